@@ -0,0 +1,587 @@
+// Package client connects to the things feed of a listofthings server and
+// keeps a replica of the things of a list.
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+var (
+	ErrClosed       = errors.New("client: closed")
+	ErrTimeout      = errors.New("client: timed out waiting for the server")
+	ErrDisconnected = errors.New("client: disconnected before the request was confirmed")
+)
+
+// Options configure the connection of a client
+type Options struct {
+	Key         string        // The session key, or token
+	Cookie      string        // The session cookie name, the key is sent as a bearer token without one
+	ListID      int64         // Zero connects to the default list
+	Origin      string        // Defaults to the server URL
+	Timeout     time.Duration // Time to wait for the server to confirm a request
+	ReadTimeout time.Duration // Time without messages before reconnecting
+	MaxBackoff  time.Duration // Longest wait between reconnects
+}
+
+var DefaultOptions = Options{
+	Timeout:     10 * time.Second,
+	ReadTimeout: 75 * time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+// Message is sent by the server. Its content depends on its resource and
+// event.
+type Message struct {
+	Resource  string          `json:"resource"`
+	Event     string          `json:"method"`
+	RequestID string          `json:"request_id,omitempty"`
+	Sequence  int64           `json:"sequence,omitempty"`
+	Cursor    string          `json:"cursor,omitempty"`
+	Content   json.RawMessage `json:"content"`
+}
+
+func (msg Message) String() string {
+	return fmt.Sprintf("%s %s: %s", msg.Event, msg.Resource, msg.Content)
+}
+
+// Error is the ERROR reply to a request
+type Error struct {
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// ConflictError is the reply to a change that was based on an outdated
+// version, with the current copy of the thing
+type ConflictError struct {
+	Current db.Thing
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf(
+		"Thing %d was changed by someone else (now version %d)",
+		e.Current.ID, e.Current.Version,
+	)
+}
+
+// Client keeps a replica of the things of a list, which is updated by
+// every change to them. It reconnects until it is closed and is sent the
+// events it missed, or the whole list again.
+type Client struct {
+	url     string
+	options Options
+	prefix  string // Of request IDs, which are seen by every client
+	events  chan Message
+	closed  chan struct{}
+	loaded  chan struct{}
+
+	mu        sync.Mutex
+	ws        *websocket.Conn // Nil while disconnected
+	connected chan struct{}   // Closed once connected
+	things    map[int64]db.Thing
+	sequence  int64
+	requests  int64
+	pending   map[string]chan Message
+	dropped   int64 // Events that were not read in time
+	dropping  bool  // Since the last event that was read
+	isLoaded  bool
+	isClosed  bool
+}
+
+// EventBuffer is the number of messages held for Events before they are
+// dropped
+const EventBuffer = 256
+
+// Events returns every message from the server after it has been applied
+// to the replica. Reading events never holds up the replica or requests,
+// so messages are dropped once EventBuffer of them are unread. Dropped
+// returns how many were.
+func (c *Client) Events() <-chan Message {
+	return c.events
+}
+
+// Dropped returns the number of messages that were dropped from Events
+// because they were not read in time
+func (c *Client) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Things returns a copy of the replica, ordered by position. Sub-items are
+// included alongside their parents rather than as children.
+func (c *Client) Things() []db.Thing {
+	c.mu.Lock()
+	things := make([]db.Thing, 0, len(c.things))
+	for _, thing := range c.things {
+		things = append(things, thing)
+	}
+	c.mu.Unlock()
+	sort.Sort(byPosition(things))
+	return things
+}
+
+// Thing returns the copy of the thing in the replica
+func (c *Client) Thing(id int64) (thing db.Thing, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	thing, ok = c.things[id]
+	return
+}
+
+type byPosition []db.Thing
+
+func (t byPosition) Len() int      { return len(t) }
+func (t byPosition) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t byPosition) Less(i, j int) bool {
+	if t[i].Position != t[j].Position {
+		return t[i].Position < t[j].Position
+	}
+	return t[i].ID < t[j].ID
+}
+
+// Create creates the thing from its content fields and returns it once the
+// server has confirmed it
+func (c *Client) Create(thing db.Thing) (db.Thing, error) {
+	return c.change("create", thing)
+}
+
+// Update sets the content of the thing, which must have the version it
+// was based on. A ConflictError is returned if it was changed since.
+func (c *Client) Update(thing db.Thing) (db.Thing, error) {
+	return c.change("update", thing)
+}
+
+// Delete moves the thing and its sub-items to the trash. The thing must
+// have the version it was based on.
+func (c *Client) Delete(thing db.Thing) (db.Thing, error) {
+	return c.change("delete", thing)
+}
+
+func (c *Client) change(method string, thing db.Thing) (db.Thing, error) {
+	reply, err := c.Request("things", method, thing)
+	if err != nil {
+		return thing, err
+	}
+	var changed db.Thing
+	err = json.Unmarshal(reply.Content, &changed)
+	return changed, err
+}
+
+// Request sends a request and waits for the server to reply to it. ERROR
+// and CONFLICT replies are returned as errors.
+func (c *Client) Request(resource, method string, content interface{}) (reply Message, err error) {
+	in := feeds.IncomingMessage{Resource: resource, Event: method}
+	if in.Content, err = json.Marshal(content); err != nil {
+		return
+	}
+	deadline := time.After(c.options.Timeout)
+	ws, err := c.connection(deadline)
+	if err != nil {
+		return
+	}
+
+	replies := make(chan Message, 1)
+	c.mu.Lock()
+	c.requests += 1
+	in.RequestID = fmt.Sprintf("%s-%d", c.prefix, c.requests)
+	c.pending[in.RequestID] = replies
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, in.RequestID)
+		c.mu.Unlock()
+	}()
+
+	if err = websocket.JSON.Send(ws, in); err != nil {
+		return
+	}
+	var ok bool
+	select {
+	case reply, ok = <-replies:
+		if !ok {
+			err = ErrDisconnected
+		}
+	case <-deadline:
+		err = ErrTimeout
+	case <-c.closed:
+		err = ErrClosed
+	}
+	if err != nil {
+		return
+	}
+
+	switch reply.Event {
+	case feeds.ERROR:
+		var content feeds.ErrorContent
+		json.Unmarshal(reply.Content, &content)
+		err = Error{Message: content.Message}
+	case feeds.CONFLICT:
+		var current db.Thing
+		json.Unmarshal(reply.Content, &current)
+		err = ConflictError{Current: current}
+	}
+	return
+}
+
+// connection waits until the client is connected
+func (c *Client) connection(deadline <-chan time.Time) (*websocket.Conn, error) {
+	for {
+		c.mu.Lock()
+		ws, connected := c.ws, c.connected
+		c.mu.Unlock()
+		if ws != nil {
+			return ws, nil
+		}
+		select {
+		case <-connected:
+		case <-deadline:
+			return nil, ErrTimeout
+		case <-c.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Close disconnects the client and stops it from reconnecting
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		return nil
+	}
+	c.isClosed = true
+	close(c.closed)
+	if c.ws != nil {
+		return c.ws.Close()
+	}
+	return nil
+}
+
+// dial connects to the feed. Reconnections ask for the events since the
+// last one seen.
+func (c *Client) dial() (*websocket.Conn, error) {
+	c.mu.Lock()
+	address := c.url
+	if c.sequence > 0 {
+		address += fmt.Sprintf("?since=%d", c.sequence)
+	}
+	c.mu.Unlock()
+
+	config, err := websocket.NewConfig(address, c.options.Origin)
+	if err != nil {
+		return nil, err
+	}
+	if c.options.Key != "" {
+		if c.options.Cookie != "" {
+			config.Header.Set("Cookie", (&http.Cookie{
+				Name:  c.options.Cookie,
+				Value: c.options.Key,
+			}).String())
+		} else {
+			config.Header.Set("Authorization", "Bearer "+c.options.Key)
+		}
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		ws.Close()
+		return nil, ErrClosed
+	}
+	c.ws = ws
+	close(c.connected)
+	return ws, nil
+}
+
+// disconnected fails the requests waiting for a reply, which may or may
+// not have been applied
+func (c *Client) disconnected() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws = nil
+	c.connected = make(chan struct{})
+	for id, replies := range c.pending {
+		close(replies)
+		delete(c.pending, id)
+	}
+}
+
+// run reads from the connection and reconnects with a growing backoff
+// until the client is closed
+func (c *Client) run(ws *websocket.Conn) {
+	for {
+		err := c.read(ws)
+		c.disconnected()
+		select {
+		case <-c.closed:
+			return
+		default:
+			log.Printf("client: disconnected: %s", err)
+		}
+
+		var wait time.Duration
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-time.After(wait):
+			}
+			if ws, err = c.dial(); err == nil {
+				break
+			} else if err == ErrClosed {
+				return
+			}
+			log.Printf("client: could not reconnect: %s", err)
+			if wait *= 2; wait < 500*time.Millisecond {
+				wait = 500 * time.Millisecond
+			}
+			if wait > c.options.MaxBackoff {
+				wait = c.options.MaxBackoff
+			}
+		}
+	}
+}
+
+// read applies every message from the connection until it fails
+func (c *Client) read(ws *websocket.Conn) error {
+	for {
+		ws.SetReadDeadline(time.Now().Add(c.options.ReadTimeout))
+		var msg Message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+		if msg.Resource == "connection" && msg.Event == feeds.PING {
+			pong := feeds.IncomingMessage{Resource: "connection", Event: feeds.PONG}
+			if err := websocket.JSON.Send(ws, pong); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.apply(msg); err != nil {
+			log.Printf("client: could not apply %s: %s", msg, err)
+		}
+		if msg.Resource == "things" && msg.Cursor != "" {
+			// Pages are requested one at a time until the list is loaded
+			more := feeds.IncomingMessage{Resource: "things", Event: "list_more"}
+			more.Content, _ = json.Marshal(feeds.More{Cursor: msg.Cursor})
+			if err := websocket.JSON.Send(ws, more); err != nil {
+				return err
+			}
+		}
+
+		c.mu.Lock()
+		replies, ok := c.pending[msg.RequestID]
+		c.mu.Unlock()
+		if ok && msg.RequestID != "" {
+			select {
+			case replies <- msg:
+			default:
+			}
+		}
+		c.emit(msg)
+	}
+}
+
+// emit sends the message to Events, or counts it as dropped if the buffer
+// is full. Only the first of consecutive drops is logged.
+func (c *Client) emit(msg Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case c.events <- msg:
+		c.dropping = false
+	default:
+		if !c.dropping {
+			log.Printf("client: events are not being read, dropped %s", msg)
+		}
+		c.dropped += 1
+		c.dropping = true
+	}
+}
+
+// apply updates the replica with a message of the things resource. Any
+// event can be applied more than once.
+func (c *Client) apply(msg Message) error {
+	if msg.Resource != "things" {
+		return nil
+	}
+	var things feeds.Things
+	var thing db.Thing
+	switch msg.Event {
+	case feeds.LIST, feeds.LIST_MORE, feeds.MOVE, feeds.CLEAR:
+		if err := json.Unmarshal(msg.Content, &things); err != nil {
+			return err
+		}
+	case feeds.CREATE, feeds.UPDATE, feeds.DELETE, feeds.RESTORE,
+		feeds.REPARENT, feeds.COLLAPSE, feeds.TOGGLE, feeds.SCHEDULE,
+		feeds.TAG, feeds.UNTAG, feeds.REMINDER, feeds.CONFLICT:
+		if err := json.Unmarshal(msg.Content, &thing); err != nil {
+			return err
+		}
+		things = feeds.Things{thing}
+	default:
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg.Sequence > c.sequence {
+		c.sequence = msg.Sequence
+	}
+	switch msg.Event {
+	case feeds.LIST:
+		c.things = make(map[int64]db.Thing)
+		c.upsert(things)
+	case feeds.DELETE, feeds.CLEAR:
+		for _, deleted := range flatten(things) {
+			delete(c.things, deleted.ID)
+		}
+	default:
+		c.upsert(things)
+	}
+	if (msg.Event == feeds.LIST || msg.Event == feeds.LIST_MORE) && msg.Cursor == "" && !c.isLoaded {
+		c.isLoaded = true
+		close(c.loaded)
+	}
+	return nil
+}
+
+// upsert saves the things and their sub-items, unless the replica has a
+// newer version
+func (c *Client) upsert(things feeds.Things) {
+	for _, thing := range flatten(things) {
+		if current, ok := c.things[thing.ID]; ok && current.Version > thing.Version {
+			continue
+		}
+		if thing.IsDeleted() {
+			delete(c.things, thing.ID)
+			continue
+		}
+		c.things[thing.ID] = thing
+	}
+}
+
+// flatten returns the things and their sub-items without children
+func flatten(things feeds.Things) feeds.Things {
+	flat := feeds.Things{}
+	for _, thing := range things {
+		children := thing.Children
+		thing.Children = nil
+		flat = append(flat, thing)
+		flat = append(flat, flatten(children)...)
+	}
+	return flat
+}
+
+// FeedURL returns the websocket URL of the things of a list. The server is
+// its HTTP or websocket URL.
+func FeedURL(server string, listID int64) (string, error) {
+	u, err := url.Parse(strings.TrimRight(server, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("client: unsupported URL scheme: %s", u.Scheme)
+	}
+	u.RawQuery = ""
+	if listID == 0 {
+		u.Path += "/feeds/v1/things"
+	} else {
+		u.Path += fmt.Sprintf("/feeds/v1/lists/%d/things", listID)
+	}
+	return u.String(), nil
+}
+
+// NewSession asks the server for a new user and returns the key of its
+// session, which can be used as the key of Options
+func NewSession(server string) (string, error) {
+	resp, err := http.Get(strings.TrimRight(server, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value != "" {
+			return cookie.Value, nil
+		}
+	}
+	return "", fmt.Errorf("client: the server did not create a session")
+}
+
+// Dial connects to the feed of the server, which is its HTTP or websocket
+// URL, and returns once the things of the list are loaded
+func Dial(server string, options Options) (*Client, error) {
+	address, err := FeedURL(server, options.ListID)
+	if err != nil {
+		return nil, err
+	}
+	if options.Origin == "" {
+		options.Origin = strings.TrimRight(server, "/")
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions.Timeout
+	}
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = DefaultOptions.ReadTimeout
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	prefix := make([]byte, 6)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		url:       address,
+		options:   options,
+		prefix:    hex.EncodeToString(prefix),
+		events:    make(chan Message, EventBuffer),
+		closed:    make(chan struct{}),
+		loaded:    make(chan struct{}),
+		connected: make(chan struct{}),
+		things:    make(map[int64]db.Thing),
+		pending:   make(map[string]chan Message),
+	}
+	ws, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	go c.run(ws)
+
+	select {
+	case <-c.loaded:
+		return c, nil
+	case <-time.After(options.Timeout):
+		c.Close()
+		return nil, ErrTimeout
+	}
+}
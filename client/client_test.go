@@ -0,0 +1,210 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/aodin/volta/config"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+	"github.com/aodin/listofthings/server/auth"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/lists"
+)
+
+// eventually fails the test if the condition is not true within a few
+// seconds
+func eventually(t *testing.T, condition func() bool, format string, args ...interface{}) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func message(event string, sequence int64, content interface{}) Message {
+	raw, _ := json.Marshal(content)
+	return Message{Resource: "things", Event: event, Sequence: sequence, Content: raw}
+}
+
+func TestDroppedEvents(t *testing.T) {
+	c := &Client{events: make(chan Message, 2)}
+	for i := 0; i < 5; i++ {
+		c.emit(Message{Resource: "things", Sequence: int64(i + 1)})
+	}
+	if dropped := c.Dropped(); dropped != 3 {
+		t.Errorf("unexpected dropped events %d, expected 3", dropped)
+	}
+	if msg := <-c.Events(); msg.Sequence != 1 {
+		t.Errorf("unexpected first event %d", msg.Sequence)
+	}
+	c.emit(Message{Resource: "things", Sequence: 6})
+	if dropped := c.Dropped(); dropped != 3 {
+		t.Errorf("an event was dropped with room in the buffer: %d", dropped)
+	}
+}
+
+func TestApply(t *testing.T) {
+	c := &Client{things: make(map[int64]db.Thing), loaded: make(chan struct{})}
+	milk := db.NewThing(1, "Milk")
+	milk.ID, milk.Version = 1, 2
+	eggs := db.NewThing(1, "Eggs")
+	eggs.ID, eggs.Version = 2, 1
+	if err := c.apply(message(feeds.LIST, 3, feeds.Things{milk, eggs})); err != nil {
+		t.Fatalf("could not apply a LIST: %s", err)
+	}
+	select {
+	case <-c.loaded:
+	default:
+		t.Error("the replica was not loaded by the last page")
+	}
+
+	// Older versions are ignored, since events can arrive more than once
+	stale := db.NewThing(1, "Soy milk")
+	stale.ID, stale.Version = 1, 1
+	c.apply(message(feeds.UPDATE, 2, stale))
+	if thing, _ := c.Thing(1); thing.Version != 2 || thing.String() != "Milk" {
+		t.Errorf("a stale update was applied: %+v", thing)
+	}
+
+	c.apply(message(feeds.DELETE, 4, eggs))
+	if _, ok := c.Thing(2); ok {
+		t.Error("a deleted thing is still in the replica")
+	}
+	if c.sequence != 4 {
+		t.Errorf("unexpected sequence %d, expected 4", c.sequence)
+	}
+}
+
+func TestClient(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+
+	conf := config.Default
+	sessions := auth.Sessions(conf, conn)
+	hub := feeds.NewHub(
+		conf, feeds.DefaultSettings, conn, sessions, lists.Lists(conn),
+		feeds.NewLocalBroadcaster(), nil, nil, nil,
+	)
+
+	// The feed refuses new connections while blocked
+	var mu sync.Mutex
+	var blocked bool
+	feed := websocket.Handler(hub.ListHandler)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		refuse := blocked
+		mu.Unlock()
+		if refuse {
+			http.Error(w, "blocked", http.StatusServiceUnavailable)
+			return
+		}
+		feed.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	block := func(b bool) {
+		mu.Lock()
+		blocked = b
+		mu.Unlock()
+	}
+
+	dial := func(email string) *Client {
+		session := sessions.Create(dbtest.User(t, conn, email))
+		c, err := Dial(srv.URL, Options{Key: session.Key, ListID: list.ID})
+		if err != nil {
+			t.Fatalf("could not dial the feed: %s", err)
+		}
+		return c
+	}
+	a := dial("a@example.com")
+	defer a.Close()
+	b := dial("b@example.com")
+	defer b.Close()
+
+	// Concurrent requests are each sent their own reply
+	names := []string{"Milk", "Eggs", "Bread", "Butter", "Jam"}
+	created := make([]db.Thing, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			thing, err := a.Create(db.NewThing(list.ID, name))
+			if err != nil {
+				t.Errorf("could not create %s: %s", name, err)
+			}
+			created[i] = thing
+		}(i, name)
+	}
+	wg.Wait()
+	for i, thing := range created {
+		if thing.String() != names[i] {
+			t.Errorf("the create of %s was replied with %+v", names[i], thing)
+		}
+		if _, ok := a.Thing(thing.ID); !ok {
+			t.Errorf("%s is not in the replica of its creator", names[i])
+		}
+	}
+	eventually(t, func() bool { return len(b.Things()) == len(names) },
+		"the other replica has %d of %d things", len(b.Things()), len(names))
+
+	// Stale changes are conflicts with the current thing
+	milk := created[0]
+	milk.SetFields(map[string]interface{}{"name": "Oat milk"})
+	if milk, err := a.Update(milk); err != nil || milk.Version != 2 {
+		t.Fatalf("could not update a thing: %+v %v", milk, err)
+	}
+	stale := created[0]
+	stale.SetFields(map[string]interface{}{"name": "Soy milk"})
+	if _, err := b.Update(stale); err == nil {
+		t.Error("a stale update did not conflict")
+	} else if conflict, ok := err.(ConflictError); !ok || conflict.Current.Version != 2 {
+		t.Errorf("unexpected error of a stale update: %v", err)
+	}
+
+	// Disconnected clients are sent the events they missed
+	for len(a.Events()) > 0 {
+		<-a.Events()
+	}
+	block(true)
+	a.mu.Lock()
+	a.ws.Close()
+	a.mu.Unlock()
+	eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.ws == nil
+	}, "the client did not disconnect")
+
+	tea, err := b.Create(db.NewThing(list.ID, "Tea"))
+	if err != nil {
+		t.Fatalf("could not create a thing while disconnected: %s", err)
+	}
+	block(false)
+	eventually(t, func() bool {
+		_, ok := a.Thing(tea.ID)
+		return ok
+	}, "the missed create was not replayed")
+
+	for len(a.Events()) > 0 {
+		if msg := <-a.Events(); msg.Resource == "things" && msg.Event == feeds.LIST {
+			t.Errorf("the list was sent again instead of the missed events")
+		}
+	}
+	if things := a.Things(); len(things) != len(names)+1 {
+		t.Errorf("unexpected replica of %d things", len(things))
+	}
+	if a.Dropped() != 0 || b.Dropped() != 0 {
+		t.Errorf("events were dropped: %d and %d", a.Dropped(), b.Dropped())
+	}
+}
@@ -13,9 +13,20 @@ var applications = map[string][]*sql.TableElem{
 	"auth": {
 		db.Users,
 		db.Sessions,
+		db.Digests,
 	},
 	"things": {
+		db.Lists,
 		db.Things,
+		db.Events,
+		db.Tags,
+		db.ThingTags,
+		db.Comments,
+		db.Attachments,
+		db.Revisions,
+		db.Webhooks,
+		db.Deliveries,
+		db.Attempts,
 	},
 }
 
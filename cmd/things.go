@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aodin/listofthings/client"
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// Remote manages the things of a list on a running server through its
+// feed. Output is a table, or JSON if set.
+type Remote struct {
+	Server string
+	Token  string // A new session is created without one
+	ListID int64  // Zero is the default list
+	JSON   bool
+	Out    io.Writer
+}
+
+// ParseID returns the ID of a thing given as the first argument and the
+// arguments after it
+func ParseID(args []string) (int64, []string, error) {
+	if len(args) == 0 {
+		return 0, nil, errors.New("The ID of a thing is required")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, nil, fmt.Errorf("%q is not the ID of a thing", args[0])
+	}
+	return id, args[1:], nil
+}
+
+// ParseName returns the name of a thing given as the arguments, so names
+// with spaces do not need quotes
+func ParseName(args []string) (string, error) {
+	name := strings.TrimSpace(strings.Join(args, " "))
+	if name == "" {
+		return "", errors.New("The name of a thing is required")
+	}
+	return name, nil
+}
+
+func (r Remote) dial() (*client.Client, error) {
+	if r.Token == "" {
+		token, err := client.NewSession(r.Server)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Created a new session, set LISTOFTHINGS_TOKEN=%s to reuse it\n", token)
+		r.Token = token
+	}
+	return client.Dial(r.Server, client.Options{Key: r.Token, ListID: r.ListID})
+}
+
+// List prints every thing of the list
+func (r Remote) List() error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return r.print(c.Things()...)
+}
+
+// Add creates a thing with the given name and prints it
+func (r Remote) Add(name string) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	thing, err := c.Create(db.NewThing(r.ListID, name))
+	if err != nil {
+		return err
+	}
+	return r.print(thing)
+}
+
+// Rename sets the name of a thing and prints it
+func (r Remote) Rename(id int64, name string) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	thing, ok := c.Thing(id)
+	if !ok {
+		return feeds.ErrNoThing
+	}
+	fields := map[string]interface{}{}
+	for key, value := range thing.Fields {
+		fields[key] = value
+	}
+	fields["name"] = name
+	if err = thing.SetFields(fields); err != nil {
+		return err
+	}
+	if thing, err = c.Update(thing); err != nil {
+		return err
+	}
+	return r.print(thing)
+}
+
+// Remove moves a thing and its sub-items to the trash and prints it
+func (r Remote) Remove(id int64) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	thing, ok := c.Thing(id)
+	if !ok {
+		return feeds.ErrNoThing
+	}
+	if thing, err = c.Delete(thing); err != nil {
+		return err
+	}
+	return r.print(thing)
+}
+
+// Watch prints every change to the list until the process is stopped.
+// JSON output prints each message on its own line.
+func (r Remote) Watch() error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	encoder := json.NewEncoder(r.Out)
+	for msg := range c.Events() {
+		if r.JSON {
+			if err := encoder.Encode(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		switch msg.Resource {
+		case "things":
+			if msg.Event == feeds.LIST || msg.Event == feeds.LIST_MORE {
+				continue // The things loaded when connecting
+			}
+			var things feeds.Things
+			var thing db.Thing
+			if err := json.Unmarshal(msg.Content, &thing); err == nil {
+				things = feeds.Things{thing}
+			} else if err := json.Unmarshal(msg.Content, &things); err != nil {
+				continue
+			}
+			for _, thing := range things {
+				fmt.Fprintf(r.Out, "%s\t%d\t%s\n", msg.Event, thing.ID, thing)
+			}
+		case "users", "connection":
+			// Presence and heartbeats are not changes
+		default:
+			fmt.Fprintf(r.Out, "%s\t%s\t%s\n", msg.Event, msg.Resource, msg.Content)
+		}
+	}
+	return nil
+}
+
+// print writes the things as a table, or as JSON
+func (r Remote) print(things ...db.Thing) error {
+	if r.JSON {
+		encoder := json.NewEncoder(r.Out)
+		if len(things) == 1 {
+			return encoder.Encode(things[0])
+		}
+		return encoder.Encode(things)
+	}
+	w := tabwriter.NewWriter(r.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPARENT\tDONE\tTAGS\tNAME")
+	for _, thing := range things {
+		parent := "-"
+		if thing.ParentID != nil {
+			parent = fmt.Sprint(*thing.ParentID)
+		}
+		done := " "
+		if thing.Completed {
+			done = "x"
+		}
+		fmt.Fprintf(
+			w, "%d\t%s\t%s\t%s\t%s\n",
+			thing.ID, parent, done, strings.Join(thing.Tags, ","), thing,
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+func TestParseID(t *testing.T) {
+	id, rest, err := ParseID([]string{"42", "Oat", "milk"})
+	if err != nil || id != 42 || fmt.Sprint(rest) != "[Oat milk]" {
+		t.Errorf("unexpected ID %d and arguments %v: %v", id, rest, err)
+	}
+	for _, args := range [][]string{nil, {"milk"}, {"0"}, {"-1"}, {"4.2"}} {
+		if _, _, err := ParseID(args); err == nil {
+			t.Errorf("the arguments %q were parsed as an ID", args)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	if name, err := ParseName([]string{"Oat", "milk "}); err != nil || name != "Oat milk" {
+		t.Errorf("unexpected name %q: %v", name, err)
+	}
+	if name, err := ParseName([]string{"Oat milk"}); err != nil || name != "Oat milk" {
+		t.Errorf("unexpected quoted name %q: %v", name, err)
+	}
+	for _, args := range [][]string{nil, {""}, {" ", "\t"}} {
+		if _, err := ParseName(args); err == nil {
+			t.Errorf("the arguments %q were parsed as a name", args)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	parentID := int64(1)
+	milk := db.NewThing(1, "Milk")
+	milk.ID, milk.ParentID, milk.Completed, milk.Tags = 2, &parentID, true, []string{"dairy", "cold"}
+
+	var out bytes.Buffer
+	if err := (Remote{Out: &out}).print(milk); err != nil {
+		t.Fatalf("could not print a table: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "2 1 x dairy,cold Milk" {
+		t.Errorf("unexpected table %q", out.String())
+	}
+
+	out.Reset()
+	if err := (Remote{Out: &out, JSON: true}).print(milk, milk); err != nil {
+		t.Fatalf("could not print JSON: %s", err)
+	}
+	var printed []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil || len(printed) != 2 || printed[0]["name"] != "Milk" {
+		t.Errorf("unexpected JSON %s: %v", out.String(), err)
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Attachment is a file uploaded to a thing. Its paths are relative to the
+// media directory.
+type Attachment struct {
+	ID           int64     `db:"id,omitempty" json:"id"`
+	ThingID      int64     `db:"thing_id" json:"thing_id"`
+	UserID       int64     `db:"user_id" json:"user_id"`
+	Name         string    `db:"name" json:"name"` // The uploaded file name
+	ContentType  string    `db:"content_type" json:"content_type"`
+	Size         int64     `db:"size" json:"size"`
+	Path         string    `db:"path" json:"-"`
+	Thumbnail    string    `db:"thumbnail" json:"-"` // Empty unless an image
+	URL          string    `db:"-" json:"url"`
+	ThumbnailURL string    `db:"-" json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+func (attachment Attachment) Exists() bool {
+	return attachment.ID != 0
+}
+
+func (attachment Attachment) String() string {
+	return attachment.Name
+}
+
+// IsImage returns true if the attachment can be shown inline
+func (attachment Attachment) IsImage() bool {
+	return attachment.Thumbnail != ""
+}
+
+var Attachments = sql.Table("attachments",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"thing_id",
+		Things.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.ForeignKey(
+		"user_id",
+		Users.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("name", sql.String{Length: 256, NotNull: true}),
+	sql.Column("content_type", sql.String{Length: 128, NotNull: true}),
+	sql.Column("size", sql.Integer{NotNull: true}),
+	sql.Column("path", sql.String{Length: 512, NotNull: true}),
+	sql.Column("thumbnail", sql.String{Length: 512, NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+	sql.Unique("path"),
+)
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Broadcast is an envelope between servers that was too large to send as a
+// notification, so the notification refers to it instead. Every server
+// reads it, so it is only removed once it expires.
+type Broadcast struct {
+	ID        int64     `db:"id,omitempty" json:"id"`
+	Payload   string    `db:"payload" json:"payload"`
+	CreatedAt time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+var Broadcasts = sql.Table("broadcasts",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.Column("payload", sql.Text{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+)
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+const MaxCommentLength = 4096
+
+// Comment is a remark made by a user about a thing
+type Comment struct {
+	ID        int64      `db:"id,omitempty" json:"id"`
+	ThingID   int64      `db:"thing_id" json:"thing_id"`
+	UserID    int64      `db:"user_id" json:"user_id"`
+	Author    string     `db:"-" json:"author"` // The name of the user
+	Body      string     `db:"body" json:"body"`
+	CreatedAt time.Time  `db:"created_at,omitempty" json:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+}
+
+func (comment Comment) String() string {
+	return comment.Body
+}
+
+func (comment Comment) Error() error {
+	if strings.TrimSpace(comment.Body) == "" {
+		return fmt.Errorf("Comments cannot be blank")
+	}
+	if len(comment.Body) > MaxCommentLength {
+		return fmt.Errorf(
+			"Comments cannot be longer than %d characters",
+			MaxCommentLength,
+		)
+	}
+	return nil
+}
+
+func NewComment(thingID int64, user User, body string) Comment {
+	return Comment{
+		ThingID: thingID,
+		UserID:  user.ID,
+		Author:  user.Name,
+		Body:    body,
+	}
+}
+
+var Comments = sql.Table("comments",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"thing_id",
+		Things.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.ForeignKey(
+		"user_id",
+		Users.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("body", sql.Text{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.Column("updated_at", sql.Timestamp{}),
+	sql.PrimaryKey("id"),
+)
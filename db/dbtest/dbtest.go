@@ -0,0 +1,94 @@
+// Package dbtest connects tests to a Postgres database. Tests that need a
+// database are skipped unless LISTOFTHINGS_TEST_DB is set to the
+// credentials of one, such as "host=localhost dbname=things_test
+// sslmode=disable".
+package dbtest
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+	_ "github.com/lib/pq"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Tables are created in this order, so references exist first
+var Tables = []*sql.TableElem{
+	db.Users,
+	db.Sessions,
+	db.Digests,
+	db.Lists,
+	db.Members,
+	db.Things,
+	db.Events,
+	db.Tags,
+	db.ThingTags,
+	db.Comments,
+	db.Attachments,
+	db.Revisions,
+	db.Webhooks,
+	db.Deliveries,
+	db.Attempts,
+	db.Broadcasts,
+}
+
+// raw is a statement without parameters
+type raw string
+
+func (stmt raw) String() string {
+	return string(stmt)
+}
+
+func (stmt raw) Compile(d sql.Dialect, params *sql.Parameters) (string, error) {
+	return string(stmt), nil
+}
+
+// Connect connects to the test database and creates any missing tables.
+// The test is skipped if no database is configured.
+func Connect(t *testing.T) *sql.DB {
+	credentials := os.Getenv("LISTOFTHINGS_TEST_DB")
+	if credentials == "" {
+		t.Skip("LISTOFTHINGS_TEST_DB is not set")
+	}
+	conn, err := sql.Connect("postgres", credentials)
+	if err != nil {
+		t.Fatalf("dbtest: could not connect: %s", err)
+	}
+	for _, table := range Tables {
+		create := strings.Replace(
+			table.Create().String(), "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1,
+		)
+		if _, err := conn.Execute(raw(create)); err != nil {
+			conn.Close()
+			t.Fatalf("dbtest: could not create %s: %s", table.Name(), err)
+		}
+	}
+	return conn
+}
+
+// List creates a list. Removing it also removes its things and events.
+func List(t *testing.T, conn sql.Connection) (list db.List, remove func()) {
+	list = db.NewList("Test List")
+	stmt := pg.Insert(db.Lists).Values(list).Returning(db.Lists)
+	if err := conn.QueryOne(stmt, &list); err != nil {
+		t.Fatalf("dbtest: could not create a list: %s", err)
+	}
+	remove = func() {
+		conn.Execute(db.Lists.Delete().Where(db.Lists.C["id"].Equals(list.ID)))
+	}
+	return
+}
+
+// User creates a user with the given email
+func User(t *testing.T, conn sql.Connection, email string) db.User {
+	user := db.NewUser("Test User", email)
+	stmt := pg.Insert(db.Users).Values(user).Returning(db.Users)
+	if err := conn.QueryOne(stmt, &user); err != nil {
+		t.Fatalf("dbtest: could not create a user: %s", err)
+	}
+	return user
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Digest frequencies
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// Digest is a user's choice to be emailed a summary of list activity. The
+// token allows the user to unsubscribe without logging in. A new email
+// address is pending until the link sent to it with the verify token is
+// followed.
+type Digest struct {
+	UserID       int64      `db:"user_id" json:"user_id"`
+	Frequency    string     `db:"frequency" json:"frequency"`
+	Token        string     `db:"token" json:"-"`
+	PendingEmail string     `db:"pending_email" json:"pending_email"`
+	VerifyToken  string     `db:"verify_token" json:"-"`
+	SentAt       *time.Time `db:"sent_at" json:"sent_at"`             // Nil until the first digest
+	ClaimedUntil *time.Time `db:"claimed_until" json:"claimed_until"` // Set while a digest is sent
+	CreatedAt    time.Time  `db:"created_at,omitempty" json:"created_at"`
+}
+
+func (digest Digest) Exists() bool {
+	return digest.UserID != 0
+}
+
+func (digest Digest) Error() error {
+	if digest.Frequency != Daily && digest.Frequency != Weekly {
+		return fmt.Errorf("Digests must be %s or %s", Daily, Weekly)
+	}
+	return nil
+}
+
+// Period is the time between digests
+func (digest Digest) Period() time.Duration {
+	if digest.Frequency == Weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Since returns the start of the activity of the next digest
+func (digest Digest) Since() time.Time {
+	if digest.SentAt != nil {
+		return *digest.SentAt
+	}
+	return digest.CreatedAt
+}
+
+var Digests = sql.Table("digests",
+	sql.ForeignKey(
+		"user_id",
+		Users.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("frequency", sql.String{Length: 16, NotNull: true}),
+	sql.Column("token", sql.String{Length: 64, NotNull: true}),
+	sql.Column("pending_email", sql.String{Length: 256, NotNull: true}),
+	sql.Column("verify_token", sql.String{Length: 64, NotNull: true}),
+	sql.Column("sent_at", sql.Timestamp{}),
+	sql.Column("claimed_until", sql.Timestamp{}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("user_id"),
+	sql.Unique("token"),
+)
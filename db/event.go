@@ -0,0 +1,36 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Event is a change to a list that was broadcast to its users. Events are
+// numbered by a sequence of their list.
+type Event struct {
+	ID        int64     `db:"id,omitempty" json:"id"`
+	ListID    int64     `db:"list_id" json:"list_id"`
+	Sequence  int64     `db:"sequence" json:"sequence"`
+	Resource  string    `db:"resource" json:"resource"`
+	Event     string    `db:"event" json:"event"`
+	Content   string    `db:"content" json:"content"`
+	CreatedAt time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+var Events = sql.Table("events",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"list_id",
+		Lists.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("sequence", sql.BigInt{NotNull: true}),
+	sql.Column("resource", sql.String{Length: 64, NotNull: true}),
+	sql.Column("event", sql.String{Length: 64, NotNull: true}),
+	sql.Column("content", pg.JSON{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+	sql.Unique("list_id", "sequence"),
+)
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"fmt"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	"github.com/aodin/listofthings/db/fields"
+)
+
+// List is a named collection of things
+type List struct {
+	ID   int64  `db:"id,omitempty" json:"id"`
+	Name string `db:"name" json:"name"`
+	fields.Timestamp
+}
+
+func (list List) Exists() bool {
+	return list.ID != 0
+}
+
+func (list List) String() string {
+	return list.Name
+}
+
+func (list List) Error() error {
+	if list.Name == "" {
+		return fmt.Errorf("List names cannot be blank")
+	}
+	if len(list.Name) > MaxNameLength {
+		return fmt.Errorf(
+			"List names cannot be longer than %d characters",
+			MaxNameLength,
+		)
+	}
+	return nil
+}
+
+func NewList(name string) List {
+	return List{Name: name}
+}
+
+var Lists = sql.Table("lists",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.Column("name", sql.String{Length: 256, NotNull: true}),
+	sql.Column("seq", sql.BigInt{}), // The sequence of the last event
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.Column("updated_at", sql.Timestamp{}),
+	sql.Column("deleted_at", sql.Timestamp{}),
+	sql.PrimaryKey("id"),
+)
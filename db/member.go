@@ -0,0 +1,39 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Member is a user who has opened a list. The user who created the list,
+// or the first to open a list created without one, is its owner. Any user
+// can open any list, so membership records who took part in a list rather
+// than who may see it.
+type Member struct {
+	ListID    int64     `db:"list_id" json:"list_id"`
+	UserID    int64     `db:"user_id" json:"user_id"`
+	Owner     bool      `db:"owner" json:"owner"`
+	CreatedAt time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+func (member Member) Exists() bool {
+	return member.ListID != 0
+}
+
+var Members = sql.Table("members",
+	sql.ForeignKey(
+		"list_id",
+		Lists.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.ForeignKey(
+		"user_id",
+		Users.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("owner", sql.Boolean{NotNull: true, Default: sql.False}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("list_id", "user_id"),
+)
@@ -0,0 +1,23 @@
+-- Split things into multiple lists
+
+-- +goose Up
+
+CREATE TABLE "lists" (
+  "id" SERIAL NOT NULL,
+  "name" VARCHAR(256) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  "updated_at" TIMESTAMP,
+  "deleted_at" TIMESTAMP,
+  PRIMARY KEY ("id")
+);
+
+-- Existing things belong to a default list
+INSERT INTO "lists" ("name") VALUES ('List of Things');
+
+ALTER TABLE "things" ADD COLUMN "list_id" INTEGER REFERENCES lists("id") ON DELETE CASCADE;
+UPDATE "things" SET "list_id" = (SELECT MIN("id") FROM "lists");
+ALTER TABLE "things" ALTER COLUMN "list_id" SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE "things" DROP COLUMN IF EXISTS "list_id";
+DROP TABLE IF EXISTS "lists";
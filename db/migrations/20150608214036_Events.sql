@@ -0,0 +1,18 @@
+-- Log the events of each list so reconnecting clients can replay them
+
+-- +goose Up
+
+CREATE TABLE "events" (
+  "id" SERIAL NOT NULL,
+  "list_id" INTEGER NOT NULL REFERENCES lists("id") ON DELETE CASCADE,
+  "resource" VARCHAR(64) NOT NULL,
+  "event" VARCHAR(64) NOT NULL,
+  "content" JSON NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "events_list_id" ON "events" ("list_id", "id");
+
+-- +goose Down
+DROP TABLE IF EXISTS "events";
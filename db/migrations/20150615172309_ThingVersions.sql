@@ -0,0 +1,7 @@
+-- Version things so that stale updates can be rejected
+
+-- +goose Up
+ALTER TABLE "things" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE "things" DROP COLUMN IF EXISTS "version";
@@ -0,0 +1,10 @@
+-- Order things by a position that users can change
+
+-- +goose Up
+ALTER TABLE "things" ADD COLUMN "position" DOUBLE PRECISION NOT NULL DEFAULT 0;
+UPDATE "things" SET "position" = "id" * 1024;
+CREATE INDEX "things_list_id_position" ON "things" ("list_id", "position");
+
+-- +goose Down
+DROP INDEX IF EXISTS "things_list_id_position";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "position";
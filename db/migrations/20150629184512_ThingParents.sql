@@ -0,0 +1,11 @@
+-- Things can be nested under another thing of the same list
+
+-- +goose Up
+ALTER TABLE "things" ADD COLUMN "parent_id" INTEGER REFERENCES "things" ("id") ON DELETE CASCADE;
+ALTER TABLE "things" ADD COLUMN "collapsed" BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX "things_parent_id" ON "things" ("parent_id");
+
+-- +goose Down
+DROP INDEX IF EXISTS "things_parent_id";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "collapsed";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "parent_id";
@@ -0,0 +1,11 @@
+-- Things can be completed, which records when and by whom
+
+-- +goose Up
+ALTER TABLE "things" ADD COLUMN "completed" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "things" ADD COLUMN "completed_at" TIMESTAMP;
+ALTER TABLE "things" ADD COLUMN "completed_by" INTEGER REFERENCES "users" ("id") ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE "things" DROP COLUMN IF EXISTS "completed_by";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "completed_at";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "completed";
@@ -0,0 +1,21 @@
+-- Things can be labeled with tags shared by their list
+
+-- +goose Up
+
+CREATE TABLE "tags" (
+  "id" SERIAL NOT NULL,
+  "list_id" INTEGER NOT NULL REFERENCES lists("id") ON DELETE CASCADE,
+  "name" VARCHAR(64) NOT NULL,
+  PRIMARY KEY ("id"),
+  UNIQUE ("list_id", "name")
+);
+
+CREATE TABLE "thing_tags" (
+  "thing_id" INTEGER NOT NULL REFERENCES things("id") ON DELETE CASCADE,
+  "tag_id" INTEGER NOT NULL REFERENCES tags("id") ON DELETE CASCADE,
+  PRIMARY KEY ("thing_id", "tag_id")
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS "thing_tags";
+DROP TABLE IF EXISTS "tags";
@@ -0,0 +1,18 @@
+-- Users can comment on things
+
+-- +goose Up
+
+CREATE TABLE "comments" (
+  "id" SERIAL NOT NULL,
+  "thing_id" INTEGER NOT NULL REFERENCES things("id") ON DELETE CASCADE,
+  "user_id" INTEGER NOT NULL REFERENCES users("id") ON DELETE CASCADE,
+  "body" TEXT NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  "updated_at" TIMESTAMP,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "comments_thing_id" ON "comments" ("thing_id");
+
+-- +goose Down
+DROP TABLE IF EXISTS "comments";
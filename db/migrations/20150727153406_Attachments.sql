@@ -0,0 +1,22 @@
+-- Files can be attached to things
+
+-- +goose Up
+
+CREATE TABLE "attachments" (
+  "id" SERIAL NOT NULL,
+  "thing_id" INTEGER NOT NULL REFERENCES things("id") ON DELETE CASCADE,
+  "user_id" INTEGER NOT NULL REFERENCES users("id") ON DELETE CASCADE,
+  "name" VARCHAR(256) NOT NULL,
+  "content_type" VARCHAR(128) NOT NULL,
+  "size" INTEGER NOT NULL,
+  "path" VARCHAR(512) NOT NULL,
+  "thumbnail" VARCHAR(512) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id"),
+  UNIQUE ("path")
+);
+
+CREATE INDEX "attachments_thing_id" ON "attachments" ("thing_id");
+
+-- +goose Down
+DROP TABLE IF EXISTS "attachments";
@@ -0,0 +1,23 @@
+-- Every change to the content of a thing is kept as a revision
+
+-- +goose Up
+
+CREATE TABLE "revisions" (
+  "id" SERIAL NOT NULL,
+  "thing_id" INTEGER NOT NULL REFERENCES things("id") ON DELETE CASCADE,
+  "version" INTEGER NOT NULL,
+  "user_id" INTEGER REFERENCES users("id") ON DELETE SET NULL,
+  "action" VARCHAR(32) NOT NULL,
+  "content" JSON NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "revisions_thing_id" ON "revisions" ("thing_id", "id");
+
+-- The history of existing things starts from their current content
+INSERT INTO "revisions" ("thing_id", "version", "action", "content", "created_at")
+  SELECT "id", "version", 'snapshot', "content", COALESCE("updated_at", "created_at") FROM "things";
+
+-- +goose Down
+DROP TABLE IF EXISTS "revisions";
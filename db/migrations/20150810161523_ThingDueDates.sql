@@ -0,0 +1,13 @@
+-- Things can be due at a time, when their assignee is reminded
+
+-- +goose Up
+ALTER TABLE "things" ADD COLUMN "due_at" TIMESTAMP;
+ALTER TABLE "things" ADD COLUMN "assignee_id" INTEGER REFERENCES "users" ("id") ON DELETE SET NULL;
+ALTER TABLE "things" ADD COLUMN "reminded_at" TIMESTAMP;
+CREATE INDEX "things_due_at" ON "things" ("due_at") WHERE "reminded_at" IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS "things_due_at";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "reminded_at";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "assignee_id";
+ALTER TABLE "things" DROP COLUMN IF EXISTS "due_at";
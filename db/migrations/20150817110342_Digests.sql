@@ -0,0 +1,16 @@
+-- Users can opt in to daily or weekly digest emails
+
+-- +goose Up
+
+CREATE TABLE "digests" (
+  "user_id" INTEGER NOT NULL REFERENCES users("id") ON DELETE CASCADE,
+  "frequency" VARCHAR(16) NOT NULL,
+  "token" VARCHAR(64) NOT NULL,
+  "sent_at" TIMESTAMP,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("user_id"),
+  UNIQUE ("token")
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS "digests";
@@ -0,0 +1,44 @@
+-- Lists can post their thing events to webhooks, which keep a log of
+-- every delivery and its attempts
+
+-- +goose Up
+
+CREATE TABLE "webhooks" (
+  "id" SERIAL NOT NULL,
+  "list_id" INTEGER NOT NULL REFERENCES lists("id") ON DELETE CASCADE,
+  "url" VARCHAR(512) NOT NULL,
+  "events" VARCHAR(256) NOT NULL,
+  "secret" VARCHAR(128) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id")
+);
+
+CREATE TABLE "webhook_deliveries" (
+  "id" SERIAL NOT NULL,
+  "webhook_id" INTEGER NOT NULL REFERENCES webhooks("id") ON DELETE CASCADE,
+  "event" VARCHAR(64) NOT NULL,
+  "payload" JSON NOT NULL,
+  "state" VARCHAR(16) NOT NULL,
+  "attempts" INTEGER NOT NULL,
+  "next_at" TIMESTAMP NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  "delivered_at" TIMESTAMP,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "webhook_deliveries_state_next_at" ON "webhook_deliveries" ("state", "next_at");
+
+CREATE TABLE "webhook_attempts" (
+  "id" SERIAL NOT NULL,
+  "delivery_id" INTEGER NOT NULL REFERENCES webhook_deliveries("id") ON DELETE CASCADE,
+  "status" INTEGER NOT NULL,
+  "error" TEXT NOT NULL,
+  "duration" INTEGER NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id")
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS "webhook_attempts";
+DROP TABLE IF EXISTS "webhook_deliveries";
+DROP TABLE IF EXISTS "webhooks";
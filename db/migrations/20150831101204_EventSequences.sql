@@ -0,0 +1,22 @@
+-- Number events by list, in the order their transactions commit
+
+-- +goose Up
+
+ALTER TABLE "lists" ADD COLUMN "seq" BIGINT;
+ALTER TABLE "events" ADD COLUMN "sequence" BIGINT;
+
+-- Clients may reconnect with the sequence of an existing event
+UPDATE "events" SET "sequence" = "id";
+UPDATE "lists" SET "seq" = (
+  SELECT MAX("id") FROM "events" WHERE "events"."list_id" = "lists"."id"
+);
+ALTER TABLE "events" ALTER COLUMN "sequence" SET NOT NULL;
+
+DROP INDEX IF EXISTS "events_list_id";
+ALTER TABLE "events" ADD CONSTRAINT "events_list_id_sequence_key" UNIQUE ("list_id", "sequence");
+
+-- +goose Down
+ALTER TABLE "events" DROP CONSTRAINT IF EXISTS "events_list_id_sequence_key";
+CREATE INDEX "events_list_id" ON "events" ("list_id", "id");
+ALTER TABLE "events" DROP COLUMN IF EXISTS "sequence";
+ALTER TABLE "lists" DROP COLUMN IF EXISTS "seq";
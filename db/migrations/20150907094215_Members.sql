@@ -0,0 +1,35 @@
+-- Lists are owned by the users who created them and record the users who
+-- opened them
+
+-- +goose Up
+
+CREATE TABLE "members" (
+  "list_id" INTEGER NOT NULL REFERENCES lists("id") ON DELETE CASCADE,
+  "user_id" INTEGER NOT NULL REFERENCES users("id") ON DELETE CASCADE,
+  "owner" BOOLEAN NOT NULL DEFAULT FALSE,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("list_id", "user_id")
+);
+
+-- Lists have at most one owner
+CREATE UNIQUE INDEX "members_owner" ON "members" ("list_id") WHERE "owner";
+
+-- Existing lists are joined by the users who changed their things, and
+-- owned by whoever changed them first. Lists without any changes, such as
+-- an unused default list, are owned by the first user to open them.
+INSERT INTO "members" ("list_id", "user_id", "created_at")
+SELECT "things"."list_id", "revisions"."user_id", MIN("revisions"."created_at")
+FROM "revisions"
+JOIN "things" ON "things"."id" = "revisions"."thing_id"
+WHERE "revisions"."user_id" IS NOT NULL
+GROUP BY "things"."list_id", "revisions"."user_id";
+
+UPDATE "members" SET "owner" = TRUE
+WHERE ("list_id", "user_id") IN (
+  SELECT DISTINCT ON ("list_id") "list_id", "user_id"
+  FROM "members"
+  ORDER BY "list_id", "created_at", "user_id"
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS "members";
@@ -0,0 +1,13 @@
+-- Digest emails are verified before they are used, and digests are claimed
+-- by a server while they are sent
+
+-- +goose Up
+
+ALTER TABLE "digests" ADD COLUMN "pending_email" VARCHAR(256) NOT NULL DEFAULT '';
+ALTER TABLE "digests" ADD COLUMN "verify_token" VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE "digests" ADD COLUMN "claimed_until" TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE "digests" DROP COLUMN IF EXISTS "claimed_until";
+ALTER TABLE "digests" DROP COLUMN IF EXISTS "verify_token";
+ALTER TABLE "digests" DROP COLUMN IF EXISTS "pending_email";
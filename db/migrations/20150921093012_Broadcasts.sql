@@ -0,0 +1,15 @@
+-- Envelopes too large for a notification are sent by reference
+
+-- +goose Up
+
+CREATE TABLE "broadcasts" (
+  "id" SERIAL NOT NULL,
+  "payload" TEXT NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT (now() at time zone 'utc'),
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "broadcasts_created_at" ON "broadcasts" ("created_at");
+
+-- +goose Down
+DROP TABLE IF EXISTS "broadcasts";
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Revision is the content of a thing after a change, along with who made
+// the change and when
+type Revision struct {
+	ID        int64     `db:"id,omitempty" json:"id"`
+	ThingID   int64     `db:"thing_id" json:"thing_id"`
+	Version   int64     `db:"version" json:"version"`
+	UserID    *int64    `db:"user_id" json:"user_id"` // Nil if the user was deleted
+	Action    string    `db:"action" json:"action"`
+	Content   string    `db:"content" json:"-"`
+	CreatedAt time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+// NewRevision creates a revision of the thing's current content
+func NewRevision(thing Thing, user User, action string) Revision {
+	revision := Revision{
+		ThingID: thing.ID,
+		Version: thing.Version,
+		Action:  action,
+		Content: thing.Content,
+	}
+	if user.Exists() {
+		revision.UserID = &user.ID
+	}
+	return revision
+}
+
+var Revisions = sql.Table("revisions",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"thing_id",
+		Things.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("version", sql.Integer{NotNull: true}),
+	sql.ForeignKey(
+		"user_id",
+		Users.C["id"],
+		sql.Integer{},
+	).OnDelete(sql.SetNull),
+	sql.Column("action", sql.String{Length: 32, NotNull: true}),
+	sql.Column("content", pg.JSON{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+)
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// The types a field of thing content can have
+const (
+	String  = "string"
+	Number  = "number"
+	Boolean = "boolean"
+)
+
+// Field declares a single field of thing content
+type Field struct {
+	Type      string `json:"type"`
+	Required  bool   `json:"required"`
+	MaxLength int    `json:"max_length"` // Zero is unlimited, only for strings
+}
+
+// Schema declares the fields that thing content may have. Unknown fields
+// are stripped before content is saved, or rejected if the schema is strict.
+type Schema struct {
+	Fields map[string]Field `json:"fields"`
+	Strict bool             `json:"strict"`
+}
+
+// DefaultSchema only allows things to have a name
+var DefaultSchema = Schema{
+	Fields: map[string]Field{
+		"name": {Type: String, Required: true, MaxLength: MaxNameLength},
+	},
+}
+
+// systemFields are set by the server and are never saved as content
+var systemFields = map[string]bool{
+	"id":            true,
+	"list_id":       true,
+	"version":       true,
+	"position":      true,
+	"parent_id":     true,
+	"collapsed":     true,
+	"completed":     true,
+	"completed_at":  true,
+	"completed_by":  true,
+	"due_at":        true,
+	"assignee_id":   true,
+	"reminded_at":   true,
+	"children":      true,
+	"tags":          true,
+	"comment_count": true,
+	"created_at":    true,
+	"updated_at":    true,
+	"deleted_at":    true,
+}
+
+// Error returns an error if the schema itself is invalid
+func (schema Schema) Error() error {
+	if len(schema.Fields) == 0 {
+		return fmt.Errorf("Schemas must declare at least one field")
+	}
+	for name, field := range schema.Fields {
+		if systemFields[name] {
+			return fmt.Errorf("Field %s is reserved", name)
+		}
+		switch field.Type {
+		case String, Number, Boolean:
+		default:
+			return fmt.Errorf("Field %s has an unknown type: %s", name, field.Type)
+		}
+		if field.MaxLength != 0 && field.Type != String {
+			return fmt.Errorf("Field %s cannot have a max length", name)
+		}
+	}
+	return nil
+}
+
+// Clean validates the content and returns only its declared fields
+func (schema Schema) Clean(content map[string]interface{}) (map[string]interface{}, error) {
+	clean := make(map[string]interface{})
+	for name, value := range content {
+		if systemFields[name] || value == nil {
+			continue
+		}
+		field, ok := schema.Fields[name]
+		if !ok {
+			if schema.Strict {
+				return nil, fmt.Errorf("Unknown field: %s", name)
+			}
+			continue
+		}
+		if err := field.check(name, value); err != nil {
+			return nil, err
+		}
+		clean[name] = value
+	}
+	for name, field := range schema.Fields {
+		if !field.Required {
+			continue
+		}
+		if value, ok := clean[name]; !ok || value == "" {
+			return nil, fmt.Errorf("%s cannot be blank", name)
+		}
+	}
+	return clean, nil
+}
+
+// check returns an error if the value does not match the field's type
+func (field Field) check(name string, value interface{}) error {
+	var ok bool
+	switch field.Type {
+	case String:
+		var s string
+		if s, ok = value.(string); ok && field.MaxLength > 0 && utf8.RuneCountInString(s) > field.MaxLength {
+			return fmt.Errorf(
+				"%s cannot be longer than %d characters",
+				name, field.MaxLength,
+			)
+		}
+	case Number:
+		_, ok = value.(float64)
+	case Boolean:
+		_, ok = value.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("%s must be a %s", name, field.Type)
+	}
+	return nil
+}
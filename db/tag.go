@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+const MaxTagLength = 64
+
+// Tag is a label shared by the things of a list
+type Tag struct {
+	ID     int64  `db:"id,omitempty" json:"id"`
+	ListID int64  `db:"list_id" json:"list_id"`
+	Name   string `db:"name" json:"name"`
+}
+
+func (tag Tag) String() string {
+	return tag.Name
+}
+
+func (tag Tag) Error() error {
+	if tag.Name == "" {
+		return fmt.Errorf("Tags cannot be blank")
+	}
+	if len(tag.Name) > MaxTagLength {
+		return fmt.Errorf(
+			"Tags cannot be longer than %d characters",
+			MaxTagLength,
+		)
+	}
+	return nil
+}
+
+func NewTag(listID int64, name string) Tag {
+	return Tag{ListID: listID, Name: name}
+}
+
+var Tags = sql.Table("tags",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"list_id",
+		Lists.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("name", sql.String{Length: MaxTagLength, NotNull: true}),
+	sql.PrimaryKey("id"),
+	sql.Unique("list_id", "name"),
+)
+
+// ThingTags associates things with their tags
+var ThingTags = sql.Table("thing_tags",
+	sql.ForeignKey(
+		"thing_id",
+		Things.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.ForeignKey(
+		"tag_id",
+		Tags.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.PrimaryKey("thing_id", "tag_id"),
+)
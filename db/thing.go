@@ -1,7 +1,8 @@
 package db
 
 import (
-	"fmt"
+	"encoding/json"
+	"time"
 
 	sql "github.com/aodin/aspect"
 	pg "github.com/aodin/aspect/postgres"
@@ -11,27 +12,85 @@ import (
 
 const MaxNameLength = 256
 
-// Thing is a thing with a name
+// Thing is a thing with content fields declared by a Schema
 type Thing struct {
-	ID      int64  `db:"id,omitempty" json:"id"`
-	Name    string `db:"-" json:"name"`
-	Content string `db:"content" json:"-"`
+	ID          int64                  `db:"id,omitempty" json:"id"`
+	ListID      int64                  `db:"list_id" json:"list_id"`
+	Version     int64                  `db:"version" json:"version"`
+	Position    float64                `db:"position" json:"position"`   // Things are ordered by position
+	ParentID    *int64                 `db:"parent_id" json:"parent_id"` // Nil for top level things
+	Collapsed   bool                   `db:"collapsed" json:"collapsed"`
+	Completed   bool                   `db:"completed" json:"completed"`
+	CompletedAt *time.Time             `db:"completed_at" json:"completed_at"`
+	CompletedBy *int64                 `db:"completed_by" json:"completed_by"` // The completing user
+	DueAt       *time.Time             `db:"due_at" json:"due_at"`
+	AssigneeID  *int64                 `db:"assignee_id" json:"assignee_id"` // Reminded when due
+	RemindedAt  *time.Time             `db:"reminded_at" json:"reminded_at"`
+	Children    []Thing                `db:"-" json:"children,omitempty"`
+	Tags        []string               `db:"-" json:"tags"`
+	Comments    int64                  `db:"-" json:"comment_count"`
+	Fields      map[string]interface{} `db:"-" json:"-"`
+	Content     string                 `db:"content" json:"-"`
 	fields.Timestamp
 }
 
 func (t Thing) String() string {
-	return t.Name
+	name, _ := t.Fields["name"].(string)
+	return name
 }
 
-func (t Thing) Error() error {
-	if t.Name == "" {
-		return fmt.Errorf("Names cannot be blank")
+// Decode sets the fields of the thing from its saved content
+func (t *Thing) Decode() error {
+	t.Fields = nil
+	return json.Unmarshal([]byte(t.Content), &t.Fields)
+}
+
+// SetFields sets both the fields and the saved content of the thing
+func (t *Thing) SetFields(fields map[string]interface{}) error {
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	t.Fields = fields
+	t.Content = string(content)
+	return nil
+}
+
+// MarshalJSON flattens the content fields alongside the system fields
+func (t Thing) MarshalJSON() ([]byte, error) {
+	type thing Thing // Without methods, to avoid recursion
+	system, err := json.Marshal(thing(t))
+	if err != nil || len(t.Fields) == 0 {
+		return system, err
+	}
+	flat := make(map[string]interface{})
+	if err = json.Unmarshal(system, &flat); err != nil {
+		return nil, err
+	}
+	for name, value := range t.Fields {
+		if _, exists := flat[name]; !exists {
+			flat[name] = value
+		}
+	}
+	return json.Marshal(flat)
+}
+
+// UnmarshalJSON reads the system fields and keeps any other fields as the
+// thing's content fields
+func (t *Thing) UnmarshalJSON(b []byte) error {
+	type thing Thing // Without methods, to avoid recursion
+	if err := json.Unmarshal(b, (*thing)(t)); err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
 	}
-	if len(t.Name) > MaxNameLength {
-		return fmt.Errorf(
-			"Names cannot be longer than %d characters",
-			MaxNameLength,
-		)
+	t.Fields = make(map[string]interface{})
+	for name, value := range all {
+		if !systemFields[name] {
+			t.Fields[name] = value
+		}
 	}
 	return nil
 }
@@ -42,12 +101,37 @@ func (t Thing) Values() sql.Values {
 	}
 }
 
-func NewThing(name string) Thing {
-	return Thing{Name: name}
+func NewThing(listID int64, name string) Thing {
+	thing := Thing{ListID: listID}
+	thing.SetFields(map[string]interface{}{"name": name})
+	return thing
 }
 
 var Things = sql.Table("things",
 	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"list_id",
+		Lists.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("version", sql.Integer{NotNull: true}),
+	sql.Column("position", sql.Double{NotNull: true}),
+	sql.Column("parent_id", sql.Integer{}), // References things(id)
+	sql.Column("collapsed", sql.Boolean{NotNull: true, Default: sql.False}),
+	sql.Column("completed", sql.Boolean{NotNull: true, Default: sql.False}),
+	sql.Column("completed_at", sql.Timestamp{}),
+	sql.ForeignKey(
+		"completed_by",
+		Users.C["id"],
+		sql.Integer{},
+	).OnDelete(sql.SetNull),
+	sql.Column("due_at", sql.Timestamp{}),
+	sql.ForeignKey(
+		"assignee_id",
+		Users.C["id"],
+		sql.Integer{},
+	).OnDelete(sql.SetNull),
+	sql.Column("reminded_at", sql.Timestamp{}),
 	sql.Column("content", pg.JSON{NotNull: true}),
 	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
 	sql.Column("updated_at", sql.Timestamp{}),
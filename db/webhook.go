@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+)
+
+// Delivery states
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead" // Every attempt failed
+)
+
+// Webhook posts the events of a list to a URL. Events are a comma separated
+// list, such as "CREATE,DELETE", and an empty list posts every event.
+type Webhook struct {
+	ID        int64     `db:"id,omitempty" json:"id"`
+	ListID    int64     `db:"list_id" json:"list_id"`
+	URL       string    `db:"url" json:"url"`
+	Events    string    `db:"events" json:"events"`
+	Secret    string    `db:"secret" json:"secret,omitempty"` // Only shown when created
+	CreatedAt time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+func (webhook Webhook) Exists() bool {
+	return webhook.ID != 0
+}
+
+func (webhook Webhook) String() string {
+	return webhook.URL
+}
+
+func (webhook Webhook) Error() error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Webhooks must have an http or https URL")
+	}
+	if len(webhook.URL) > 512 {
+		return fmt.Errorf("Webhook URLs cannot be longer than 512 characters")
+	}
+	if webhook.Secret == "" {
+		return fmt.Errorf("Webhooks must have a secret")
+	}
+	return nil
+}
+
+// Wants returns true if the webhook posts the given event
+func (webhook Webhook) Wants(event string) bool {
+	if strings.TrimSpace(webhook.Events) == "" {
+		return true
+	}
+	for _, wanted := range strings.Split(webhook.Events, ",") {
+		if strings.EqualFold(strings.TrimSpace(wanted), event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is a single event to post to a webhook. Deliveries that fail
+// every attempt are kept as dead.
+type Delivery struct {
+	ID          int64      `db:"id,omitempty" json:"id"`
+	WebhookID   int64      `db:"webhook_id" json:"webhook_id"`
+	Event       string     `db:"event" json:"event"`
+	Payload     string     `db:"payload" json:"-"`
+	State       string     `db:"state" json:"state"`
+	Attempts    int64      `db:"attempts" json:"attempts"`
+	NextAt      time.Time  `db:"next_at" json:"next_at"` // When the next attempt is made
+	CreatedAt   time.Time  `db:"created_at,omitempty" json:"created_at"`
+	DeliveredAt *time.Time `db:"delivered_at" json:"delivered_at"`
+}
+
+// Attempt is the result of posting a delivery
+type Attempt struct {
+	ID         int64     `db:"id,omitempty" json:"id"`
+	DeliveryID int64     `db:"delivery_id" json:"delivery_id"`
+	Status     int64     `db:"status" json:"status"` // Zero if no response
+	Error      string    `db:"error" json:"error"`
+	Duration   int64     `db:"duration" json:"duration"` // In milliseconds
+	CreatedAt  time.Time `db:"created_at,omitempty" json:"created_at"`
+}
+
+var Webhooks = sql.Table("webhooks",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"list_id",
+		Lists.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("url", sql.String{Length: 512, NotNull: true}),
+	sql.Column("events", sql.String{Length: 256, NotNull: true}),
+	sql.Column("secret", sql.String{Length: 128, NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+)
+
+var Deliveries = sql.Table("webhook_deliveries",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"webhook_id",
+		Webhooks.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("event", sql.String{Length: 64, NotNull: true}),
+	sql.Column("payload", pg.JSON{NotNull: true}),
+	sql.Column("state", sql.String{Length: 16, NotNull: true}),
+	sql.Column("attempts", sql.Integer{NotNull: true}),
+	sql.Column("next_at", sql.Timestamp{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.Column("delivered_at", sql.Timestamp{}),
+	sql.PrimaryKey("id"),
+)
+
+var Attempts = sql.Table("webhook_attempts",
+	sql.Column("id", pg.Serial{NotNull: true}),
+	sql.ForeignKey(
+		"delivery_id",
+		Deliveries.C["id"],
+		sql.Integer{NotNull: true},
+	).OnDelete(sql.Cascade),
+	sql.Column("status", sql.Integer{NotNull: true}),
+	sql.Column("error", sql.Text{NotNull: true}),
+	sql.Column("duration", sql.Integer{NotNull: true}),
+	sql.Column("created_at", sql.Timestamp{NotNull: true, Default: pg.Now}),
+	sql.PrimaryKey("id"),
+)
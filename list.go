@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -44,23 +45,133 @@ func main() {
 				cmd.SQL(c.Bool("all"), c.Args()...)
 			},
 		},
+		{
+			Name:  "things",
+			Usage: "manage the things of a list on a running server",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list the things of the list",
+					Flags: remoteFlags,
+					Action: func(c *cli.Context) {
+						check(remote(c).List())
+					},
+				},
+				{
+					Name:  "add",
+					Usage: "add a thing: add <name>",
+					Flags: remoteFlags,
+					Action: func(c *cli.Context) {
+						name, err := cmd.ParseName(c.Args())
+						if err != nil {
+							check(fmt.Errorf("%s\nusage: things add <name>", err))
+						}
+						check(remote(c).Add(name))
+					},
+				},
+				{
+					Name:  "rename",
+					Usage: "rename a thing: rename <id> <name>",
+					Flags: remoteFlags,
+					Action: func(c *cli.Context) {
+						id, rest, err := cmd.ParseID(c.Args())
+						var name string
+						if err == nil {
+							name, err = cmd.ParseName(rest)
+						}
+						if err != nil {
+							check(fmt.Errorf("%s\nusage: things rename <id> <name>", err))
+						}
+						check(remote(c).Rename(id, name))
+					},
+				},
+				{
+					Name:  "rm",
+					Usage: "move a thing and its sub-items to the trash: rm <id>",
+					Flags: remoteFlags,
+					Action: func(c *cli.Context) {
+						id, rest, err := cmd.ParseID(c.Args())
+						if err == nil && len(rest) > 0 {
+							err = fmt.Errorf("Only one ID can be given")
+						}
+						if err != nil {
+							check(fmt.Errorf("%s\nusage: things rm <id>", err))
+						}
+						check(remote(c).Remove(id))
+					},
+				},
+				{
+					Name:  "watch",
+					Usage: "print changes to the list as they happen",
+					Flags: remoteFlags,
+					Action: func(c *cli.Context) {
+						check(remote(c).Watch())
+					},
+				},
+			},
+		},
 	}
 	app.Run(os.Args)
 }
 
-func setUp(file string) (*sql.DB, config.Config) {
+// remoteFlags are the flags of every things subcommand
+var remoteFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "server, s",
+		Value:  "http://localhost:8080",
+		Usage:  "Sets the URL of the server",
+		EnvVar: "LISTOFTHINGS_SERVER",
+	},
+	cli.StringFlag{
+		Name:   "token, t",
+		Usage:  "Sets the session key, a new session is created without one",
+		EnvVar: "LISTOFTHINGS_TOKEN",
+	},
+	cli.IntFlag{
+		Name:  "list",
+		Usage: "Sets the ID of the list, the default list is used without one",
+	},
+	cli.BoolFlag{
+		Name:  "json",
+		Usage: "Prints JSON instead of a table",
+	},
+}
+
+func remote(c *cli.Context) cmd.Remote {
+	return cmd.Remote{
+		Server: c.String("server"),
+		Token:  c.String("token"),
+		ListID: int64(c.Int("list")),
+		JSON:   c.Bool("json"),
+		Out:    os.Stdout,
+	}
+}
+
+// check exits with the error, if there is one
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "quilt: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func setUp(file string) (*sql.DB, config.Config, server.Settings) {
 	// Parse the given configuration file
 	conf, err := config.ParseFile(file)
 	if err != nil {
 		log.Panicf("quilt: could not parse configuration: %s", err)
 	}
+	settings, err := server.ParseSettings(file)
+	if err != nil {
+		log.Panicf("quilt: could not parse settings: %s", err)
+	}
 
 	// Connect to the database
 	conn, err := sql.Connect(conf.Database.Driver, conf.Database.Credentials())
 	if err != nil {
 		log.Panicf("quilt: could not connect to the database: %s", err)
 	}
-	return conn, conf
+	return conn, conf, settings
 }
 
 func startServer(c *cli.Context) {
@@ -79,8 +190,8 @@ func startServer(c *cli.Context) {
 		defer l.Close()
 		log.SetOutput(l)
 	}
-	conn, conf := setUp(file)
+	conn, conf, settings := setUp(file)
 	defer conn.Close()
 	log.Println("Starting server on", conf.Address())
-	log.Panic(server.New(conf, conn).ListenAndServe())
+	log.Panic(server.New(conf, settings, conn).ListenAndServe())
 }
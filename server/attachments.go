@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// AttachmentsHandler lists, uploads and deletes the files attached to
+// things. GET /api/v1/attachments?thing={id} lists the attachments of a
+// thing, a multipart POST with the fields "thing" and "file" attaches a
+// file and DELETE /api/v1/attachments?id={id} removes one. Files are only
+// listed and attached by users who have opened the thing's list, so their
+// IDs are not served to sessions that never saw it, and only uploaders can
+// delete their attachments.
+func (srv *Server) AttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	user := srv.user(r)
+	if !user.Exists() {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "A session is required",
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		thingID, _ := strconv.ParseInt(r.FormValue("thing"), 10, 64)
+		thing, err := srv.attachments.Thing(thingID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		if !srv.hasOpened(thing.ListID, user) {
+			writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+				Message: "Open the list before seeing its attachments",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, srv.attachments.ForThing(thingID))
+
+	case "POST":
+		// Leave room for the other fields of the form
+		r.Body = http.MaxBytesReader(w, r.Body, srv.attachments.MaxSize()+1<<20)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: fmt.Sprintf("Invalid upload: %s", err),
+			})
+			return
+		}
+		file.Close()
+		thingID, _ := strconv.ParseInt(r.FormValue("thing"), 10, 64)
+		thing, err := srv.attachments.Thing(thingID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		if !srv.hasOpened(thing.ListID, user) {
+			writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+				Message: "Open the list before attaching files",
+			})
+			return
+		}
+		attachment, err := srv.attachments.Create(thing, user, header)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		srv.record(thing.ListID, feeds.CREATE, attachment)
+		writeJSON(w, http.StatusCreated, attachment)
+
+	case "DELETE":
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		attachment := srv.attachments.Get(id)
+		if !attachment.Exists() {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "Attachment does not exist",
+			})
+			return
+		}
+		if attachment.UserID != user.ID {
+			writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+				Message: "Only the uploader of an attachment can delete it",
+			})
+			return
+		}
+		thing, err := srv.attachments.Thing(attachment.ThingID)
+		if err == nil {
+			err = srv.attachments.Delete(attachment)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		srv.record(thing.ListID, feeds.DELETE, attachment)
+		writeJSON(w, http.StatusOK, attachment)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "Attachments must use GET, POST or DELETE",
+		})
+	}
+}
+
+// hasOpened returns true if the user has opened the list
+func (srv *Server) hasOpened(listID int64, user db.User) bool {
+	member, err := srv.lists.Member(listID, user)
+	if err != nil {
+		log.Printf("error: could not get the membership of %s: %s", user, err)
+	}
+	return member.Exists()
+}
+
+// record broadcasts a change to an attachment to the users of its list
+func (srv *Server) record(listID int64, event string, content interface{}) {
+	out := feeds.OutgoingMessage{
+		Resource: "attachments",
+		Event:    event,
+		Content:  content,
+	}
+	if err := srv.hub.Record(listID, out); err != nil {
+		log.Printf("error: could not record %s of attachment: %s", event, err)
+	}
+}
+
+// MediaHandler serves attached files and their thumbnails to users who
+// have opened their list. Only images are shown inline, other files are downloaded.
+func (srv *Server) MediaHandler(w http.ResponseWriter, r *http.Request) {
+	user := srv.user(r)
+	if !user.Exists() {
+		http.Error(w, "A session is required", http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, srv.config.MediaURL)
+	attachment := srv.attachments.ByPath(path)
+	if !attachment.Exists() {
+		http.NotFound(w, r)
+		return
+	}
+	thing, err := srv.attachments.Thing(attachment.ThingID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !srv.hasOpened(thing.ListID, user) {
+		http.Error(w, "Open the list before seeing its attachments", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if path == attachment.Thumbnail {
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		w.Header().Set("Content-Type", attachment.ContentType)
+		if !attachment.IsImage() {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType(
+				"attachment", map[string]string{"filename": attachment.Name},
+			))
+		}
+	}
+	http.ServeFile(w, r, srv.attachments.File(path))
+}
@@ -0,0 +1,260 @@
+package attachments
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+	"github.com/aodin/volta/config"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/server/auth"
+)
+
+// Settings limit the files that can be attached to things
+type Settings struct {
+	MaxSize       int64    `json:"max_size"` // In bytes
+	MaxPixels     int64    `json:"max_pixels"`
+	Types         []string `json:"types"` // Allowed MIME types
+	ThumbnailSize int      `json:"thumbnail_size"`
+}
+
+// Allows returns true if files of the given MIME type can be attached
+func (s Settings) Allows(contentType string) bool {
+	for _, allowed := range s.Types {
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+var DefaultSettings = Settings{
+	MaxSize:   10 << 20,
+	MaxPixels: 40 << 20,
+	Types: []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"application/pdf",
+		"text/plain",
+	},
+	ThumbnailSize: 200,
+}
+
+// AttachmentManager saves the attachments of things under the media
+// directory. The files of things purged from the trash are removed by the
+// hub.
+type AttachmentManager struct {
+	conn     sql.Connection
+	dir      string
+	url      string
+	settings Settings
+}
+
+// Enabled returns true if a media directory and URL are configured
+func (m *AttachmentManager) Enabled() bool {
+	return m.dir != "" && m.url != ""
+}
+
+// MaxSize returns the largest file size in bytes that can be attached
+func (m *AttachmentManager) MaxSize() int64 {
+	return m.settings.MaxSize
+}
+
+// withURLs sets the URLs the attachment is served from
+func (m *AttachmentManager) withURLs(attachment db.Attachment) db.Attachment {
+	attachment.URL = m.url + attachment.Path
+	if attachment.Thumbnail != "" {
+		attachment.ThumbnailURL = m.url + attachment.Thumbnail
+	}
+	return attachment
+}
+
+// Thing returns the thing with the given ID, which may be in the trash
+func (m *AttachmentManager) Thing(id int64) (thing db.Thing, err error) {
+	stmt := db.Things.Select().Where(db.Things.C["id"].Equals(id))
+	if err = m.conn.QueryOne(stmt, &thing); err == sql.ErrNoResult {
+		err = fmt.Errorf("Thing does not exist")
+	}
+	return
+}
+
+// Create saves the uploaded file and attaches it to the thing. The type of
+// the file is detected from its contents rather than trusted from the
+// upload.
+func (m *AttachmentManager) Create(thing db.Thing, user db.User, header *multipart.FileHeader) (attachment db.Attachment, err error) {
+	if !m.Enabled() {
+		err = fmt.Errorf("Attachments are not enabled")
+		return
+	}
+	if thing.IsDeleted() {
+		err = fmt.Errorf("Thing has been deleted")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if err != nil {
+		return
+	}
+	if !m.settings.Allows(contentType) {
+		err = fmt.Errorf("Files of type %s cannot be attached", contentType)
+		return
+	}
+
+	name := filepath.Base(strings.Replace(header.Filename, "\\", "/", -1))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	if len(name) > 256 {
+		name = name[len(name)-256:]
+	}
+
+	// Files are stored by thing under random names, keeping the extension
+	key := auth.RandomKeyN(12)
+	attachment = db.Attachment{
+		ThingID:     thing.ID,
+		UserID:      user.ID,
+		Name:        name,
+		ContentType: contentType,
+		Path:        path.Join("things", fmt.Sprint(thing.ID), key+strings.ToLower(filepath.Ext(name))),
+	}
+	if err = os.MkdirAll(filepath.Dir(m.File(attachment.Path)), 0755); err != nil {
+		return
+	}
+	dst, err := os.Create(m.File(attachment.Path))
+	if err != nil {
+		return
+	}
+	written, err := io.Copy(dst, io.LimitReader(io.MultiReader(
+		bytes.NewReader(sniff[:n]), file,
+	), m.settings.MaxSize+1))
+	dst.Close()
+	if err == nil && written > m.settings.MaxSize {
+		err = fmt.Errorf("Files cannot be larger than %d bytes", m.settings.MaxSize)
+	}
+	if err != nil {
+		os.Remove(m.File(attachment.Path))
+		return
+	}
+	attachment.Size = written
+
+	// Images that are too large to decode are refused
+	if strings.HasPrefix(contentType, "image/") {
+		thumbnail := path.Join(path.Dir(attachment.Path), key+"_thumb.png")
+		switch err = m.thumbnail(attachment.Path, thumbnail); err {
+		case nil:
+			attachment.Thumbnail = thumbnail
+		case ErrTooManyPixels:
+			os.Remove(m.File(attachment.Path))
+			err = fmt.Errorf("Images cannot have more than %d pixels", m.settings.MaxPixels)
+			return
+		default:
+			err = nil
+		}
+	}
+
+	stmt := pg.Insert(db.Attachments).Values(attachment).Returning(db.Attachments)
+	if err = m.conn.QueryOne(stmt, &attachment); err != nil {
+		m.remove(attachment)
+		return
+	}
+	return m.withURLs(attachment), nil
+}
+
+// Get returns the attachment with the given ID
+func (m *AttachmentManager) Get(id int64) (attachment db.Attachment) {
+	stmt := db.Attachments.Select().Where(db.Attachments.C["id"].Equals(id))
+	m.conn.MustQueryOne(stmt, &attachment)
+	return m.withURLs(attachment)
+}
+
+// ForThing returns the attachments of a thing, oldest first
+func (m *AttachmentManager) ForThing(thingID int64) []db.Attachment {
+	attachments := []db.Attachment{}
+	stmt := db.Attachments.Select().Where(
+		db.Attachments.C["thing_id"].Equals(thingID),
+	).OrderBy(db.Attachments.C["id"])
+	m.conn.MustQueryAll(stmt, &attachments)
+	for i := range attachments {
+		attachments[i] = m.withURLs(attachments[i])
+	}
+	return attachments
+}
+
+// ByPath returns the attachment whose file or thumbnail is at the given
+// path relative to the media directory
+func (m *AttachmentManager) ByPath(p string) (attachment db.Attachment) {
+	stmt := db.Attachments.Select().Where(sql.AnyOf(
+		db.Attachments.C["path"].Equals(p),
+		db.Attachments.C["thumbnail"].Equals(p),
+	))
+	m.conn.MustQueryOne(stmt, &attachment)
+	return m.withURLs(attachment)
+}
+
+// Delete removes the attachment and its files
+func (m *AttachmentManager) Delete(attachment db.Attachment) error {
+	stmt := db.Attachments.Delete().Where(
+		db.Attachments.C["id"].Equals(attachment.ID),
+	)
+	if _, err := m.conn.Execute(stmt); err != nil {
+		return err
+	}
+	m.remove(attachment)
+	return nil
+}
+
+func (m *AttachmentManager) remove(attachment db.Attachment) {
+	os.Remove(m.File(attachment.Path))
+	if attachment.Thumbnail != "" {
+		os.Remove(m.File(attachment.Thumbnail))
+	}
+}
+
+// File returns the location in the media directory of the given path
+func (m *AttachmentManager) File(p string) string {
+	return filepath.Join(m.dir, filepath.FromSlash(p))
+}
+
+// Attachments creates a new attachment manager that saves files under
+// the configured media directory
+func Attachments(config config.Config, settings Settings, conn sql.Connection) *AttachmentManager {
+	if settings.MaxSize <= 0 {
+		settings.MaxSize = DefaultSettings.MaxSize
+	}
+	if len(settings.Types) == 0 {
+		settings.Types = DefaultSettings.Types
+	}
+	if settings.MaxPixels <= 0 {
+		settings.MaxPixels = DefaultSettings.MaxPixels
+	}
+	if settings.ThumbnailSize <= 0 {
+		settings.ThumbnailSize = DefaultSettings.ThumbnailSize
+	}
+	return &AttachmentManager{
+		conn:     conn,
+		dir:      config.MediaDir,
+		url:      config.MediaURL,
+		settings: settings,
+	}
+}
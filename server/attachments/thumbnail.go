@@ -0,0 +1,77 @@
+package attachments
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+// ErrTooManyPixels is returned for images larger than the pixel limit,
+// which are not decoded
+var ErrTooManyPixels = errors.New("Image has too many pixels")
+
+// thumbnail saves a PNG copy of the image at src that fits within the
+// thumbnail size. Images that are already small enough are copied as is.
+func (m *AttachmentManager) thumbnail(src, dst string) error {
+	in, err := os.Open(m.File(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// The dimensions are read from the header before the image is decoded
+	config, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return err
+	}
+	if int64(config.Width)*int64(config.Height) > m.settings.MaxPixels {
+		return ErrTooManyPixels
+	}
+	if _, err = in.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(m.File(dst))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return png.Encode(out, scale(img, m.settings.ThumbnailSize))
+}
+
+// scale resizes the image so that its longest side is at most the given
+// size, using nearest neighbor sampling
+func scale(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			thumb.Set(x, y, img.At(
+				bounds.Min.X+x*w/tw,
+				bounds.Min.Y+y*h/th,
+			))
+		}
+	}
+	return thumb
+}
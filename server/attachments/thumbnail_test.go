@@ -0,0 +1,65 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aodin/volta/config"
+)
+
+// writePNG saves a PNG of the given size whose header claims the given
+// width, as a decompression bomb would
+func writePNG(t *testing.T, file string, size, width int) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// The IHDR chunk follows the signature: length, type, width, height...
+	binary.BigEndian.PutUint32(b[16:20], uint32(width))
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThumbnailPixelLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	settings := DefaultSettings
+	settings.MaxPixels = 64 * 64
+	settings.ThumbnailSize = 16
+	m := Attachments(config.Config{MediaDir: dir}, settings, nil)
+
+	writePNG(t, filepath.Join(dir, "small.png"), 64, 64)
+	if err = m.thumbnail("small.png", "small_thumb.png"); err != nil {
+		t.Fatalf("could not create a thumbnail: %s", err)
+	}
+	in, err := os.Open(filepath.Join(dir, "small_thumb.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	thumb, _, err := image.DecodeConfig(in)
+	if err != nil || thumb.Width != 16 || thumb.Height != 16 {
+		t.Errorf("unexpected thumbnail %+v: %v", thumb, err)
+	}
+
+	writePNG(t, filepath.Join(dir, "bomb.png"), 1, 1<<30)
+	if err = m.thumbnail("bomb.png", "bomb_thumb.png"); err != ErrTooManyPixels {
+		t.Errorf("an image with too many pixels was decoded: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "bomb_thumb.png")); !os.IsNotExist(err) {
+		t.Error("a thumbnail was saved for an image with too many pixels")
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/aodin/volta/config"
 
@@ -20,3 +21,17 @@ func SetCookie(w http.ResponseWriter, c config.CookieConfig, session db.Session)
 	}
 	http.SetCookie(w, cookie)
 }
+
+// RequestKey returns the session key of the request, either from its
+// session cookie or, for clients without cookies, from an
+// "Authorization: Bearer {key}" header
+func RequestKey(r *http.Request, c config.CookieConfig) string {
+	if cookie, err := r.Cookie(c.Name); err == nil {
+		return cookie.Value
+	}
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return ""
+}
@@ -25,6 +25,15 @@ func (m *UserManager) Get(id int64) (user db.User) {
 	return
 }
 
+// SetEmail changes the email address of the user
+func (m *UserManager) SetEmail(user db.User, email string) error {
+	stmt := db.Users.Update().Values(sql.Values{
+		"email": email,
+	}).Where(db.Users.C["id"].Equals(user.ID))
+	_, err := m.conn.Execute(stmt)
+	return err
+}
+
 func Users(conn sql.Connection) *UserManager {
 	return &UserManager{
 		conn: conn,
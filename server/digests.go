@@ -0,0 +1,95 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aodin/volta/templates"
+)
+
+// DigestsHandler sets how often the user is emailed a digest from a form
+// POST to /digests/ with a frequency of "daily", "weekly" or "never". An
+// email address can be given at the same time, which is used once the link
+// emailed to it is followed.
+func (srv *Server) DigestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	user := srv.user(r)
+	if !user.Exists() {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	var err error
+	if frequency := r.FormValue("frequency"); frequency == "never" {
+		err = srv.digests.Unsubscribe(user)
+	} else if _, err = srv.digests.Subscribe(user, frequency); err == nil {
+		if email := strings.TrimSpace(r.FormValue("email")); email != "" && email != user.Email {
+			err = srv.digests.RequestEmail(user, email)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		srv.templates.Execute(w, "lists", templates.Attrs{
+			"Lists":  srv.lists.All(),
+			"User":   user,
+			"Digest": srv.digests.Get(user),
+			"Error":  err.Error(),
+		})
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// VerifyHandler confirms the email address of a digest with the token of
+// the link emailed to it. The link shows a form, which verifies the address
+// when it is posted. It does not require a session.
+func (srv *Server) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if r.Method != "POST" {
+		digest, err := srv.digests.Pending(token)
+		if err != nil {
+			log.Printf("error: could not get pending digest email: %s", err)
+		}
+		srv.templates.Execute(w, "verify", templates.Attrs{
+			"Confirm": digest.Exists(),
+			"Token":   token,
+			"Email":   digest.PendingEmail,
+		})
+		return
+	}
+	verified, err := srv.digests.Verify(token)
+	if err != nil {
+		log.Printf("error: could not verify digest email: %s", err)
+		http.Error(w, "Could not verify the email address", http.StatusInternalServerError)
+		return
+	}
+	srv.templates.Execute(w, "verify", templates.Attrs{
+		"Verified": verified,
+	})
+}
+
+// UnsubscribeHandler stops the digests of the token in the link of every
+// digest. The link shows a form, which unsubscribes when it is posted. It
+// does not require a session.
+func (srv *Server) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if r.Method != "POST" {
+		srv.templates.Execute(w, "unsubscribed", templates.Attrs{
+			"Confirm": token != "",
+			"Token":   token,
+		})
+		return
+	}
+	unsubscribed, err := srv.digests.UnsubscribeToken(token)
+	if err != nil {
+		log.Printf("error: could not unsubscribe: %s", err)
+		http.Error(w, "Could not unsubscribe", http.StatusInternalServerError)
+		return
+	}
+	srv.templates.Execute(w, "unsubscribed", templates.Attrs{
+		"Unsubscribed": unsubscribed,
+	})
+}
@@ -0,0 +1,445 @@
+package digests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+	"github.com/aodin/volta/config"
+	"github.com/aodin/volta/templates"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/server/auth"
+	"github.com/aodin/listofthings/server/mail"
+)
+
+// Settings configure when digests are sent
+type Settings struct {
+	Interval time.Duration `json:"interval"` // Time between checks for due digests, zero never sends
+}
+
+var DefaultSettings = Settings{
+	Interval: time.Hour,
+}
+
+// Claims of digests expire after this long, so the digest of a server that
+// stopped while sending it is sent by another
+const ClaimLease = 10 * time.Minute
+
+// Activity is what happened to the things of a single list
+type Activity struct {
+	ListID    int64
+	Name      string
+	URL       string
+	Created   []string
+	Updated   []string
+	Completed []string
+	Deleted   []string
+}
+
+// DigestManager keeps the digest preferences of users and emails their
+// digests
+type DigestManager struct {
+	conn      sql.Connection
+	config    config.Config
+	mailer    mail.Mailer
+	templates *templates.Templates
+}
+
+// Get returns the digest of the user, which will not exist if the user has
+// not opted in
+func (m *DigestManager) Get(user db.User) (digest db.Digest) {
+	stmt := db.Digests.Select().Where(db.Digests.C["user_id"].Equals(user.ID))
+	m.conn.MustQueryOne(stmt, &digest)
+	return
+}
+
+// Subscribe sets how often the user is sent digests
+func (m *DigestManager) Subscribe(user db.User, frequency string) (digest db.Digest, err error) {
+	if digest = m.Get(user); digest.Exists() {
+		digest.Frequency = frequency
+		if err = digest.Error(); err != nil {
+			return
+		}
+		stmt := db.Digests.Update().Values(sql.Values{
+			"frequency": frequency,
+		}).Where(db.Digests.C["user_id"].Equals(user.ID))
+		_, err = m.conn.Execute(stmt)
+		return
+	}
+	digest = db.Digest{
+		UserID:    user.ID,
+		Frequency: frequency,
+		Token:     auth.RandomKey(),
+	}
+	if err = digest.Error(); err != nil {
+		return
+	}
+	stmt := pg.Insert(db.Digests).Values(digest).Returning(db.Digests)
+	err = m.conn.QueryOne(stmt, &digest)
+	return
+}
+
+// Unsubscribe stops the digests of the user
+func (m *DigestManager) Unsubscribe(user db.User) error {
+	stmt := db.Digests.Delete().Where(db.Digests.C["user_id"].Equals(user.ID))
+	_, err := m.conn.Execute(stmt)
+	return err
+}
+
+// RequestEmail emails a link to the address the user wants digests sent to.
+// The address is used once the link is followed.
+func (m *DigestManager) RequestEmail(user db.User, email string) error {
+	if m.mailer == nil {
+		return fmt.Errorf("Email addresses cannot be verified without a mail server")
+	}
+	if !strings.Contains(email, "@") || len(email) > 256 {
+		return fmt.Errorf("Invalid email address")
+	}
+	token := auth.RandomKey()
+	stmt := db.Digests.Update().Values(sql.Values{
+		"pending_email": email,
+		"verify_token":  token,
+	}).Where(db.Digests.C["user_id"].Equals(user.ID))
+	result, err := m.conn.Execute(stmt)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = fmt.Errorf("Choose how often to be sent digests first")
+		}
+		return err
+	}
+	body := fmt.Sprintf(
+		"Follow this link to be sent digests of your lists at %s:\n\n%s\n\nIf you did not ask for digests, ignore this email.\n",
+		email,
+		m.VerifyURL(token),
+	)
+	return m.mailer.Send(email, "Confirm your email for digests", body)
+}
+
+// Pending returns the digest whose pending email is verified by the token,
+// which will not exist if no digest has the token
+func (m *DigestManager) Pending(token string) (digest db.Digest, err error) {
+	if token == "" {
+		return
+	}
+	stmt := db.Digests.Select().Where(db.Digests.C["verify_token"].Equals(token))
+	if err = m.conn.QueryOne(stmt, &digest); err == sql.ErrNoResult {
+		err = nil
+	}
+	return
+}
+
+// Verify sets the email of the user to the pending email of the token. It
+// returns false if no digest has the token.
+func (m *DigestManager) Verify(token string) (bool, error) {
+	digest, err := m.Pending(token)
+	if err != nil || !digest.Exists() {
+		return false, err
+	}
+	tx, err := m.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	stmt := db.Users.Update().Values(sql.Values{
+		"email": digest.PendingEmail,
+	}).Where(db.Users.C["id"].Equals(digest.UserID))
+	if _, err = tx.Execute(stmt); err != nil {
+		return false, err
+	}
+	stmt = db.Digests.Update().Values(sql.Values{
+		"pending_email": "",
+		"verify_token":  "",
+	}).Where(
+		db.Digests.C["user_id"].Equals(digest.UserID),
+		db.Digests.C["verify_token"].Equals(token),
+	)
+	if _, err = tx.Execute(stmt); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// UnsubscribeToken stops the digests with the given token. It returns false
+// if no digests have the token.
+func (m *DigestManager) UnsubscribeToken(token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	stmt := db.Digests.Delete().Where(db.Digests.C["token"].Equals(token))
+	result, err := m.conn.Execute(stmt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Summary returns the activity between the given times of every list the
+// user has opened, ordered by list
+func (m *DigestManager) Summary(user db.User, since, until time.Time) ([]Activity, error) {
+	lists := make(map[int64]*Activity)
+	activity := func(listID int64, name string) *Activity {
+		if lists[listID] == nil {
+			lists[listID] = &Activity{
+				ListID: listID,
+				Name:   name,
+				URL:    fmt.Sprintf("%s/lists/%d", m.config.FullAddress(), listID),
+			}
+		}
+		return lists[listID]
+	}
+
+	// Things are named by their content at the time of the change
+	var revisions []struct {
+		db.Revision
+		ListID int64  `db:"list_id"`
+		Name   string `db:"name"`
+	}
+	stmt := db.Revisions.Select(db.Things.C["list_id"], db.Lists.C["name"]).JoinOn(
+		db.Things, db.Things.C["id"].Equals(db.Revisions.C["thing_id"]),
+	).JoinOn(
+		db.Lists, db.Lists.C["id"].Equals(db.Things.C["list_id"]),
+	).JoinOn(
+		db.Members, db.Members.C["list_id"].Equals(db.Lists.C["id"]),
+	).Where(
+		db.Members.C["user_id"].Equals(user.ID),
+		db.Revisions.C["created_at"].GreaterThan(since),
+		db.Revisions.C["created_at"].LessThan(until),
+		db.Lists.C["deleted_at"].IsNull(),
+	).OrderBy(db.Revisions.C["id"])
+	if err := m.conn.QueryAll(stmt, &revisions); err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		thing := db.Thing{Content: revision.Content}
+		if err := thing.Decode(); err != nil {
+			log.Printf("error: could not decode revision %d: %s", revision.ID, err)
+			continue
+		}
+		a := activity(revision.ListID, revision.Name)
+		switch revision.Action {
+		case "create":
+			a.Created = append(a.Created, thing.String())
+		case "update", "revert":
+			a.Updated = append(a.Updated, thing.String())
+		case "delete":
+			a.Deleted = append(a.Deleted, thing.String())
+		}
+	}
+
+	var completed []struct {
+		db.Thing
+		Name string `db:"name"`
+	}
+	stmt = db.Things.Select(db.Lists.C["name"]).JoinOn(
+		db.Lists, db.Lists.C["id"].Equals(db.Things.C["list_id"]),
+	).JoinOn(
+		db.Members, db.Members.C["list_id"].Equals(db.Lists.C["id"]),
+	).Where(
+		db.Members.C["user_id"].Equals(user.ID),
+		db.Things.C["completed"].Equals(true),
+		db.Things.C["completed_at"].GreaterThan(since),
+		db.Things.C["completed_at"].LessThan(until),
+		db.Things.C["deleted_at"].IsNull(),
+		db.Lists.C["deleted_at"].IsNull(),
+	).OrderBy(db.Things.C["completed_at"])
+	if err := m.conn.QueryAll(stmt, &completed); err != nil {
+		return nil, err
+	}
+	for _, row := range completed {
+		if err := row.Thing.Decode(); err != nil {
+			log.Printf("error: could not decode thing %d: %s", row.ID, err)
+			continue
+		}
+		a := activity(row.ListID, row.Name)
+		a.Completed = append(a.Completed, row.Thing.String())
+	}
+
+	summary := make([]Activity, 0, len(lists))
+	for _, a := range lists {
+		a.Updated = unique(a.Updated)
+		summary = append(summary, *a)
+	}
+	sort.Sort(byList(summary))
+	return summary, nil
+}
+
+type byList []Activity
+
+func (a byList) Len() int           { return len(a) }
+func (a byList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byList) Less(i, j int) bool { return a[i].ListID < a[j].ListID }
+
+// unique removes repeated names, such as a thing that was updated often
+func unique(names []string) []string {
+	seen := make(map[string]bool)
+	kept := names[:0]
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// claim leases the unsent digest to this server until it is sent. It
+// returns false if another server is sending or already sent it.
+func (m *DigestManager) claim(digest db.Digest, now time.Time) (bool, error) {
+	var unsent sql.Clause = db.Digests.C["sent_at"].IsNull()
+	if digest.SentAt != nil {
+		unsent = db.Digests.C["sent_at"].Equals(*digest.SentAt)
+	}
+	stmt := db.Digests.Update().Values(sql.Values{
+		"claimed_until": now.Add(ClaimLease),
+	}).Where(
+		db.Digests.C["user_id"].Equals(digest.UserID),
+		unsent,
+		sql.AnyOf(
+			db.Digests.C["claimed_until"].IsNull(),
+			db.Digests.C["claimed_until"].LessThan(now),
+		),
+	)
+	result, err := m.conn.Execute(stmt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// release ends the claim of the digest. Sent digests start their next
+// period, otherwise the digest is sent again.
+func (m *DigestManager) release(digest db.Digest, sent bool, now time.Time) error {
+	values := sql.Values{"claimed_until": nil}
+	if sent {
+		values["sent_at"] = now
+	}
+	stmt := db.Digests.Update().Values(values).Where(
+		db.Digests.C["user_id"].Equals(digest.UserID),
+	)
+	_, err := m.conn.Execute(stmt)
+	return err
+}
+
+// SendDue emails every digest whose period has passed. Digests without any
+// activity are skipped until the next period, and digests that could not
+// be sent are tried again.
+func (m *DigestManager) SendDue(now time.Time) {
+	var due []struct {
+		db.Digest
+		Email string `db:"email"`
+	}
+	stmt := db.Digests.Select(db.Users.C["email"]).JoinOn(
+		db.Users, db.Users.C["id"].Equals(db.Digests.C["user_id"]),
+	).Where(
+		db.Users.C["email"].DoesNotEqual(""),
+		db.Users.C["deleted_at"].IsNull(),
+	)
+	if err := m.conn.QueryAll(stmt, &due); err != nil {
+		log.Printf("error: could not get digests: %s", err)
+		return
+	}
+	for _, digest := range due {
+		if now.Sub(digest.Since()) < digest.Period() {
+			continue
+		}
+		claimed, err := m.claim(digest.Digest, now)
+		if err != nil {
+			log.Printf("error: could not claim digest of user %d: %s", digest.UserID, err)
+		}
+		if !claimed {
+			continue
+		}
+		user := db.User{ID: digest.UserID, Email: digest.Email}
+		err = m.send(digest.Digest, user, now)
+		if err != nil {
+			log.Printf("error: could not send digest to user %d: %s", digest.UserID, err)
+		}
+		if err = m.release(digest.Digest, err == nil, now); err != nil {
+			log.Printf("error: could not release digest of user %d: %s", digest.UserID, err)
+		}
+	}
+}
+
+// send renders and emails a single digest
+func (m *DigestManager) send(digest db.Digest, user db.User, now time.Time) error {
+	summary, err := m.Summary(user, digest.Since(), now)
+	if err != nil || len(summary) == 0 {
+		return err
+	}
+	var body bytes.Buffer
+	err = m.render(&body, "digest", templates.Attrs{
+		"Digest":      digest,
+		"Since":       digest.Since(),
+		"Lists":       summary,
+		"Unsubscribe": m.UnsubscribeURL(digest),
+	})
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Your %s list of things digest", digest.Frequency)
+	return m.mailer.SendHTML(user.Email, subject, body.String())
+}
+
+// render executes the named template, returning the error that the
+// templates package panics with
+func (m *DigestManager) render(w io.Writer, name string, attrs templates.Attrs) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	m.templates.Execute(w, name, attrs)
+	return
+}
+
+// UnsubscribeURL is the link that stops the digests without logging in
+func (m *DigestManager) UnsubscribeURL(digest db.Digest) string {
+	return fmt.Sprintf(
+		"%s/digests/unsubscribe?token=%s",
+		m.config.FullAddress(),
+		url.QueryEscape(digest.Token),
+	)
+}
+
+// VerifyURL is the link that verifies the pending email of a digest
+func (m *DigestManager) VerifyURL(token string) string {
+	return fmt.Sprintf(
+		"%s/digests/verify?token=%s",
+		m.config.FullAddress(),
+		url.QueryEscape(token),
+	)
+}
+
+// Run periodically sends the digests that are due. Without a mailer, no
+// digests are sent.
+func (m *DigestManager) Run(interval time.Duration) {
+	if m.mailer == nil || interval <= 0 {
+		return
+	}
+	for now := range time.Tick(interval) {
+		m.SendDue(now.UTC())
+	}
+}
+
+// Digests creates a new digest manager
+func Digests(config config.Config, conn sql.Connection, mailer mail.Mailer, templates *templates.Templates) *DigestManager {
+	return &DigestManager{
+		conn:      conn,
+		config:    config,
+		mailer:    mailer,
+		templates: templates,
+	}
+}
@@ -0,0 +1,175 @@
+package digests
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	"github.com/aodin/volta/config"
+	"github.com/aodin/volta/templates"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+	"github.com/aodin/listofthings/server/mail"
+	"github.com/aodin/listofthings/server/mail/mailtest"
+)
+
+// failingMailer cannot send any email
+type failingMailer struct{}
+
+func (failingMailer) Send(to, subject, body string) error {
+	return errors.New("connection refused")
+}
+
+func (failingMailer) SendHTML(to, subject, body string) error {
+	return errors.New("connection refused")
+}
+
+func TestDigestIsSentOnlyOnce(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "digests@example.com")
+	defer conn.Execute(db.Users.Delete().Where(db.Users.C["id"].Equals(user.ID)))
+
+	now := time.Now().UTC()
+	completed := now.Add(-time.Hour)
+	thing := db.NewThing(list.ID, "Milk")
+	thing.Version = 1
+	thing.Completed = true
+	thing.CompletedAt = &completed
+	if _, err := conn.Execute(db.Things.Insert().Values(thing)); err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	member := db.Member{ListID: list.ID, UserID: user.ID}
+	if _, err := conn.Execute(db.Members.Insert().Values(member)); err != nil {
+		t.Fatalf("could not join the list: %s", err)
+	}
+
+	// Lists the user has not opened are not in their digest
+	other, removeOther := dbtest.List(t, conn)
+	defer removeOther()
+	secret := db.NewThing(other.ID, "Secret")
+	secret.Version = 1
+	secret.Completed = true
+	secret.CompletedAt = &completed
+	if _, err := conn.Execute(db.Things.Insert().Values(secret)); err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+
+	tmpl := templates.New("../../templates")
+	m := Digests(config.Config{}, conn, failingMailer{}, tmpl)
+	if _, err := m.Subscribe(user, db.Daily); err != nil {
+		t.Fatalf("could not subscribe: %s", err)
+	}
+	conn.Execute(db.Digests.Update().Values(sql.Values{
+		"created_at": now.Add(-2 * 24 * time.Hour),
+	}).Where(db.Digests.C["user_id"].Equals(user.ID)))
+
+	// A digest that could not be sent is not marked as sent
+	m.SendDue(now)
+	digest := m.Get(user)
+	if digest.SentAt != nil || digest.ClaimedUntil != nil {
+		t.Fatalf("a failed digest was marked as sent: %+v", digest)
+	}
+
+	// Only one server can send the digest at a time
+	if claimed, err := m.claim(digest, now); !claimed || err != nil {
+		t.Fatalf("could not claim the digest: %v", err)
+	}
+	if claimed, _ := m.claim(digest, now); claimed {
+		t.Error("a claimed digest was claimed again")
+	}
+	if claimed, _ := m.claim(digest, now.Add(ClaimLease+time.Second)); !claimed {
+		t.Error("an expired claim was not claimed again")
+	}
+	m.release(digest, false, now)
+
+	smtp, emails, stop := mailtest.Listen(t)
+	defer stop()
+	m.mailer = mail.New(smtp)
+	m.SendDue(now)
+	select {
+	case email := <-emails:
+		if !strings.Contains(email.Data, "Milk") {
+			t.Errorf("the digest does not include the completed thing: %s", email.Data)
+		}
+		if strings.Contains(email.Data, "Secret") {
+			t.Errorf("the digest includes a list the user has not opened: %s", email.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no digest was sent")
+	}
+	if digest = m.Get(user); digest.SentAt == nil || digest.ClaimedUntil != nil {
+		t.Errorf("a sent digest was not marked as sent: %+v", digest)
+	}
+	m.SendDue(now)
+	select {
+	case <-emails:
+		t.Error("a sent digest was sent again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	m := Digests(config.Config{}, nil, nil, templates.Empty())
+	var body bytes.Buffer
+	if err := m.render(&body, "digest", templates.Attrs{}); err == nil {
+		t.Error("a missing template was rendered without an error")
+	}
+}
+
+func TestDigestEmailIsVerified(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	user := dbtest.User(t, conn, "")
+	defer conn.Execute(db.Users.Delete().Where(db.Users.C["id"].Equals(user.ID)))
+	smtp, emails, stop := mailtest.Listen(t)
+	defer stop()
+	m := Digests(config.Config{}, conn, mail.New(smtp), templates.Empty())
+
+	if _, err := m.Subscribe(user, db.Weekly); err != nil {
+		t.Fatalf("could not subscribe: %s", err)
+	}
+	if err := m.RequestEmail(user, "ann@example.com"); err != nil {
+		t.Fatalf("could not request an email address: %s", err)
+	}
+	var token string
+	select {
+	case email := <-emails:
+		link := regexp.MustCompile(`/digests/verify\?token=(\S+)`).FindStringSubmatch(email.Data)
+		if len(link) != 2 {
+			t.Fatalf("the email has no verification link: %s", email.Data)
+		}
+		token, _ = url.QueryUnescape(link[1])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no verification email was sent")
+	}
+
+	// The address is not used until it is verified
+	if digest := m.Get(user); digest.PendingEmail != "ann@example.com" {
+		t.Errorf("unexpected pending email %q", digest.PendingEmail)
+	}
+	var current db.User
+	conn.QueryOne(db.Users.Select().Where(db.Users.C["id"].Equals(user.ID)), &current)
+	if current.Email != "" {
+		t.Errorf("an unverified email was saved: %s", current.Email)
+	}
+
+	if verified, err := m.Verify(token); !verified || err != nil {
+		t.Fatalf("could not verify the email: %v", err)
+	}
+	conn.QueryOne(db.Users.Select().Where(db.Users.C["id"].Equals(user.ID)), &current)
+	if current.Email != "ann@example.com" {
+		t.Errorf("the verified email was not saved: %q", current.Email)
+	}
+	if verified, _ := m.Verify(token); verified {
+		t.Error("a verification link was used twice")
+	}
+}
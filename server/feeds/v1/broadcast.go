@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aodin/volta/config"
+)
+
+// The kinds of envelopes sent between hubs
+const (
+	MESSAGE  = "MESSAGE"  // A message for the connections of a list
+	PRESENCE = "PRESENCE" // The users of a list connected to the origin hub
+	SYNC     = "SYNC"     // A request for every hub to send its presence
+	RESYNC   = "RESYNC"   // The connections of a list missed a message
+)
+
+// Envelope wraps the messages that are fanned out to every hub. Presence
+// envelopes may include a message, such as the user that joined or left.
+// Stored envelopes are sent without the content of their message, which is
+// loaded from the event of its sequence. Envelopes with a broadcast are only
+// a reference to the whole envelope, which was too large to send.
+type Envelope struct {
+	Kind      string           `json:"kind"`
+	Origin    string           `json:"origin"`
+	ListID    int64            `json:"list_id,omitempty"`
+	UserID    int64            `json:"user_id,omitempty"` // Only sent to this user, if set
+	Message   *OutgoingMessage `json:"message,omitempty"`
+	Presence  []Presence       `json:"presence,omitempty"`
+	Stored    bool             `json:"stored,omitempty"`
+	Broadcast int64            `json:"broadcast,omitempty"`
+}
+
+// Broadcaster fans out envelopes to the subscribers of every hub instance,
+// including the instance that published the envelope.
+type Broadcaster interface {
+	Publish(Envelope) error
+	Subscribe(func(Envelope))
+	Close() error
+}
+
+// LocalBroadcaster delivers envelopes to subscribers in the same process
+type LocalBroadcaster struct {
+	sync.RWMutex
+	subscribers []func(Envelope)
+}
+
+var _ Broadcaster = &LocalBroadcaster{}
+
+// Publish synchronously delivers the envelope to all subscribers
+func (b *LocalBroadcaster) Publish(envelope Envelope) error {
+	b.RLock()
+	defer b.RUnlock()
+	for _, subscriber := range b.subscribers {
+		subscriber(envelope)
+	}
+	return nil
+}
+
+func (b *LocalBroadcaster) Subscribe(subscriber func(Envelope)) {
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+func (b *LocalBroadcaster) Close() error {
+	return nil
+}
+
+// NewLocalBroadcaster creates a broadcaster for a single process
+func NewLocalBroadcaster() *LocalBroadcaster {
+	return &LocalBroadcaster{}
+}
+
+// NewBroadcaster creates the broadcaster named by the given settings
+func NewBroadcaster(settings Settings, database config.DatabaseConfig) (Broadcaster, error) {
+	switch settings.Broadcaster {
+	case "", "local":
+		return NewLocalBroadcaster(), nil
+	case "postgres":
+		if database.Driver != "postgres" {
+			return nil, fmt.Errorf(
+				"feeds: the postgres broadcaster requires the postgres driver, not %s",
+				database.Driver,
+			)
+		}
+		return NewPostgresBroadcaster(database.Credentials(), settings.Channel)
+	}
+	return nil, fmt.Errorf("feeds: unknown broadcaster %s", settings.Broadcaster)
+}
@@ -0,0 +1,182 @@
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Thread is the content of requests for the comments of a thing
+type Thread struct {
+	ThingID int64 `json:"thing_id"`
+}
+
+// commented is a comment along with the name of its author
+type commented struct {
+	db.Comment
+	Name string `db:"name"`
+}
+
+// selectComments selects the comments of the given list that match the
+// clauses along with the names of their authors
+func selectComments(listID int64, clauses ...sql.Clause) sql.SelectStmt {
+	return db.Comments.Select(db.Users.C["name"]).JoinOn(
+		db.Things, db.Things.C["id"].Equals(db.Comments.C["thing_id"]),
+	).JoinOn(
+		db.Users, db.Users.C["id"].Equals(db.Comments.C["user_id"]),
+	).Where(
+		append([]sql.Clause{db.Things.C["list_id"].Equals(listID)}, clauses...)...,
+	)
+}
+
+// getComments returns the comments of a thing in the given list, oldest
+// first
+func getComments(conn sql.Connection, listID, thingID int64) ([]db.Comment, error) {
+	var rows []commented
+	stmt := selectComments(
+		listID, db.Comments.C["thing_id"].Equals(thingID),
+	).OrderBy(db.Comments.C["created_at"], db.Comments.C["id"])
+	if err := conn.QueryAll(stmt, &rows); err != nil {
+		return nil, err
+	}
+	comments := make([]db.Comment, len(rows))
+	for i, row := range rows {
+		comments[i] = row.Comment
+		comments[i].Author = row.Name
+	}
+	return comments, nil
+}
+
+// getComment returns a comment on a thing in the given list
+func getComment(conn sql.Connection, listID, id int64) (comment db.Comment, err error) {
+	var row commented
+	stmt := selectComments(listID, db.Comments.C["id"].Equals(id))
+	if err = conn.QueryOne(stmt, &row); err != nil {
+		if err == sql.ErrNoResult {
+			err = invalid("Comment does not exist")
+		}
+		return
+	}
+	comment = row.Comment
+	comment.Author = row.Name
+	return
+}
+
+// loadComments sets the number of comments on each thing
+func loadComments(conn sql.Connection, things Things) error {
+	if len(things) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(things))
+	for i := range things {
+		ids[i] = things[i].ID
+		things[i].Comments = 0
+	}
+	var counts []struct {
+		ThingID int64 `db:"thing_id"`
+		Count   int64 `db:"count"`
+	}
+	stmt := sql.Select(
+		db.Comments.C["thing_id"],
+		sql.Count(db.Comments.C["id"]).As("count"),
+	).Where(
+		db.Comments.C["thing_id"].In(ids),
+	).GroupBy(db.Comments.C["thing_id"])
+	if err := conn.QueryAll(stmt, &counts); err != nil {
+		return err
+	}
+	index := make(map[int64]int)
+	for i, thing := range things {
+		index[thing.ID] = i
+	}
+	for _, count := range counts {
+		things[index[count.ThingID]].Comments = count.Count
+	}
+	return nil
+}
+
+// handleComments creates, edits and deletes comments on the things of the
+// list. Comments can only be changed by their authors.
+func handleComments(conn sql.Connection, user db.User, listID int64, in IncomingMessage) (out OutgoingMessage, err error) {
+	out.Resource = "comments"
+	out.RequestID = in.RequestID
+
+	var comment db.Comment
+	if err = json.Unmarshal(in.Content, &comment); err != nil {
+		return
+	}
+	comment.Body = strings.TrimSpace(comment.Body)
+
+	switch in.Event {
+	case "create":
+		out.Event = CREATE
+		var thing db.Thing
+		if thing, err = getThing(conn, listID, comment.ThingID); err != nil {
+			return
+		}
+		if thing.IsDeleted() {
+			err = invalid("Thing has been deleted")
+			return
+		}
+		comment = db.NewComment(thing.ID, user, comment.Body)
+		if err = comment.Error(); err != nil {
+			return
+		}
+		stmt := pg.Insert(db.Comments).Values(comment).Returning(db.Comments)
+		if err = conn.QueryOne(stmt, &comment); err != nil {
+			return
+		}
+		comment.Author = user.Name
+		out.Content = comment
+	case "update":
+		out.Event = UPDATE
+		var current db.Comment
+		if current, err = authored(conn, user, listID, comment.ID); err != nil {
+			return
+		}
+		current.Body = comment.Body
+		if err = current.Error(); err != nil {
+			return
+		}
+		now := time.Now().UTC()
+		stmt := db.Comments.Update().Values(sql.Values{
+			"body":       current.Body,
+			"updated_at": now,
+		}).Where(db.Comments.C["id"].Equals(current.ID))
+		if _, err = conn.Execute(stmt); err != nil {
+			return
+		}
+		current.UpdatedAt = &now
+		out.Content = current
+	case "delete":
+		out.Event = DELETE
+		var current db.Comment
+		if current, err = authored(conn, user, listID, comment.ID); err != nil {
+			return
+		}
+		stmt := db.Comments.Delete().Where(db.Comments.C["id"].Equals(current.ID))
+		if _, err = conn.Execute(stmt); err != nil {
+			return
+		}
+		out.Content = current
+	default:
+		err = invalid("Unknown method: %s", in.Event)
+	}
+	return
+}
+
+// authored returns the comment if it was written by the given user
+func authored(conn sql.Connection, user db.User, listID, id int64) (comment db.Comment, err error) {
+	if comment, err = getComment(conn, listID, id); err != nil {
+		return
+	}
+	if comment.UserID != user.ID {
+		err = invalid("Only the author of a comment can change it")
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package v1
+
+import (
+	dbsql "database/sql"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// commentsConn is a list with a single thing and a single comment on it.
+// Changes are counted but not made.
+type commentsConn struct {
+	sql.Connection
+	thing   db.Thing
+	comment db.Comment
+	changes int
+}
+
+func (c *commentsConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	return nil
+}
+
+func (c *commentsConn) QueryOne(stmt sql.Executable, dst interface{}) error {
+	switch dst := dst.(type) {
+	case *db.Thing:
+		*dst = c.thing
+	case *commented:
+		*dst = commented{Comment: c.comment, Name: "author"}
+	case *db.Comment:
+		c.changes += 1 // The insert returns the comment as it was given
+	}
+	return nil
+}
+
+func (c *commentsConn) Execute(stmt sql.Executable, args ...interface{}) (dbsql.Result, error) {
+	c.changes += 1
+	return nil, nil
+}
+
+func TestCommentAuthorization(t *testing.T) {
+	author := db.User{ID: 1, Name: "author"}
+	other := db.User{ID: 2, Name: "other"}
+	request := func(conn *commentsConn, user db.User, event, content string) (OutgoingMessage, error) {
+		return handleComments(conn, user, 1, IncomingMessage{
+			Resource: "comments",
+			Event:    event,
+			Content:  []byte(content),
+		})
+	}
+	list := func() *commentsConn {
+		return &commentsConn{
+			thing:   db.Thing{ID: 1, ListID: 1, Version: 1, Content: `{"name": "Milk"}`},
+			comment: db.Comment{ID: 1, ThingID: 1, UserID: author.ID, Body: "Oat?"},
+		}
+	}
+
+	// Comments are written by the user that created them, whatever the
+	// content claims
+	conn := list()
+	out, err := request(conn, other, "create", `{"thing_id": 1, "user_id": 1, "body": "Soy"}`)
+	if err != nil {
+		t.Fatalf("could not create a comment: %s", err)
+	}
+	if comment := out.Content.(db.Comment); comment.UserID != other.ID || comment.Author != other.Name {
+		t.Errorf("unexpected author of %+v", comment)
+	}
+	conn.thing.DeletedAt = &conn.comment.CreatedAt
+	if _, err = request(conn, other, "create", `{"thing_id": 1, "body": "Soy"}`); err == nil {
+		t.Error("a deleted thing was commented")
+	}
+
+	// Only the author can change or delete a comment
+	for _, event := range []string{"update", "delete"} {
+		conn = list()
+		_, err = request(conn, other, event, `{"id": 1, "body": "Soy"}`)
+		if err == nil || err.Error() != "Only the author of a comment can change it" {
+			t.Errorf("unexpected error of an %s by another user: %v", event, err)
+		}
+		if conn.changes != 0 {
+			t.Errorf("the comment was changed by an %s of another user", event)
+		}
+		if _, err = request(conn, author, event, `{"id": 1, "body": "Soy"}`); err != nil {
+			t.Errorf("could not %s a comment as its author: %s", event, err)
+		}
+		if conn.changes != 1 {
+			t.Errorf("unexpected %d changes of an %s by the author", conn.changes, event)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Filters of the things in a LIST
+const (
+	All  = "all"
+	Open = "open" // Things that are not completed
+	Done = "done" // Completed things
+)
+
+// Toggle is the content of a "toggle" request
+type Toggle struct {
+	ID        int64 `json:"id"`
+	Completed bool  `json:"completed"`
+}
+
+// Query is the content of a "read" request for things. Connections with a
+// subscription are only sent the things with its tags.
+type Query struct {
+	Filter string   `json:"filter"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// filterClauses returns the clauses that select things matching the filter
+func filterClauses(filter string) ([]sql.Clause, error) {
+	switch filter {
+	case All, "":
+		return nil, nil
+	case Open:
+		return []sql.Clause{db.Things.C["completed"].Equals(false)}, nil
+	case Done:
+		return []sql.Clause{db.Things.C["completed"].Equals(true)}, nil
+	}
+	return nil, invalid("Unknown filter: %s", filter)
+}
+
+// toggleThing completes a thing, or reopens it, and returns its new copy
+func toggleThing(conn sql.Connection, listID int64, user db.User, toggle Toggle) (db.Thing, error) {
+	values := sql.Values{
+		"completed":    toggle.Completed,
+		"completed_at": nil,
+		"completed_by": nil,
+	}
+	if toggle.Completed {
+		values["completed_at"] = time.Now().UTC()
+		values["completed_by"] = user.ID
+	}
+	return setThing(conn, listID, toggle.ID, values)
+}
+
+// clearCompleted moves every completed thing of the list to the trash,
+// along with their sub-items. If the delete policy refuses, completed
+// things with sub-items are kept.
+func clearCompleted(conn sql.Connection, settings Settings, listID int64) (cleared Things, err error) {
+	var completed Things
+	stmt := db.Things.Select().Where(
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+		db.Things.C["completed"].Equals(true),
+	).OrderBy(db.Things.C["position"], db.Things.C["id"])
+	if err = conn.QueryAll(stmt, &completed); err != nil {
+		return
+	}
+
+	// Everything is deleted at the same time, so restoring any cleared
+	// thing also restores the sub-items cleared with it
+	now := time.Now().UTC()
+	trashed := make(map[int64]bool)
+	cleared = Things{}
+	for _, thing := range completed {
+		if trashed[thing.ID] {
+			continue
+		}
+		if settings.DeletePolicy == Refuse {
+			var descendants Things
+			if descendants, err = subtree(conn, listID, thing.ID, nil); err != nil {
+				return
+			}
+			if len(descendants) > 0 {
+				continue
+			}
+		}
+		if thing, err = trashThing(conn, listID, thing, now); err != nil {
+			return
+		}
+		for _, descendant := range flatten(thing.Children) {
+			trashed[descendant.ID] = true
+		}
+		cleared = append(cleared, thing)
+	}
+	return
+}
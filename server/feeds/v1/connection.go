@@ -0,0 +1,239 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// ErrClosed is returned when reading from a closed connection
+var ErrClosed = errors.New("Connection is closed")
+
+// Connection is a single websocket of a user. Outgoing messages are queued
+// and written by the connection's own writer goroutine, so a slow client
+// never blocks the hub or other clients.
+type Connection struct {
+	db.User
+	ListID       int64  // The list, or room, the connection joined
+	key          string // Session key
+	ws           *websocket.Conn
+	settings     Settings
+	send         chan Message
+	done         chan struct{}
+	once         sync.Once
+	mu           sync.Mutex // Guards holding, held and subscription
+	holding      bool
+	held         []Message
+	subscription Subscription
+	filter       string // The completion filter of its pages of things
+	lastSeen     int64  // Unix nanoseconds of the last message of any kind
+	lastActive   int64  // Unix nanoseconds of the last non-heartbeat message
+}
+
+func (c *Connection) String() string {
+	return fmt.Sprintf("%s (id: %d, list: %d)", c.User, c.User.ID, c.ListID)
+}
+
+// Seen records that a message was received from the client
+func (c *Connection) Seen() {
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+}
+
+// Active records that a message other than a heartbeat was received
+func (c *Connection) Active() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *Connection) LastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastSeen)).UTC()
+}
+
+func (c *Connection) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive)).UTC()
+}
+
+// Presence returns the user and the time they were last seen
+func (c *Connection) Presence() Presence {
+	return Presence{ID: c.User.ID, Name: c.User.Name, LastSeen: c.LastSeen()}
+}
+
+// Subscribe changes which things are sent to the connection
+func (c *Connection) Subscribe(subscription Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscription = subscription
+}
+
+// Subscription returns the things the connection is interested in
+func (c *Connection) Subscription() Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subscription
+}
+
+// Enqueue adds a message to the connection's outbound queue. If the queue is
+// full the client cannot keep up: it is disconnected and false is returned.
+// Messages are held instead while the connection's initial state is sent.
+// Things outside the connection's subscription are left out.
+func (c *Connection) Enqueue(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg, ok := c.subscription.Filter(msg)
+	if !ok {
+		return true
+	}
+	return c.push(msg)
+}
+
+// Reply enqueues a message regardless of the connection's subscription
+func (c *Connection) Reply(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.push(msg)
+}
+
+func (c *Connection) push(msg Message) bool {
+	if c.holding {
+		c.held = append(c.held, msg)
+		return true
+	}
+	return c.enqueue(msg)
+}
+
+func (c *Connection) enqueue(msg Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("%s could not keep up and was disconnected", c)
+		metrics.Evictions.Add(1)
+		c.Close()
+		return false
+	}
+}
+
+// Hold holds all enqueued messages until Release is called
+func (c *Connection) Hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holding = true
+}
+
+// Release sends the given initial messages followed by the held messages.
+// Held messages with a sequence at or before the given sequence are already
+// part of the initial state and are dropped. Unlike Enqueue, Release waits
+// for room in the queue, since the initial state may be larger than it.
+func (c *Connection) Release(sequence int64, initial ...Message) {
+	subscription := c.Subscription()
+	msgs := make([]Message, 0, len(initial))
+	for _, msg := range initial {
+		if msg, ok := subscription.Filter(msg); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	for {
+		for _, msg := range msgs {
+			select {
+			case c.send <- msg:
+			case <-c.done:
+				return
+			}
+		}
+
+		// Messages may have been held while the previous ones were sent
+		c.mu.Lock()
+		if len(c.held) == 0 {
+			c.holding = false
+			c.mu.Unlock()
+			return
+		}
+		msgs = make([]Message, 0, len(c.held))
+		for _, msg := range c.held {
+			if out, ok := msg.(OutgoingMessage); ok && out.Sequence != 0 && out.Sequence <= sequence {
+				continue
+			}
+			msgs = append(msgs, msg)
+		}
+		c.held = nil
+		c.mu.Unlock()
+	}
+}
+
+// Close stops the writer and ends the connection's read loop, which closes
+// the socket. It never blocks, so it is safe to call from the hub, and safe
+// to call more than once.
+func (c *Connection) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.SetReadDeadline(time.Now())
+
+		// Closing the websocket sends a close frame, which waits for any
+		// stalled write to finish
+		go c.ws.Close()
+	})
+}
+
+// Receive reads the next message from the client. The client must send a
+// message, if only a heartbeat, before the read timeout.
+func (c *Connection) Receive(msg *IncomingMessage) error {
+	if c.settings.ReadTimeout > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.settings.ReadTimeout))
+	}
+	// The deadline set by Close must not be replaced
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	if err := websocket.JSON.Receive(c.ws, msg); err != nil {
+		return err
+	}
+	c.Seen()
+	return nil
+}
+
+// write sends queued messages until the connection is closed
+func (c *Connection) write() {
+	for {
+		select {
+		case msg := <-c.send:
+			if c.settings.WriteTimeout > 0 {
+				c.ws.SetWriteDeadline(time.Now().Add(c.settings.WriteTimeout))
+			}
+			if err := websocket.JSON.Send(c.ws, msg); err != nil {
+				log.Printf("error: could not send to %s: %s", c, err)
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// NewConnection wraps the websocket and starts its writer goroutine
+func NewConnection(ws *websocket.Conn, listID int64, settings Settings) *Connection {
+	c := &Connection{
+		ListID:   listID,
+		ws:       ws,
+		settings: settings,
+		send:     make(chan Message, settings.QueueSize),
+		done:     make(chan struct{}),
+	}
+	c.Seen()
+	c.Active()
+	go c.write()
+	return c
+}
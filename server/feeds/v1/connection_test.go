@@ -0,0 +1,143 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/aodin/volta/config"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// sockets returns the server side of websockets dialed by the test. The
+// server side stays open until the returned function is called.
+func sockets(t *testing.T) (dial func() (server, client *websocket.Conn), stop func()) {
+	accepted := make(chan *websocket.Conn)
+	release := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		accepted <- ws
+		<-release
+	}))
+	dial = func() (*websocket.Conn, *websocket.Conn) {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		client, err := websocket.Dial(url, "", srv.URL)
+		if err != nil {
+			t.Fatalf("could not dial the test server: %s", err)
+		}
+		return <-accepted, client
+	}
+	stop = func() {
+		close(release)
+		srv.Close()
+	}
+	return
+}
+
+// testHub creates a hub without a database that broadcasts locally
+func testHub(settings Settings) *Hub {
+	return NewHub(
+		config.Config{}, settings, nil, nil, nil,
+		NewLocalBroadcaster(), nil, nil, nil,
+	)
+}
+
+func TestStalledWriterDoesNotBlockHub(t *testing.T) {
+	dial, stop := sockets(t)
+	defer stop()
+
+	settings := Settings{QueueSize: 2, PingInterval: time.Hour}
+	hub := testHub(settings)
+
+	// The writer of a client that never reads is stalled, so the stalled
+	// connection is never started and nothing drains its queue
+	stalledWS, stalledClient := dial()
+	defer stalledClient.Close()
+	stalled := &Connection{
+		User:     db.User{ID: 1, Name: "stalled"},
+		ListID:   1,
+		ws:       stalledWS,
+		settings: settings,
+		send:     make(chan Message, settings.QueueSize),
+		done:     make(chan struct{}),
+	}
+	hub.Join(stalled)
+
+	const n = 4
+	roomy := settings
+	roomy.QueueSize = n + 4
+	activeWS, activeClient := dial()
+	active := NewConnection(activeWS, 1, roomy)
+	active.User = db.User{ID: 2, Name: "active"}
+	hub.Join(active)
+
+	received := make(chan OutgoingMessage, n)
+	go func() {
+		for {
+			var msg OutgoingMessage
+			if err := websocket.JSON.Receive(activeClient, &msg); err != nil {
+				return
+			}
+			if msg.Resource == "things" {
+				received <- msg
+			}
+		}
+	}()
+
+	// Broadcasts return once the hub has taken the envelope, and the hub
+	// answers the request for users only after delivering it
+	broadcast := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			hub.Broadcast(1, OutgoingMessage{
+				Resource: "things",
+				Event:    UPDATE,
+				Sequence: int64(i + 1),
+				Content:  db.NewThing(1, "Milk"),
+			})
+		}
+		hub.Users(1)
+		close(broadcast)
+	}()
+	select {
+	case <-broadcast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hub was blocked by a stalled connection")
+	}
+
+	select {
+	case <-stalled.done:
+	default:
+		t.Error("the stalled connection was not evicted")
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-received:
+			if msg.Sequence != int64(i+1) {
+				t.Errorf("unexpected sequence %d, expected %d", msg.Sequence, i+1)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the active connection received %d of %d messages", i, n)
+		}
+	}
+	select {
+	case <-active.done:
+		t.Error("the active connection was evicted")
+	default:
+	}
+}
+
+func TestPresenceHasNoEmail(t *testing.T) {
+	c := NewConnection(nil, 1, DefaultSettings)
+	c.User = db.User{ID: 1, Name: "Ann", Email: "ann@example.com"}
+	b, err := json.Marshal(c.Presence())
+	if err != nil {
+		t.Fatalf("could not encode presence: %s", err)
+	}
+	if strings.Contains(string(b), "ann@example.com") {
+		t.Errorf("the presence of a user includes their email: %s", b)
+	}
+}
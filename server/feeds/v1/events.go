@@ -5,6 +5,60 @@ const (
 	UPDATE = "UPDATE"
 	DELETE = "DELETE"
 	LIST   = "LIST"
+	ERROR  = "ERROR"
+
+	// Sent when a deleted thing is taken out of the trash
+	RESTORE = "RESTORE"
+
+	// Sent when things change position, with every thing that moved
+	MOVE = "MOVE"
+
+	// Sent when a thing is nested under another thing or moved to the top
+	REPARENT = "REPARENT"
+
+	// Sent when the sub-items of a thing are hidden or shown
+	COLLAPSE = "COLLAPSE"
+
+	// Sent when a thing is completed or reopened
+	TOGGLE = "TOGGLE"
+
+	// Sent when every completed thing is moved to the trash at once
+	CLEAR = "CLEAR"
+
+	// Sent when the due date or assignee of a thing changes
+	SCHEDULE = "SCHEDULE"
+
+	// Sent to the assignee of a thing when it becomes due, or to every
+	// user of the list if it has no assignee
+	REMINDER = "REMINDER"
+
+	// Sent when a tag is added to or removed from a thing
+	TAG   = "TAG"
+	UNTAG = "UNTAG"
+
+	// Sent only to the client that searched, with the matching things
+	SEARCH = "SEARCH"
+
+	// Sent only to the client that asked for the next page of things
+	LIST_MORE = "LIST_MORE"
+
+	// Sent only to the client that asked for the revisions of a thing
+	HISTORY = "HISTORY"
+
+	// Sent instead of an ERROR when a change was based on an outdated
+	// version, with the current version as content
+	CONFLICT = "CONFLICT"
 )
 
-// TODO CONNECT and DISCONNECT?
+// Presence events of the "users" resource
+const (
+	CONNECT    = "CONNECT"
+	DISCONNECT = "DISCONNECT"
+)
+
+// Heartbeat events of the "connection" resource. The server sends PING and
+// clients must reply with "pong".
+const (
+	PING = "PING"
+	PONG = "pong"
+)
@@ -0,0 +1,176 @@
+package v1
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// History is the content of "history" requests
+type History struct {
+	ID int64 `json:"id"`
+}
+
+// Revert is the content of "revert" requests. The version is the current
+// version of the thing, as with updates.
+type Revert struct {
+	ID       int64 `json:"id"`
+	Revision int64 `json:"revision"`
+	Version  int64 `json:"version"`
+}
+
+// Diff is the change of a single content field between revisions. Added
+// fields have no before value and removed fields have no after value.
+type Diff struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Change is a revision with its content and how it differs from the
+// revision before it
+type Change struct {
+	db.Revision
+	Author string                 `json:"author"`
+	Fields map[string]interface{} `json:"fields"`
+	Diffs  []Diff                 `json:"diffs"`
+}
+
+// diff returns the fields that differ between the two contents, sorted by
+// field name
+func diff(before, after map[string]interface{}) []Diff {
+	diffs := []Diff{}
+	for field, value := range after {
+		if previous, ok := before[field]; !ok || !reflect.DeepEqual(previous, value) {
+			diffs = append(diffs, Diff{Field: field, Before: previous, After: value})
+		}
+	}
+	for field, value := range before {
+		if _, ok := after[field]; !ok {
+			diffs = append(diffs, Diff{Field: field, Before: value})
+		}
+	}
+	sort.Sort(byField(diffs))
+	return diffs
+}
+
+type byField []Diff
+
+func (d byField) Len() int           { return len(d) }
+func (d byField) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byField) Less(i, j int) bool { return d[i].Field < d[j].Field }
+
+// revise records a revision of every thing changed by the request. The
+// things of a CLEAR and the sub-items of a deleted thing are each recorded
+// as deleted.
+func revise(conn sql.Connection, user db.User, event string, content interface{}) error {
+	var things Things
+	action := event
+	switch event {
+	case "create", "update", "revert":
+		thing, ok := content.(db.Thing)
+		if !ok {
+			return nil
+		}
+		things = Things{thing}
+	case "delete":
+		thing, ok := content.(db.Thing)
+		if !ok {
+			return nil
+		}
+		things = flatten(Things{thing})
+	case "clear":
+		cleared, ok := content.(Things)
+		if !ok {
+			return nil
+		}
+		action = "delete"
+		things = flatten(cleared)
+	default:
+		return nil
+	}
+	for _, thing := range things {
+		stmt := db.Revisions.Insert().Values(db.NewRevision(thing, user, action))
+		if _, err := conn.Execute(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getHistory returns the changes to a thing of the list, newest first
+func getHistory(conn sql.Connection, listID, id int64) ([]Change, error) {
+	if _, err := getThing(conn, listID, id); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		db.Revision
+		Name *string `db:"name"`
+	}
+	stmt := db.Revisions.Select(db.Users.C["name"]).LeftOuterJoinOn(
+		db.Users, db.Users.C["id"].Equals(db.Revisions.C["user_id"]),
+	).Where(
+		db.Revisions.C["thing_id"].Equals(id),
+	).OrderBy(db.Revisions.C["id"])
+	if err := conn.QueryAll(stmt, &rows); err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, len(rows))
+	var previous map[string]interface{}
+	for i, row := range rows {
+		change := Change{Revision: row.Revision}
+		if row.Name != nil {
+			change.Author = *row.Name
+		}
+		if err := json.Unmarshal([]byte(row.Content), &change.Fields); err != nil {
+			return nil, err
+		}
+		change.Diffs = diff(previous, change.Fields)
+		previous = change.Fields
+
+		// Newest first
+		changes[len(rows)-1-i] = change
+	}
+	return changes, nil
+}
+
+// revertThing sets the content of a thing back to that of one of its
+// revisions and returns its new copy. The old content must still be valid
+// for the schema.
+func revertThing(conn sql.Connection, schema db.Schema, listID int64, revert Revert) (thing db.Thing, err error) {
+	if thing, err = getThing(conn, listID, revert.ID); err != nil {
+		return
+	}
+	var revision db.Revision
+	stmt := db.Revisions.Select().Where(
+		db.Revisions.C["id"].Equals(revert.Revision),
+		db.Revisions.C["thing_id"].Equals(thing.ID),
+	)
+	if err = conn.QueryOne(stmt, &revision); err != nil {
+		if err == sql.ErrNoResult {
+			err = invalid("Revision does not exist")
+		}
+		return
+	}
+	var content map[string]interface{}
+	if err = json.Unmarshal([]byte(revision.Content), &content); err != nil {
+		return
+	}
+	if content, err = schema.Clean(content); err != nil {
+		return
+	}
+	if err = thing.SetFields(content); err != nil {
+		return
+	}
+	thing.Version = revert.Version
+	values := thing.Values()
+	values["version"] = thing.Version + 1
+	values["updated_at"] = time.Now().UTC()
+	return changeThing(conn, listID, thing, values, false)
+}
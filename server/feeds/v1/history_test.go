@@ -0,0 +1,89 @@
+package v1
+
+import (
+	dbsql "database/sql"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// revisionsConn has a single thing at its current version with a single
+// revision. Updates only change a row when they are not stale.
+type revisionsConn struct {
+	sql.Connection
+	current  db.Thing
+	revision *db.Revision
+	stale    bool
+}
+
+type affected int64
+
+func (n affected) LastInsertId() (int64, error) { return 0, nil }
+func (n affected) RowsAffected() (int64, error) { return int64(n), nil }
+
+func (c *revisionsConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	return nil
+}
+
+func (c *revisionsConn) QueryOne(stmt sql.Executable, dst interface{}) error {
+	switch dst := dst.(type) {
+	case *db.Thing:
+		*dst = c.current
+	case *db.Revision:
+		if c.revision == nil {
+			return sql.ErrNoResult
+		}
+		*dst = *c.revision
+	}
+	return nil
+}
+
+func (c *revisionsConn) Execute(stmt sql.Executable, args ...interface{}) (dbsql.Result, error) {
+	if c.stale {
+		return affected(0), nil
+	}
+	return affected(1), nil
+}
+
+func TestRevertConflicts(t *testing.T) {
+	conn := &revisionsConn{
+		current:  db.Thing{ID: 1, ListID: 1, Version: 3, Content: `{"name": "Oat milk"}`},
+		revision: &db.Revision{ID: 1, ThingID: 1, Version: 1, Content: `{"name": "Milk"}`},
+		stale:    true,
+	}
+
+	// Reverts based on an outdated version conflict with the current thing
+	_, err := revertThing(conn, db.DefaultSchema, 1, Revert{ID: 1, Revision: 1, Version: 2})
+	conflict, ok := err.(ConflictError)
+	if !ok {
+		t.Fatalf("unexpected error of a stale revert: %v", err)
+	}
+	if conflict.Version != 2 || conflict.Current.Version != 3 || conflict.Current.String() != "Oat milk" {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+	if _, err = revertThing(conn, db.DefaultSchema, 1, Revert{ID: 1, Revision: 1}); err == nil {
+		t.Error("a revert without a version was made")
+	} else if _, ok := err.(ConflictError); ok {
+		t.Error("a revert without a version was a conflict")
+	}
+
+	conn.stale = false
+	if _, err = revertThing(conn, db.DefaultSchema, 1, Revert{ID: 1, Revision: 1, Version: 3}); err != nil {
+		t.Errorf("could not revert the current version: %s", err)
+	}
+
+	// Revisions must be of the thing and valid for the current schema
+	strict := db.Schema{Strict: true, Fields: map[string]db.Field{
+		"title": {Type: db.String, Required: true},
+	}}
+	if _, err = revertThing(conn, strict, 1, Revert{ID: 1, Revision: 1, Version: 3}); err == nil {
+		t.Error("a revision that is invalid for the schema was reverted to")
+	}
+	conn.revision = nil
+	_, err = revertThing(conn, db.DefaultSchema, 1, Revert{ID: 1, Revision: 2, Version: 3})
+	if err == nil || err.Error() != "Revision does not exist" {
+		t.Errorf("unexpected error of a missing revision: %v", err)
+	}
+}
@@ -1,235 +1,678 @@
 package v1
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
 	"code.google.com/p/go.net/websocket"
 	sql "github.com/aodin/aspect"
-	pg "github.com/aodin/aspect/postgres"
 	"github.com/aodin/volta/config"
 
 	db "github.com/aodin/listofthings/db"
 	"github.com/aodin/listofthings/server/auth"
+	"github.com/aodin/listofthings/server/lists"
+	"github.com/aodin/listofthings/server/mail"
 )
 
-type Connection struct {
-	db.User
-	key string // Session key
-	ws  *websocket.Conn
+// Room holds the connections of a single list
+type Room map[*Connection]bool
+
+// membership is a request to join or leave a room. The reply receives the
+// local users of the room after the change.
+type membership struct {
+	connection *Connection
+	at         time.Time
+	reply      chan []Presence
+}
+
+// received is an envelope from the broadcaster waiting for the hub
+type received struct {
+	envelope Envelope
+	at       time.Time
+}
+
+// usersRequest asks the hub for the users of a list
+type usersRequest struct {
+	listID int64
+	reply  chan []Presence
 }
 
-func (c Connection) String() string {
-	return fmt.Sprintf("%s (id: %d)", c.User, c.User.ID)
+// Notifier is told of every change committed through the hub, such as to
+// post it to webhooks. Notify is called within the transaction of the
+// change, which is rolled back if it fails, and Wake once it is committed.
+type Notifier interface {
+	Notify(tx sql.Connection, listID int64, out OutgoingMessage) error
+	Wake()
 }
 
-// Hub matches session keys to connections
+// Hub groups connections into rooms by the list they joined. Its state is
+// owned by a single goroutine and all access goes through its channels.
+// Messages are fanned out through the broadcaster so that they reach the
+// connections of every hub instance.
 type Hub struct {
-	sync.RWMutex
+	id          string // Identifies this hub to other instances
 	config      config.Config
+	settings    Settings
 	conn        sql.Connection
 	sessions    *auth.SessionManager
-	connections map[string]Connection
+	lists       *lists.ListManager
+	broadcaster Broadcaster
+	searcher    Searcher
+	mailer      mail.Mailer
+	notifier    Notifier
+
+	join      chan membership
+	leave     chan membership
+	envelopes chan received
+	users     chan usersRequest
+
+	// State owned by the run goroutine
+	rooms  map[int64]Room
+	remote map[int64]map[string]remotePresence // Users of other instances
+}
+
+// run owns the state of the hub. It must never block on a connection or
+// the broadcaster.
+func (hub *Hub) run() {
+	heartbeat := time.NewTicker(hub.settings.PingInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case now := <-heartbeat.C:
+			hub.heartbeat(now)
+
+		case m := <-hub.join:
+			room, exists := hub.rooms[m.connection.ListID]
+			if !exists {
+				room = Room{}
+				hub.rooms[m.connection.ListID] = room
+			}
+			room[m.connection] = true
+			metrics.Connections.Add(1)
+			m.reply <- hub.localUsers(m.connection.ListID)
+			metrics.Join.Since(m.at)
+
+		case m := <-hub.leave:
+			listID := m.connection.ListID
+			if hub.rooms[listID][m.connection] {
+				delete(hub.rooms[listID], m.connection)
+				metrics.Connections.Add(-1)
+			}
+			if len(hub.rooms[listID]) == 0 {
+				delete(hub.rooms, listID)
+			}
+			m.reply <- hub.localUsers(listID)
+			metrics.Leave.Since(m.at)
+
+		case r := <-hub.envelopes:
+			hub.receive(r.envelope)
+			metrics.Broadcast.Since(r.at)
+
+		case r := <-hub.users:
+			r.reply <- hub.allUsers(r.listID)
+		}
+	}
+}
+
+// receive handles envelopes from the broadcaster. It is called by the run
+// goroutine.
+func (hub *Hub) receive(envelope Envelope) {
+	switch envelope.Kind {
+	case MESSAGE:
+		if envelope.Message == nil {
+			return
+		}
+		msg := decodeMessage(*envelope.Message)
+		if envelope.UserID != 0 {
+			hub.deliverTo(envelope.ListID, envelope.UserID, msg)
+		} else {
+			hub.deliver(envelope.ListID, msg)
+		}
+	case PRESENCE:
+		if envelope.Origin != hub.id {
+			if len(envelope.Presence) == 0 {
+				delete(hub.remote[envelope.ListID], envelope.Origin)
+			} else {
+				if hub.remote[envelope.ListID] == nil {
+					hub.remote[envelope.ListID] = make(map[string]remotePresence)
+				}
+				hub.remote[envelope.ListID][envelope.Origin] = remotePresence{
+					users: envelope.Presence,
+					at:    time.Now(),
+				}
+			}
+		}
+		msg := envelope.Message
+		if msg == nil {
+			return
+		}
+		// Users with another connection to the list are still present
+		if msg.Event == DISCONNECT && hub.isPresent(envelope.ListID, msg.Content) {
+			return
+		}
+		hub.deliver(envelope.ListID, *msg)
+	case SYNC:
+		if envelope.Origin == hub.id {
+			return
+		}
+		// Publishing may loop back to this hub, so it must be done
+		// outside the run goroutine
+		presence := make([]Envelope, 0, len(hub.rooms))
+		for id := range hub.rooms {
+			presence = append(presence, Envelope{
+				Kind:     PRESENCE,
+				Origin:   hub.id,
+				ListID:   id,
+				Presence: hub.localUsers(id),
+			})
+		}
+		// An envelope without an origin was created by the broadcaster
+		// itself after it lost its connection: ask the others to resend
+		if envelope.Origin == "" {
+			presence = append(presence, Envelope{Kind: SYNC, Origin: hub.id})
+		}
+		go func() {
+			for _, e := range presence {
+				hub.publish(e)
+			}
+		}()
+	case RESYNC:
+		// Reconnecting clients are sent the events they missed
+		for connection := range hub.rooms[envelope.ListID] {
+			log.Printf("%s missed an event and was disconnected", connection)
+			connection.Close()
+		}
+	}
+}
+
+// deliver queues a message on every local connection of the given list. It
+// is called by the run goroutine.
+func (hub *Hub) deliver(listID int64, msg Message) {
+	for connection := range hub.rooms[listID] {
+		connection.Enqueue(msg)
+	}
+}
+
+// deliverTo queues a message on the local connections of a single user of
+// the given list. It is called by the run goroutine.
+func (hub *Hub) deliverTo(listID, userID int64, msg Message) {
+	for connection := range hub.rooms[listID] {
+		if connection.User.ID == userID {
+			connection.Enqueue(msg)
+		}
+	}
+}
+
+// heartbeat pings every local connection, disconnects idle connections,
+// expires the presence of silent instances and re-sends this instance's
+// presence. It is called by the run goroutine.
+func (hub *Hub) heartbeat(now time.Time) {
+	ping := OutgoingMessage{
+		Resource: "connection",
+		Event:    PING,
+		Content:  now.UTC(),
+	}
+	presence := make([]Envelope, 0, len(hub.rooms))
+	for id, room := range hub.rooms {
+		for connection := range room {
+			idle := now.Sub(connection.LastActive())
+			if hub.settings.IdleTimeout > 0 && idle > hub.settings.IdleTimeout {
+				// Closing does not wait for the writer, which may be stalled
+				log.Printf("%s was idle for %s and was disconnected", connection, idle)
+				connection.Close()
+				continue
+			}
+			connection.Enqueue(ping)
+		}
+		presence = append(presence, Envelope{
+			Kind:     PRESENCE,
+			Origin:   hub.id,
+			ListID:   id,
+			Presence: hub.localUsers(id),
+		})
+	}
+
+	// Instances that stopped sending their presence are gone
+	for id, instances := range hub.remote {
+		var expired bool
+		for origin, remote := range instances {
+			if now.Sub(remote.at) > hub.settings.ReadTimeout {
+				delete(instances, origin)
+				expired = true
+			}
+		}
+		if expired {
+			hub.deliver(id, OutgoingMessage{
+				Resource: "users",
+				Event:    LIST,
+				Content:  hub.allUsers(id),
+			})
+		}
+	}
+
+	// Publishing may loop back to this hub
+	go func() {
+		for _, e := range presence {
+			hub.publish(e)
+		}
+	}()
+}
+
+// isPresent returns true if the user in the given presence content is still
+// connected to the list. It is called by the run goroutine.
+func (hub *Hub) isPresent(listID int64, content interface{}) bool {
+	// Content from other instances has been decoded as a generic map
+	var userID int64
+	switch c := content.(type) {
+	case Presence:
+		userID = c.ID
+	case map[string]interface{}:
+		id, _ := c["id"].(float64)
+		userID = int64(id)
+	}
+	for _, user := range hub.allUsers(listID) {
+		if user.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// localUsers is called by the run goroutine
+func (hub *Hub) localUsers(listID int64) []Presence {
+	room := hub.rooms[listID]
+	users := make([]Presence, 0, len(room))
+	for connection := range room {
+		users = append(users, connection.Presence())
+	}
+	return users
+}
+
+// allUsers is called by the run goroutine. Users connected more than once
+// are only included once, with their most recent last seen time.
+func (hub *Hub) allUsers(listID int64) []Presence {
+	users := hub.localUsers(listID)
+	for _, remote := range hub.remote[listID] {
+		users = append(users, remote.users...)
+	}
+	index := make(map[int64]int)
+	unique := make([]Presence, 0, len(users))
+	for _, user := range users {
+		i, seen := index[user.ID]
+		if !seen {
+			index[user.ID] = len(unique)
+			unique = append(unique, user)
+		} else if user.LastSeen.After(unique[i].LastSeen) {
+			unique[i].LastSeen = user.LastSeen
+		}
+	}
+	return unique
+}
+
+// Receive passes an envelope from the broadcaster to the hub
+func (hub *Hub) Receive(envelope Envelope) {
+	hub.envelopes <- received{envelope: envelope, at: time.Now()}
+}
+
+// Broadcast sends a message to all users of the given list on every
+// hub instance
+func (hub *Hub) Broadcast(listID int64, msg OutgoingMessage) {
+	hub.publish(Envelope{
+		Kind:    MESSAGE,
+		Origin:  hub.id,
+		ListID:  listID,
+		Message: &msg,
+	})
+}
+
+// Record saves the event of a change made outside of the websockets, such
+// as an upload, and broadcasts it to the users of the list
+func (hub *Hub) Record(listID int64, out OutgoingMessage) error {
+	tx, err := hub.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err = recordEvent(tx, listID, &out); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	hub.Broadcast(listID, out)
+	return nil
 }
 
-// Broadcast sends a message to all users
-func (hub *Hub) Broadcast(msg Message) {
-	for _, user := range hub.connections {
-		// TODO error ignored
-		_ = websocket.JSON.Send(user.ws, msg)
+func (hub *Hub) publish(envelope Envelope) {
+	start := time.Now()
+	if err := hub.broadcaster.Publish(envelope); err != nil {
+		log.Printf("error: could not publish %s envelope: %s", envelope.Kind, err)
 	}
+	metrics.Publish.Since(start)
 }
 
-func (hub *Hub) Join(connection Connection) {
+// Send queues a message for a single connection
+func (hub *Hub) Send(connection *Connection, msg Message) {
+	connection.Enqueue(msg)
+}
+
+// Join adds the connection to the room of its list and broadcasts the
+// user's arrival
+func (hub *Hub) Join(connection *Connection) {
+	reply := make(chan []Presence, 1)
+	hub.join <- membership{connection: connection, at: time.Now(), reply: reply}
+
 	// Log and broadcast the event
 	log.Printf("%s joined\n", connection)
 	msg := OutgoingMessage{
 		Resource: "users",
-		Event:    CREATE,
-		Content:  connection.User,
+		Event:    CONNECT,
+		Content:  connection.Presence(),
 	}
-	hub.Broadcast(msg)
-
-	// Add the connection to the hub
-	hub.Lock()
-	defer hub.Unlock()
-	hub.connections[connection.key] = connection
+	hub.publish(Envelope{
+		Kind:     PRESENCE,
+		Origin:   hub.id,
+		ListID:   connection.ListID,
+		Message:  &msg,
+		Presence: <-reply,
+	})
 }
 
-func (hub *Hub) Leave(connection Connection) {
-	// Remove the connection from the hub
-	hub.Lock()
-	delete(hub.connections, connection.key)
-	hub.Unlock()
+// Leave removes the connection from its room and broadcasts the user's
+// departure
+func (hub *Hub) Leave(connection *Connection) {
+	reply := make(chan []Presence, 1)
+	hub.leave <- membership{connection: connection, at: time.Now(), reply: reply}
+	connection.Close()
 
 	// Log and broadcast the event
 	log.Printf("%s left\n", connection)
 	msg := OutgoingMessage{
 		Resource: "users",
-		Event:    DELETE,
-		Content:  connection.User,
+		Event:    DISCONNECT,
+		Content:  connection.Presence(),
 	}
-	hub.Broadcast(msg)
+	hub.publish(Envelope{
+		Kind:     PRESENCE,
+		Origin:   hub.id,
+		ListID:   connection.ListID,
+		Message:  &msg,
+		Presence: <-reply,
+	})
 }
 
-func (hub *Hub) Users() []db.User {
+// Users returns the users connected to the given list across all hub
+// instances
+func (hub *Hub) Users(listID int64) []Presence {
 	// This list will include the requesting user
 	// TODO Does order matter?
-	users := make([]db.User, len(hub.connections))
-	var i int
-	for _, connection := range hub.connections {
-		users[i] = connection.User
-		i += 1
-	}
-	return users
+	reply := make(chan []Presence, 1)
+	hub.users <- usersRequest{listID: listID, reply: reply}
+	return <-reply
 }
 
-func unmarshalThing(msg IncomingMessage) (thing db.Thing, err error) {
-	if err = json.Unmarshal(msg.Content, &thing); err != nil {
+// HandleMessage handles a message from the given connection. Successful
+// changes are broadcast to all users of the connection's list, but errors
+// are only sent back to the connection that sent the message.
+func (hub *Hub) HandleMessage(connection *Connection, in IncomingMessage) {
+	log.Println("Handling message:", in)
+	// TODO Check the resources - whitelist?
+	// TODO Handle user renames
+	if in.Event == "subscribe" {
+		if err := hub.subscribe(connection, in); err != nil {
+			log.Printf("error: %s sent %s: %s", connection, in, err)
+			hub.Send(connection, ErrorMessage(in, err))
+		}
+		return
+	}
+	if isQuery(in) {
+		out, err := hub.Query(connection.ListID, connection.Subscription().scope(in))
+		if err != nil {
+			log.Printf("error: %s sent %s: %s", connection, in, err)
+			hub.Send(connection, ErrorMessage(in, err))
+			return
+		}
+		hub.Send(connection, out)
 		return
 	}
-	// TODO remove the timestamps from the content?
-	// TODO whitelisted fields?
-	thing.Content = string(msg.Content)
-	err = thing.Error()
-	return
-}
 
-type Things []db.Thing
+	out, err := hub.Commit(connection.ListID, connection.User, in)
+	if conflict, ok := err.(ConflictError); ok {
+		log.Printf("conflict: %s sent %s: %s", connection, in, err)
+		hub.Send(connection, ConflictMessage(in, conflict.Current))
+		return
+	} else if err != nil {
+		log.Printf("error: %s sent %s: %s", connection, in, err)
+		hub.Send(connection, ErrorMessage(in, err))
+		return
+	}
 
-func (t Things) Mutate() {
-	for i, thing := range t {
-		// TODO ignored error
-		json.Unmarshal([]byte(thing.Content), &thing)
+	// The sender is always told the result of its own request
+	if _, ok := connection.Subscription().Filter(out); !ok {
+		connection.Reply(out)
+	}
+}
 
-		// Only copy content fields - preserve system info
-		t[i].Name = thing.Name
+// Commit applies a change to the list as the given user and broadcasts its
+// event, as for messages from websockets. Changes made through the REST API
+// are committed with it so that connected clients see them live.
+func (hub *Hub) Commit(listID int64, user db.User, in IncomingMessage) (out OutgoingMessage, err error) {
+	if out, err = hub.commit(listID, user, in); err != nil {
+		return
+	}
+	log.Println("Broadcasting:", out)
+	hub.Broadcast(listID, out)
+	if hub.notifier != nil {
+		hub.notifier.Wake()
 	}
+	return
+}
+
+// Thing returns the current copy of a thing of the list, which may be in
+// the trash
+func (hub *Hub) Thing(listID, id int64) (db.Thing, error) {
+	return getThing(hub.conn, listID, id)
 }
 
-// TODO error?
-func getThings(conn sql.Connection) (things Things) {
-	things = Things{}
-	conn.MustQueryAll(db.Things.Select().Where(
-		db.Things.C["deleted_at"].IsNull(),
-	).OrderBy(db.Things.C["id"]), &things)
-	things.Mutate()
+// commit applies the message and records the resulting event in a single
+// transaction. The list is locked first, so the changes to a list are
+// applied one at a time.
+func (hub *Hub) commit(listID int64, user db.User, in IncomingMessage) (out OutgoingMessage, err error) {
+	tx, err := hub.conn.Begin()
+	if err != nil {
+		return
+	}
+	if err = lockList(tx, listID); err != nil {
+		tx.Rollback()
+		return
+	}
+	if in.Resource == "comments" {
+		out, err = handleComments(tx, user, listID, in)
+	} else {
+		out, err = handleThings(tx, hub.settings, user, listID, in)
+	}
+	if err == nil {
+		err = recordEvent(tx, listID, &out)
+	}
+	if err == nil && hub.notifier != nil {
+		err = hub.notifier.Notify(tx, listID, out)
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
 	return
 }
 
-func (hub *Hub) HandleMessage(in IncomingMessage) {
-	log.Println("Handling message:", in)
-	// TODO Check the resources - whitelist?
-	// TODO Handle user renames
+// Handler is the websocket handler for the default list
+func (hub *Hub) Handler(ws *websocket.Conn) {
+	list := hub.lists.Default()
+	if !list.Exists() {
+		log.Printf("No default list exists")
+		return
+	}
+	hub.serve(ws, list)
+}
 
-	var err error
-	var out OutgoingMessage
-	if in.Resource != "things" {
-		err = fmt.Errorf("Unknown resource: %s", in.Resource)
-	}
-	out.Resource = "things"
-
-	// TODO errors will overwrite
-	// TODO persist the changes
-	var thing db.Thing
-	switch in.Event {
-	case "create":
-		out.Event = CREATE
-		if thing, err = unmarshalThing(in); err == nil {
-			stmt := pg.Insert(db.Things).Values(thing).Returning(db.Things)
-			err = hub.conn.QueryOne(stmt, &thing)
-			out.Content = thing
-		}
-	case "delete":
-		out.Event = DELETE
-		// TODO confirm ID?
-		if thing, err = unmarshalThing(in); err == nil {
-			stmt := db.Things.Delete().Where(
-				db.Things.C["id"].Equals(thing.ID),
-			)
-			_, err = hub.conn.Execute(stmt)
-			out.Content = thing
-		}
-	case "update":
-		out.Event = UPDATE
-		// TODO confirm ID?
-		if thing, err = unmarshalThing(in); err == nil {
-			stmt := db.Things.Update().Values(thing.Values()).Where(
-				db.Things.C["id"].Equals(thing.ID),
-			)
-			_, err = hub.conn.Execute(stmt)
-			out.Content = thing
-		}
-	default:
-		err = fmt.Errorf("Unknown method: %s", in.Event)
-	}
-
-	// TODO return an error that will be sent to the sender only
+// ListHandler is the websocket handler for lists. The list ID is parsed
+// from paths of the form /feeds/v1/lists/{id}/things
+func (hub *Hub) ListHandler(ws *websocket.Conn) {
+	id, err := ParseListID(ws.Request().URL.Path)
 	if err != nil {
 		log.Printf("error: %s", err)
 		return
 	}
+	list := hub.lists.Get(id)
+	if !list.Exists() {
+		log.Printf("No list with id: %d", id)
+		return
+	}
+	hub.serve(ws, list)
+}
 
-	log.Println("Broadcasting:", out)
-	hub.Broadcast(out)
+// ParseListID returns the list ID from the given websocket path
+func ParseListID(path string) (int64, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 || parts[2] != "lists" || parts[4] != "things" {
+		return 0, fmt.Errorf("Invalid list path: %s", path)
+	}
+	return strconv.ParseInt(parts[3], 10, 64)
 }
 
-// Handler is the main websocket handler for users
-func (hub *Hub) Handler(ws *websocket.Conn) {
-	// Wrap the user, session key, and websocket together
-	conn := Connection{ws: ws}
+// serve is the main websocket handler for users of the given list
+func (hub *Hub) serve(ws *websocket.Conn, list db.List) {
+	// Wrap the user, session key, list, and websocket together
+	conn := NewConnection(ws, list.ID, hub.settings)
+	defer conn.Close()
 
 	// Examine the request for the session key and user
 	r := ws.Request()
-	if cookie, err := r.Cookie(hub.config.Cookie.Name); err == nil {
-		conn.key = cookie.Value
-	}
+	conn.key = auth.RequestKey(r, hub.config.Cookie)
 
 	// TODO this will request users even if cookie value was "" - shortcircuit?
 	if conn.User = hub.sessions.GetUser(conn.key); !conn.User.Exists() {
 		log.Printf("No user with session: %s", conn.key)
 		return
 	}
+	if err := hub.lists.Join(list, conn.User); err != nil {
+		log.Printf("error: %s could not join list %d: %s", conn.User, list.ID, err)
+	}
+
+	// Clients may subscribe to only the things with some tags, and only
+	// want the open or done things of the list
+	subscription := Subscription{Tags: r.URL.Query()["tag"]}
+	conn.Subscribe(subscription)
+	conn.filter = r.URL.Query().Get("filter")
+	if _, err := filterClauses(conn.filter); err != nil {
+		log.Printf("error: %s", err)
+		return
+	}
 
+	// Messages are held until the initial state has been sent
+	conn.Hold()
 	hub.Join(conn)
 
 	// Send the initial state of the users list
-	msg := OutgoingMessage{
-		Resource: "users",
-		Event:    LIST,
-		Content:  hub.Users(),
+	initial := []Message{
+		OutgoingMessage{
+			Resource: "users",
+			Event:    LIST,
+			Content:  hub.Users(list.ID),
+		},
 	}
-	websocket.JSON.Send(ws, msg)
 
-	// Send the initial state of the data
-	msg = OutgoingMessage{
-		Resource: "things",
-		Event:    LIST,
-		Content:  getThings(hub.conn),
+	// Reconnecting clients send the sequence of the last event they saw and
+	// are sent the events they missed. If those events cannot be replayed,
+	// or the client is new, the first page of the list is sent.
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	events, ok := replayEvents(hub.conn, list.ID, since, hub.settings.ReplayLimit)
+	if ok {
+		initial = append(initial, events...)
+		if len(events) > 0 {
+			since = events[len(events)-1].(OutgoingMessage).Sequence
+		}
+	} else {
+		// The LIST may include later events - but every event can be
+		// applied more than once
+		page, _ := hub.listPage(list.ID, More{Filter: conn.filter, Tags: subscription.Tags})
+		since = page.Sequence
+		initial = append(initial, page)
 	}
-	websocket.JSON.Send(ws, msg)
+	conn.Release(since, initial...)
 
 	// Main event loop
 Events:
 	for {
 		var event IncomingMessage
-		if err := websocket.JSON.Receive(ws, &event); err != nil {
+		if err := conn.Receive(&event); err != nil {
 			log.Printf("error: parse error: %s", err)
 			break Events
 		}
-		hub.HandleMessage(event)
+		// Heartbeats only keep the connection alive
+		if event.Resource == "connection" {
+			continue
+		}
+		conn.Active()
+		hub.HandleMessage(conn, event)
 	}
 
 	hub.Leave(conn)
 }
 
-func NewHub(config config.Config, conn sql.Connection, sessions *auth.SessionManager) *Hub {
-	return &Hub{
+// NewHub creates a hub, starts its goroutine and subscribes it to the given
+// broadcaster. Other hub instances are asked for their presence.
+func NewHub(config config.Config, settings Settings, conn sql.Connection, sessions *auth.SessionManager, lists *lists.ListManager, broadcaster Broadcaster, searcher Searcher, mailer mail.Mailer, notifier Notifier) *Hub {
+	if settings.QueueSize < 1 {
+		settings.QueueSize = DefaultSettings.QueueSize
+	}
+	if settings.PingInterval <= 0 {
+		settings.PingInterval = DefaultSettings.PingInterval
+	}
+	if settings.ReplayLimit < 0 {
+		settings.ReplayLimit = DefaultSettings.ReplayLimit
+	}
+	if settings.DeletePolicy != Refuse {
+		settings.DeletePolicy = Cascade
+	}
+	if settings.PageSize < 0 {
+		settings.PageSize = 0
+	}
+	if len(settings.Schema.Fields) == 0 {
+		settings.Schema = db.DefaultSchema
+	}
+	hub := &Hub{
+		id:          auth.RandomKeyN(12),
 		config:      config,
+		settings:    settings,
 		conn:        conn,
 		sessions:    sessions,
-		connections: make(map[string]Connection),
+		lists:       lists,
+		broadcaster: broadcaster,
+		searcher:    searcher,
+		mailer:      mailer,
+		notifier:    notifier,
+		join:        make(chan membership),
+		leave:       make(chan membership),
+		envelopes:   make(chan received),
+		users:       make(chan usersRequest),
+		rooms:       make(map[int64]Room),
+		remote:      make(map[int64]map[string]remotePresence),
+	}
+	go hub.run()
+	if settings.EventRetention > 0 {
+		go hub.pruneEvents()
+	}
+	if settings.TrashRetention > 0 {
+		go hub.purgeTrash()
+	}
+	if settings.ReminderInterval > 0 {
+		go hub.remindThings()
 	}
+	broadcaster.Subscribe(hub.Receive)
+	hub.publish(Envelope{Kind: SYNC, Origin: hub.id})
+	return hub
 }
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+func TestIdleStalledConnectionDoesNotBlockHeartbeat(t *testing.T) {
+	dial, stop := sockets(t)
+	defer stop()
+
+	settings := Settings{
+		QueueSize:    2,
+		PingInterval: 20 * time.Millisecond,
+		IdleTimeout:  100 * time.Millisecond,
+	}
+	hub := testHub(settings)
+
+	// Block the writer of a client that never reads on a message larger
+	// than the socket buffers
+	stalledWS, stalledClient := dial()
+	defer stalledClient.Close()
+	stalled := NewConnection(stalledWS, 1, hub.settings)
+	stalled.User = db.User{ID: 1, Name: "stalled"}
+	stalled.Enqueue(OutgoingMessage{Resource: "things", Content: strings.Repeat("x", 8<<20)})
+	time.Sleep(100 * time.Millisecond)
+	hub.Join(stalled)
+
+	activeWS, activeClient := dial()
+	active := NewConnection(activeWS, 1, hub.settings)
+	active.User = db.User{ID: 2, Name: "active"}
+	hub.Join(active)
+
+	// The active client stays active
+	go func() {
+		for {
+			var msg OutgoingMessage
+			if err := websocket.JSON.Receive(activeClient, &msg); err != nil {
+				return
+			}
+			active.Active()
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	select {
+	case <-stalled.done:
+	case <-timeout:
+		t.Fatal("the idle connection was not disconnected")
+	}
+
+	// The hub is still running
+	users := make(chan []Presence)
+	go func() { users <- hub.Users(1) }()
+	select {
+	case <-users:
+	case <-timeout:
+		t.Fatal("the hub was blocked by closing the idle connection")
+	}
+	select {
+	case <-active.done:
+		t.Error("the active connection was disconnected")
+	default:
+	}
+}
+
+func TestResyncDisconnectsList(t *testing.T) {
+	dial, stop := sockets(t)
+	defer stop()
+	hub := testHub(Settings{PingInterval: time.Hour})
+
+	connections := make([]*Connection, 2)
+	for i := range connections {
+		ws, client := dial()
+		defer client.Close()
+		connections[i] = NewConnection(ws, int64(i+1), hub.settings)
+		connections[i].User = db.User{ID: int64(i + 1)}
+		hub.Join(connections[i])
+	}
+
+	hub.Receive(Envelope{Kind: RESYNC, ListID: 1})
+	hub.Users(1) // Wait for the envelope to be handled
+
+	select {
+	case <-connections[0].done:
+	default:
+		t.Error("the connection of the list was not disconnected")
+	}
+	select {
+	case <-connections[1].done:
+		t.Error("the connection of another list was disconnected")
+	default:
+	}
+}
+
+func TestErrorsAreSentToTheSender(t *testing.T) {
+	settings := Settings{QueueSize: 8, PingInterval: time.Hour}
+	hub := testHub(settings)
+
+	// The connections are never started, so their queues hold what was sent
+	connections := make([]*Connection, 2)
+	for i := range connections {
+		connections[i] = &Connection{
+			User:     db.User{ID: int64(i + 1)},
+			ListID:   1,
+			settings: settings,
+			send:     make(chan Message, settings.QueueSize),
+			done:     make(chan struct{}),
+		}
+		hub.Join(connections[i])
+	}
+	hub.Users(1) // Wait for the arrivals to be delivered
+	for _, connection := range connections {
+		for len(connection.send) > 0 {
+			<-connection.send
+		}
+	}
+
+	sender, other := connections[0], connections[1]
+	hub.HandleMessage(sender, IncomingMessage{
+		Resource:  "unknown",
+		Event:     "read",
+		RequestID: "r1",
+	})
+	if len(sender.send) != 1 {
+		t.Fatalf("the sender was sent %d messages", len(sender.send))
+	}
+	msg, ok := (<-sender.send).(OutgoingMessage)
+	if !ok || msg.Event != ERROR || msg.RequestID != "r1" || msg.Resource != "unknown" {
+		t.Errorf("unexpected reply %+v", msg)
+	}
+	if len(other.send) != 0 {
+		t.Errorf("the error was sent to another connection: %v", <-other.send)
+	}
+
+	conflict := ConflictMessage(IncomingMessage{Resource: "things", RequestID: "r2"}, db.Thing{ID: 1})
+	if conflict.Event != CONFLICT || conflict.RequestID != "r2" {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+}
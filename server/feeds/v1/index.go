@@ -0,0 +1,286 @@
+package v1
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Snippets of the index show this many words
+const snippetWords = 12
+
+// Index is an in-process search index of things, for databases that cannot
+// search. Lists are loaded on their first search and then kept up to date
+// by the envelopes of the broadcaster.
+type Index struct {
+	conn    sql.Connection
+	mu      sync.Mutex
+	lists   map[int64]map[int64]entry
+	loading map[int64]*load
+}
+
+// load is a list being loaded. The events of the list are held until the
+// load finishes.
+type load struct {
+	done   chan struct{}
+	events []OutgoingMessage
+	stale  bool // Set when events may have been missed during the load
+	err    error
+}
+
+type entry struct {
+	thing  db.Thing
+	text   string
+	length int
+	counts map[string]int
+}
+
+func newEntry(thing db.Thing) entry {
+	e := entry{
+		thing:  thing,
+		text:   document(thing),
+		counts: make(map[string]int),
+	}
+	for _, word := range words(e.text) {
+		e.counts[word] += 1
+		e.length += 1
+	}
+	return e
+}
+
+// rank returns the frequency of the terms in the thing, or zero if any
+// term is missing
+func (e entry) rank(terms []string) float64 {
+	var matches int
+	for _, term := range terms {
+		if e.counts[term] == 0 {
+			return 0
+		}
+		matches += e.counts[term]
+	}
+	return float64(matches) / float64(e.length)
+}
+
+// snippet returns the words around the first match with matches marked
+func (e entry) snippet(terms []string) string {
+	isTerm := make(map[string]bool)
+	for _, term := range terms {
+		isTerm[term] = true
+	}
+	matches := func(field string) bool {
+		for _, word := range words(field) {
+			if isTerm[word] {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := strings.Fields(e.text)
+	start := 0
+	for i, field := range fields {
+		if matches(field) {
+			start = i - snippetWords/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(fields) {
+		end = len(fields)
+	}
+	shown := make([]string, 0, end-start)
+	for _, field := range fields[start:end] {
+		if matches(field) {
+			field = startMatch + field + stopMatch
+		}
+		shown = append(shown, field)
+	}
+	return markSnippet(strings.Join(shown, " "))
+}
+
+// entries returns the entries of the list, loading them if needed. The
+// things are queried without the lock. Unless an error is returned, the lock
+// is held and must be released by the caller.
+func (index *Index) entries(listID int64) (map[int64]entry, error) {
+	index.mu.Lock()
+	if entries, ok := index.lists[listID]; ok {
+		return entries, nil
+	}
+	if l, ok := index.loading[listID]; ok {
+		index.mu.Unlock()
+		<-l.done
+		if l.err != nil {
+			return nil, l.err
+		}
+		return index.entries(listID)
+	}
+	l := &load{done: make(chan struct{})}
+	index.loading[listID] = l
+	index.mu.Unlock()
+
+	things, err := getThings(index.conn, listID)
+
+	index.mu.Lock()
+	delete(index.loading, listID)
+	l.err = err
+	close(l.done)
+	if err != nil {
+		index.mu.Unlock()
+		return nil, err
+	}
+	entries := make(map[int64]entry)
+	for _, thing := range things {
+		entries[thing.ID] = newEntry(thing)
+	}
+	for _, msg := range l.events {
+		apply(entries, msg)
+	}
+	if !l.stale {
+		index.lists[listID] = entries
+	}
+	return entries, nil
+}
+
+// Search returns the things of the list that contain every word of the
+// query
+func (index *Index) Search(listID int64, query string, limit int) ([]Result, error) {
+	results := []Result{}
+	terms := words(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	entries, err := index.entries(listID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if rank := e.rank(terms); rank > 0 {
+			results = append(results, Result{
+				Thing:   e.thing,
+				Rank:    rank,
+				Snippet: e.snippet(terms),
+			})
+		}
+	}
+	index.mu.Unlock()
+
+	sort.Sort(byRank(results))
+	if limit = searchLimit(limit); len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Receive keeps the index up to date with the envelopes of the broadcaster
+func (index *Index) Receive(envelope Envelope) {
+	switch envelope.Kind {
+	case MESSAGE:
+		if envelope.Message != nil && envelope.UserID == 0 {
+			index.Apply(envelope.ListID, decodeMessage(*envelope.Message))
+		}
+	case SYNC:
+		// The broadcaster lost its connection and envelopes were missed
+		if envelope.Origin == "" {
+			index.Reset()
+		}
+	case RESYNC:
+		index.forget(envelope.ListID)
+	}
+}
+
+// Apply keeps a loaded list up to date with an event of the list
+func (index *Index) Apply(listID int64, msg OutgoingMessage) {
+	if msg.Resource != "things" {
+		return
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if l, ok := index.loading[listID]; ok {
+		l.events = append(l.events, msg)
+	}
+	if entries, ok := index.lists[listID]; ok {
+		apply(entries, msg)
+	}
+}
+
+// apply changes the entries of a list by an event of the list. Events that
+// are older than an entry, such as those of a load, are ignored.
+func apply(entries map[int64]entry, msg OutgoingMessage) {
+	var things Things
+	switch msg.Event {
+	case MOVE, CLEAR:
+		if !decodeContent(msg.Content, &things) {
+			return
+		}
+	case CREATE, UPDATE, DELETE, RESTORE, TOGGLE, REPARENT, COLLAPSE, SCHEDULE, TAG, UNTAG:
+		var thing db.Thing
+		if !decodeContent(msg.Content, &thing) {
+			return
+		}
+		things = Things{thing}
+	default:
+		return
+	}
+
+	// Sub-items are deleted and restored with their parent
+	for _, thing := range flatten(things) {
+		if e, ok := entries[thing.ID]; ok && e.thing.Version > thing.Version {
+			continue
+		}
+		if thing.IsDeleted() {
+			delete(entries, thing.ID)
+		} else {
+			entries[thing.ID] = newEntry(thing)
+		}
+	}
+}
+
+// Reset forgets every list, which are loaded again on their next search.
+// It is used when events may have been missed.
+func (index *Index) Reset() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.lists = make(map[int64]map[int64]entry)
+	for _, l := range index.loading {
+		l.stale = true
+	}
+}
+
+// forget forgets a list that may have missed events
+func (index *Index) forget(listID int64) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	delete(index.lists, listID)
+	if l, ok := index.loading[listID]; ok {
+		l.stale = true
+	}
+}
+
+// NewIndex creates an empty index
+func NewIndex(conn sql.Connection) *Index {
+	return &Index{
+		conn:    conn,
+		lists:   make(map[int64]map[int64]entry),
+		loading: make(map[int64]*load),
+	}
+}
+
+// byRank orders results by rank, then by position
+type byRank []Result
+
+func (r byRank) Len() int      { return len(r) }
+func (r byRank) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byRank) Less(i, j int) bool {
+	if r[i].Rank != r[j].Rank {
+		return r[i].Rank > r[j].Rank
+	}
+	return r[i].Thing.Position < r[j].Thing.Position
+}
@@ -0,0 +1,112 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// thingsConn is a connection that only answers queries for things. Other
+// queries, such as of tags, have no results.
+type thingsConn struct {
+	sql.Connection
+	things func() (Things, error)
+}
+
+func (c thingsConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	if things, ok := dst.(*Things); ok {
+		loaded, err := c.things()
+		*things = loaded
+		return err
+	}
+	return nil
+}
+
+func TestIndexHoldsEventsDuringLoad(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	index := NewIndex(thingsConn{things: func() (Things, error) {
+		close(started)
+		<-release
+		return Things{{ID: 1, Version: 1, Content: `{"name": "Milk"}`}}, nil
+	}})
+
+	searched := make(chan []Result)
+	go func() {
+		results, err := index.Search(1, "oat", 10)
+		if err != nil {
+			t.Errorf("could not search: %s", err)
+		}
+		searched <- results
+	}()
+	<-started
+
+	// Envelopes are received while the list is loaded, without waiting
+	content, _ := json.Marshal(db.Thing{
+		ID:      1,
+		Version: 2,
+		Fields:  map[string]interface{}{"name": "Oat milk"},
+	})
+	msg := OutgoingMessage{
+		Resource: "things",
+		Event:    UPDATE,
+		Content:  json.RawMessage(content), // As if from another instance
+	}
+	received := make(chan struct{})
+	go func() {
+		index.Receive(Envelope{Kind: MESSAGE, ListID: 1, Message: &msg})
+		close(received)
+	}()
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("the index was locked while a list was loaded")
+	}
+	close(release)
+
+	results := <-searched
+	if len(results) != 1 || results[0].Thing.Version != 2 {
+		t.Fatalf("the update during the load was not applied: %+v", results)
+	}
+	if results[0].Snippet != "<mark>Oat</mark> milk" {
+		t.Errorf("unexpected snippet %q", results[0].Snippet)
+	}
+
+	// An older event is ignored
+	msg.Content = db.Thing{
+		ID:      1,
+		Version: 1,
+		Fields:  map[string]interface{}{"name": "Milk"},
+	}
+	index.Apply(1, msg)
+	if results, _ = index.Search(1, "oat", 10); len(results) != 1 {
+		t.Error("an older event replaced the thing")
+	}
+}
+
+func TestIndexLoadError(t *testing.T) {
+	failed := errors.New("connection refused")
+	index := NewIndex(thingsConn{things: func() (Things, error) {
+		return nil, failed
+	}})
+	for i := 0; i < 2; i++ {
+		done := make(chan error)
+		go func() {
+			_, err := index.Search(1, "milk", 10)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != failed {
+				t.Errorf("unexpected error %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the index was left locked by a failed load")
+		}
+	}
+}
@@ -9,22 +9,61 @@ type Message interface {
 	String() string
 }
 
+// OutgoingMessage is sent from the server to clients. If the message was
+// caused by a client request, the request ID of that request is echoed back.
+// Changes to a list are numbered by an increasing sequence, which is also
+// set on the LIST of things to mark the state it includes. A LIST of things
+// with a cursor has more pages, which are requested with the cursor.
 type OutgoingMessage struct {
-	Resource string      `json:"resource"`
-	Event    string      `json:"method"`
-	Content  interface{} `json:"content"`
+	Resource  string      `json:"resource"`
+	Event     string      `json:"method"`
+	RequestID string      `json:"request_id,omitempty"`
+	Sequence  int64       `json:"sequence,omitempty"`
+	Cursor    string      `json:"cursor,omitempty"`
+	Content   interface{} `json:"content"`
 }
 
 func (msg OutgoingMessage) String() string {
 	return fmt.Sprintf("%s %s: %+v", msg.Event, msg.Resource, msg.Content)
 }
 
+// IncomingMessage is sent from clients to the server. The request ID is
+// optional and chosen by the client.
 type IncomingMessage struct {
-	Resource string          `json:"resource"`
-	Event    string          `json:"method"`
-	Content  json.RawMessage `json:"content"`
+	Resource  string          `json:"resource"`
+	Event     string          `json:"method"`
+	RequestID string          `json:"request_id,omitempty"`
+	Content   json.RawMessage `json:"content"`
 }
 
 func (msg IncomingMessage) String() string {
 	return fmt.Sprintf("%s %s: %s", msg.Event, msg.Resource, msg.Content)
 }
+
+// ErrorContent is the content of an ERROR event
+type ErrorContent struct {
+	Message string `json:"message"`
+}
+
+// ErrorMessage creates an ERROR event in response to the given incoming
+// message. It should only be sent to the sender of the incoming message.
+func ErrorMessage(in IncomingMessage, err error) OutgoingMessage {
+	return OutgoingMessage{
+		Resource:  in.Resource,
+		Event:     ERROR,
+		RequestID: in.RequestID,
+		Content:   ErrorContent{Message: err.Error()},
+	}
+}
+
+// ConflictMessage creates a CONFLICT event in response to the given incoming
+// message, which was based on an outdated version of the current content.
+// It should only be sent to the sender of the incoming message.
+func ConflictMessage(in IncomingMessage, current interface{}) OutgoingMessage {
+	return OutgoingMessage{
+		Resource:  in.Resource,
+		Event:     CONFLICT,
+		RequestID: in.RequestID,
+		Content:   current,
+	}
+}
@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Latency is an expvar.Var that summarizes observed durations
+type Latency struct {
+	sync.Mutex
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+var _ expvar.Var = &Latency{}
+
+func (l *Latency) Observe(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.count += 1
+	l.total += d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+// Since observes the time elapsed since the given time
+func (l *Latency) Since(t time.Time) {
+	l.Observe(time.Since(t))
+}
+
+// String outputs the count, mean and max in milliseconds as JSON
+func (l *Latency) String() string {
+	l.Lock()
+	defer l.Unlock()
+	var mean time.Duration
+	if l.count > 0 {
+		mean = l.total / time.Duration(l.count)
+	}
+	return fmt.Sprintf(
+		`{"count": %d, "mean_ms": %f, "max_ms": %f}`,
+		l.count, milliseconds(mean), milliseconds(l.max),
+	)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Metrics of the hub are published by expvar at /debug/vars
+type Metrics struct {
+	Broadcast   *Latency // From receiving an envelope to queueing it
+	Publish     *Latency // Sending an envelope through the broadcaster
+	Join        *Latency
+	Leave       *Latency
+	Connections *expvar.Int
+	Evictions   *expvar.Int
+}
+
+var metrics = Metrics{
+	Broadcast:   &Latency{},
+	Publish:     &Latency{},
+	Join:        &Latency{},
+	Leave:       &Latency{},
+	Connections: &expvar.Int{},
+	Evictions:   &expvar.Int{},
+}
+
+func init() {
+	m := expvar.NewMap("feeds")
+	m.Set("broadcast_latency", metrics.Broadcast)
+	m.Set("publish_latency", metrics.Publish)
+	m.Set("join_latency", metrics.Join)
+	m.Set("leave_latency", metrics.Leave)
+	m.Set("connections", metrics.Connections)
+	m.Set("evictions", metrics.Evictions)
+}
@@ -0,0 +1,150 @@
+package v1
+
+import (
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Things are appended, and the list is renumbered, with this gap between
+// their positions
+const PositionGap = 1024
+
+// Moves place a thing halfway between its new neighbors. Once neighbors are
+// closer than this, the whole list is renumbered.
+const MinPositionGap = 1e-6
+
+// Move is the content of a "move" request. The thing is placed after the
+// thing with the AfterID, or at the top of the list if it is zero.
+type Move struct {
+	ID      int64 `json:"id"`
+	AfterID int64 `json:"after_id"`
+}
+
+type position struct {
+	ID       int64   `db:"id"`
+	Position float64 `db:"position"`
+}
+
+// getPositions returns the positions of the things in the list in order
+func getPositions(conn sql.Connection, listID int64) (positions []position, err error) {
+	stmt := sql.Select(
+		db.Things.C["id"],
+		db.Things.C["position"],
+	).Where(
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+	).OrderBy(db.Things.C["position"], db.Things.C["id"])
+	err = conn.QueryAll(stmt, &positions)
+	return
+}
+
+// lastPosition returns the position after the last thing in the list
+func lastPosition(conn sql.Connection, listID int64) (float64, error) {
+	var last float64
+	stmt := sql.Select(db.Things.C["position"]).Where(
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+	).OrderBy(db.Things.C["position"].Desc()).Limit(1)
+	err := conn.QueryOne(stmt, &last)
+	if err == sql.ErrNoResult {
+		err = nil
+	}
+	return last + PositionGap, err
+}
+
+// moveThing moves a thing within its list and returns every thing whose
+// position changed. Positions are not content, so versions are unchanged
+// and moves never conflict with edits. The list must be locked, so the
+// positions cannot change until the move is committed.
+func moveThing(conn sql.Connection, listID int64, move Move) (moved Things, err error) {
+	if move.ID == move.AfterID {
+		err = invalid("Things cannot be moved after themselves")
+		return
+	}
+	positions, err := getPositions(conn, listID)
+	if err != nil {
+		return
+	}
+
+	// Remove the moving thing and find where it will be inserted
+	others := make([]position, 0, len(positions))
+	var found bool
+	for _, p := range positions {
+		if p.ID == move.ID {
+			found = true
+			continue
+		}
+		others = append(others, p)
+	}
+	if !found {
+		err = ErrNoThing
+		return
+	}
+	index := 0 // The index of the moving thing in the new order
+	if move.AfterID != 0 {
+		index = -1
+		for i, p := range others {
+			if p.ID == move.AfterID {
+				index = i + 1
+				break
+			}
+		}
+		if index < 0 {
+			err = invalid("Thing %d does not exist", move.AfterID)
+			return
+		}
+	}
+
+	// Place the thing between its neighbors
+	var updated []position
+	switch {
+	case len(others) == 0:
+		updated = []position{{ID: move.ID, Position: 0}}
+	case index == 0:
+		updated = []position{{ID: move.ID, Position: others[0].Position - PositionGap}}
+	case index == len(others):
+		updated = []position{{ID: move.ID, Position: others[index-1].Position + PositionGap}}
+	default:
+		before, after := others[index-1].Position, others[index].Position
+		if after-before > MinPositionGap {
+			updated = []position{{ID: move.ID, Position: (before + after) / 2}}
+		} else {
+			updated = renumber(others, index, move.ID)
+		}
+	}
+
+	for _, p := range updated {
+		stmt := db.Things.Update().Values(sql.Values{
+			"position": p.Position,
+		}).Where(
+			db.Things.C["id"].Equals(p.ID),
+			db.Things.C["list_id"].Equals(listID),
+		)
+		if _, err = conn.Execute(stmt); err != nil {
+			return
+		}
+		var thing db.Thing
+		if thing, err = getThing(conn, listID, p.ID); err != nil {
+			return
+		}
+		moved = append(moved, thing)
+	}
+	return
+}
+
+// renumber inserts the thing at the index and spaces out every position.
+// Only the positions that changed are returned.
+func renumber(others []position, index int, id int64) (updated []position) {
+	ordered := make([]position, 0, len(others)+1)
+	ordered = append(ordered, others[:index]...)
+	ordered = append(ordered, position{ID: id})
+	ordered = append(ordered, others[index:]...)
+	for i, p := range ordered {
+		next := float64(i+1) * PositionGap
+		if p.ID == id || p.Position != next {
+			updated = append(updated, position{ID: p.ID, Position: next})
+		}
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package v1
+
+import (
+	dbsql "database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+// positionsConn is a list with the given positions. Moves record the new
+// position of each thing they update.
+type positionsConn struct {
+	sql.Connection
+	positions []position
+	moved     map[int64]float64
+}
+
+func (c *positionsConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	if positions, ok := dst.(*[]position); ok {
+		*positions = append([]position{}, c.positions...)
+	}
+	return nil
+}
+
+func (c *positionsConn) QueryOne(stmt sql.Executable, dst interface{}) error {
+	if thing, ok := dst.(*db.Thing); ok {
+		*thing = db.Thing{Version: 1, Content: `{"name": "Milk"}`}
+	}
+	return nil
+}
+
+func (c *positionsConn) Execute(stmt sql.Executable, args ...interface{}) (dbsql.Result, error) {
+	params := sql.Params()
+	if _, err := stmt.Compile(&pg.PostGres{}, params); err != nil {
+		return nil, err
+	}
+	values := params.Args()
+	c.moved[values[1].(int64)] = values[0].(float64)
+	return nil, nil
+}
+
+func TestMovePositions(t *testing.T) {
+	list := []position{{ID: 1, Position: 1024}, {ID: 2, Position: 2048}, {ID: 3, Position: 2048.0000001}}
+	for _, test := range []struct {
+		move  Move
+		moved map[int64]float64
+	}{
+		{Move{ID: 3, AfterID: 0}, map[int64]float64{3: 0}},
+		{Move{ID: 1, AfterID: 3}, map[int64]float64{1: 2048.0000001 + PositionGap}},
+		{Move{ID: 3, AfterID: 1}, map[int64]float64{3: 1536}},
+		{Move{ID: 1, AfterID: 2}, map[int64]float64{2: 1024, 1: 2048, 3: 3072}}, // Renumbered
+	} {
+		conn := &positionsConn{positions: list, moved: make(map[int64]float64)}
+		if _, err := moveThing(conn, 1, test.move); err != nil {
+			t.Errorf("could not move %+v: %s", test.move, err)
+			continue
+		}
+		if fmt.Sprint(conn.moved) != fmt.Sprint(test.moved) {
+			t.Errorf("unexpected positions %v after %+v, expected %v", conn.moved, test.move, test.moved)
+		}
+	}
+
+	conn := &positionsConn{positions: list, moved: make(map[int64]float64)}
+	for _, move := range []Move{{ID: 2, AfterID: 2}, {ID: 4}, {ID: 2, AfterID: 4}} {
+		if _, err := moveThing(conn, 1, move); err == nil {
+			t.Errorf("the invalid move %+v was made", move)
+		}
+	}
+	if len(conn.moved) != 0 {
+		t.Errorf("invalid moves changed positions %v", conn.moved)
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	others := []position{{ID: 1, Position: 1024}, {ID: 2, Position: 1024.0000001}, {ID: 3, Position: 3072}}
+	updated := renumber(others, 1, 4)
+	expected := map[int64]float64{4: 2048, 2: 3072, 3: 4096}
+	if len(updated) != len(expected) {
+		t.Fatalf("unexpected renumbered positions %v", updated)
+	}
+	for _, p := range updated {
+		if expected[p.ID] != p.Position {
+			t.Errorf("unexpected position %g of thing %d", p.Position, p.ID)
+		}
+	}
+}
+
+func TestConcurrentMovesKeepDistinctPositions(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "moves@example.com")
+	hub := dbHub(conn)
+
+	ids := make([]int64, 4)
+	for i := range ids {
+		thing, err := change(hub, list.ID, user, "create", fmt.Sprintf(`{"name": "%d"}`, i))
+		if err != nil {
+			t.Fatalf("could not create a thing: %s", err)
+		}
+		ids[i] = thing.ID
+	}
+
+	// Both moves place a thing between the same neighbors
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			content, _ := json.Marshal(Move{ID: id, AfterID: ids[0]})
+			_, err := hub.commit(list.ID, user, IncomingMessage{
+				Resource: "things",
+				Event:    "move",
+				Content:  content,
+			})
+			if err != nil {
+				errs <- err
+			}
+		}(ids[2+i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("could not move a thing: %s", err)
+	}
+
+	positions, err := getPositions(conn, list.ID)
+	if err != nil {
+		t.Fatalf("could not get positions: %s", err)
+	}
+	if len(positions) != len(ids) || positions[0].ID != ids[0] {
+		t.Fatalf("unexpected order %v", positions)
+	}
+	for i := 1; i < len(positions); i++ {
+		if positions[i].Position <= positions[i-1].Position {
+			t.Errorf("things %d and %d share a position", positions[i-1].ID, positions[i].ID)
+		}
+	}
+	for _, id := range ids[2:4] {
+		if thing, err := getThing(conn, list.ID, id); err != nil || thing.Version != 1 {
+			t.Errorf("unexpected version %d of thing %d after 10 moves: %v", thing.Version, id, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Cursor marks the last thing of a page. The next page continues after it
+// in the order of positions, then IDs.
+type Cursor struct {
+	Position float64
+	ID       int64
+}
+
+func (c Cursor) String() string {
+	raw := strconv.FormatFloat(c.Position, 'g', -1, 64) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.URLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor parses a cursor created by Cursor.String
+func ParseCursor(s string) (cursor Cursor, err error) {
+	malformed := invalid("Invalid cursor: %s", s)
+	raw, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, malformed
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return cursor, malformed
+	}
+	if cursor.Position, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return cursor, malformed
+	}
+	if cursor.ID, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return cursor, malformed
+	}
+	return cursor, nil
+}
+
+// More is the content of a "list_more" request. The filter and tags must
+// match those of the previous pages.
+type More struct {
+	Cursor string   `json:"cursor"`
+	Filter string   `json:"filter"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// getPage returns up to limit things after the cursor, or every thing if
+// the limit is zero. The cursor of the next page is empty after the last
+// page.
+func getPage(conn sql.Connection, listID int64, after *Cursor, limit int, filters ...sql.Clause) (things Things, next string) {
+	clauses := append([]sql.Clause{
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+	}, filters...)
+	if after != nil {
+		clauses = append(clauses, sql.AnyOf(
+			db.Things.C["position"].GreaterThan(after.Position),
+			sql.AllOf(
+				db.Things.C["position"].Equals(after.Position),
+				db.Things.C["id"].GreaterThan(after.ID),
+			),
+		))
+	}
+	stmt := db.Things.Select().Where(clauses...).OrderBy(
+		db.Things.C["position"], db.Things.C["id"],
+	)
+	if limit > 0 {
+		stmt = stmt.Limit(limit + 1)
+	}
+
+	things = Things{}
+	conn.MustQueryAll(stmt, &things)
+	if limit > 0 && len(things) > limit {
+		things = things[:limit]
+		last := things[limit-1]
+		next = Cursor{Position: last.Position, ID: last.ID}.String()
+	}
+	things.Decode()
+	if err := loadRelated(conn, things); err != nil {
+		log.Printf("error: could not load tags and comments: %s", err)
+	}
+	return
+}
+
+// listPage creates a LIST with a page of the list's things nested under
+// their parents. Its sequence is read before the things, so the page
+// includes at least every event up to it.
+func (hub *Hub) listPage(listID int64, more More) (out OutgoingMessage, err error) {
+	filters, err := filterClauses(more.Filter)
+	if err != nil {
+		return
+	}
+	filters = append(filters, tagClauses(more.Tags)...)
+	var after *Cursor
+	if more.Cursor != "" {
+		var cursor Cursor
+		if cursor, err = ParseCursor(more.Cursor); err != nil {
+			return
+		}
+		after = &cursor
+	}
+	out.Resource = "things"
+	out.Event = LIST
+	out.Sequence = latestSequence(hub.conn, listID)
+	things, next := getPage(hub.conn, listID, after, hub.settings.PageSize, filters...)
+	out.Content = buildTree(things)
+	out.Cursor = next
+	return
+}
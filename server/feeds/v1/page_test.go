@@ -0,0 +1,159 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+// pageConn records the query of a page of things and answers it with the
+// given things. Other queries, such as of tags, have no results.
+type pageConn struct {
+	sql.Connection
+	things Things
+	query  string
+	args   []interface{}
+}
+
+func (c *pageConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	things, ok := dst.(*Things)
+	if !ok {
+		return nil
+	}
+	params := sql.Params()
+	compiled, err := stmt.Compile(&pg.PostGres{}, params)
+	if err != nil {
+		return err
+	}
+	c.query, c.args = compiled, params.Args()
+	*things = c.things
+	return nil
+}
+
+func (c *pageConn) MustQueryAll(stmt sql.Executable, dst interface{}) {
+	if err := c.QueryAll(stmt, dst); err != nil {
+		panic(err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	cursor := Cursor{Position: 1536.5, ID: 42}
+	parsed, err := ParseCursor(cursor.String())
+	if err != nil || parsed != cursor {
+		t.Errorf("unexpected parsed cursor %+v: %v", parsed, err)
+	}
+	for _, s := range []string{"", "!", "MTAyNA==", "eDo0Mg==", "MTAyNDp4"} {
+		if _, err := ParseCursor(s); err == nil {
+			t.Errorf("the invalid cursor %q was parsed", s)
+		}
+	}
+}
+
+func TestPagesAreCutAfterTags(t *testing.T) {
+	conn := &pageConn{things: Things{
+		{ID: 2, Position: 2048, Content: `{"name": "Deploy"}`},
+		{ID: 4, Position: 4096, Content: `{"name": "Migrate"}`},
+		{ID: 6, Position: 6144, Content: `{"name": "Monitor"}`},
+	}}
+	after := &Cursor{Position: 1024, ID: 1}
+	filters := append([]sql.Clause{db.Things.C["completed"].Equals(false)}, tagClauses([]string{"backend"})...)
+	things, next := getPage(conn, 1, after, 2, filters...)
+
+	// The tags are selected by the query, before its limit
+	tagged := strings.Index(conn.query, `"things"."id" IN (SELECT "thing_tags"."thing_id" FROM "thing_tags"`)
+	limit := strings.Index(conn.query, "LIMIT 3")
+	if tagged < 0 || limit < tagged {
+		t.Fatalf("the page is not cut after its tags: %s", conn.query)
+	}
+	if args := fmt.Sprint(conn.args); args != "[1 false backend 1024 1024 1]" {
+		t.Errorf("unexpected arguments %s of %s", args, conn.query)
+	}
+
+	// The extra thing is only used to know there is another page
+	if len(things) != 2 || things[1].ID != 4 {
+		t.Fatalf("unexpected page %+v", things)
+	}
+	if next != (Cursor{Position: 4096, ID: 4}).String() {
+		t.Errorf("unexpected cursor %s", next)
+	}
+	conn.things = conn.things[2:]
+	if things, next = getPage(conn, 1, &Cursor{Position: 4096, ID: 4}, 2); len(things) != 1 || next != "" {
+		t.Errorf("unexpected last page %+v with cursor %q", things, next)
+	}
+}
+
+func TestScopeAddsSubscribedTags(t *testing.T) {
+	subscription := Subscription{Tags: []string{"backend"}}
+	more := subscription.scope(IncomingMessage{
+		Resource: "things",
+		Event:    "list_more",
+		Content:  []byte(`{"cursor": "abc", "filter": "open"}`),
+	})
+	if content := string(more.Content); content != `{"cursor":"abc","filter":"open","tags":["backend"]}` {
+		t.Errorf("unexpected scoped content %s", content)
+	}
+	read := subscription.scope(IncomingMessage{Resource: "things", Event: "read"})
+	if content := string(read.Content); content != `{"filter":"","tags":["backend"]}` {
+		t.Errorf("unexpected scoped content %s", content)
+	}
+	create := IncomingMessage{Resource: "things", Event: "create", Content: []byte(`{}`)}
+	if scoped := subscription.scope(create); string(scoped.Content) != `{}` {
+		t.Errorf("a change was scoped: %s", scoped.Content)
+	}
+}
+
+func TestTaggedPages(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "pages@example.com")
+	hub := dbHub(conn)
+	hub.settings.PageSize = 2
+
+	// Every other thing is tagged, so pages cut before filtering are short
+	var tagged []int64
+	for i := 0; i < 6; i++ {
+		thing, err := change(hub, list.ID, user, "create", fmt.Sprintf(`{"name": "Thing %d"}`, i))
+		if err != nil {
+			t.Fatalf("could not create a thing: %s", err)
+		}
+		if i%2 == 0 {
+			continue
+		}
+		tagging := fmt.Sprintf(`{"id": %d, "tag": "backend"}`, thing.ID)
+		if _, err = change(hub, list.ID, user, "tag", tagging); err != nil {
+			t.Fatalf("could not tag a thing: %s", err)
+		}
+		tagged = append(tagged, thing.ID)
+	}
+
+	more := More{Tags: []string{"backend"}}
+	first, err := hub.listPage(list.ID, more)
+	if err != nil {
+		t.Fatalf("could not list the first page: %s", err)
+	}
+	if things := first.Content.(Things); len(things) != 2 || things[0].ID != tagged[0] || things[1].ID != tagged[1] {
+		t.Errorf("unexpected first page %+v", things)
+	}
+	if first.Cursor == "" {
+		t.Fatal("the first page has no cursor")
+	}
+	more.Cursor = first.Cursor
+	last, err := hub.listPage(list.ID, more)
+	if err != nil {
+		t.Fatalf("could not list the last page: %s", err)
+	}
+	if things := last.Content.(Things); len(things) != 1 || things[0].ID != tagged[2] {
+		t.Errorf("unexpected last page %+v", things)
+	}
+	if last.Cursor != "" {
+		t.Errorf("the last page has a cursor %s", last.Cursor)
+	}
+}
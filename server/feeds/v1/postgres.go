@@ -0,0 +1,210 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres limits NOTIFY payloads to less than 8000 bytes
+const MaxNotifyPayload = 7999
+
+// Envelopes too large to notify are kept for this long, which is much longer
+// than every listener needs to read them
+const BroadcastLifetime = time.Minute
+
+// PostgresBroadcaster fans out envelopes to every process listening on the
+// same channel using Postgres LISTEN / NOTIFY
+type PostgresBroadcaster struct {
+	sync.RWMutex
+	db          *sql.DB
+	listener    *pq.Listener
+	channel     string
+	subscribers []func(Envelope)
+}
+
+var _ Broadcaster = &PostgresBroadcaster{}
+
+// Publish sends the envelope as a NOTIFY on the broadcaster's channel.
+// Messages with an event are sent by reference, since their content may be
+// larger than the notify limit. Any other envelope over the limit, such as
+// the presence of a busy list or a reminder, is stored and sent by
+// reference to its broadcast.
+func (b *PostgresBroadcaster) Publish(envelope Envelope) error {
+	payload, err := notifyPayload(envelope)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxNotifyPayload {
+		if payload, err = b.store(envelope, payload); err != nil {
+			return err
+		}
+	}
+	_, err = b.db.Exec("SELECT pg_notify($1, $2)", b.channel, string(payload))
+	return err
+}
+
+// notifyPayload encodes the envelope, leaving out the content of messages
+// that were recorded as events
+func notifyPayload(envelope Envelope) ([]byte, error) {
+	if envelope.Kind == MESSAGE && envelope.Message != nil && envelope.Message.Sequence != 0 {
+		msg := *envelope.Message
+		msg.Content = nil
+		envelope.Message = &msg
+		envelope.Stored = true
+	}
+	return json.Marshal(envelope)
+}
+
+// reference encodes a notification of the stored broadcast of the envelope
+func reference(envelope Envelope, id int64) ([]byte, error) {
+	return json.Marshal(Envelope{
+		Kind:      envelope.Kind,
+		Origin:    envelope.Origin,
+		ListID:    envelope.ListID,
+		Broadcast: id,
+	})
+}
+
+// store saves the payload of an envelope that is too large to notify and
+// returns a reference to it. Expired broadcasts are removed at the same
+// time.
+func (b *PostgresBroadcaster) store(envelope Envelope, payload []byte) ([]byte, error) {
+	now := time.Now().UTC()
+	_, err := b.db.Exec(
+		`DELETE FROM "broadcasts" WHERE "created_at" < $1`,
+		now.Add(-BroadcastLifetime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	err = b.db.QueryRow(
+		`INSERT INTO "broadcasts" ("payload", "created_at") VALUES ($1, $2) RETURNING "id"`,
+		string(payload), now,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return reference(envelope, id)
+}
+
+// broadcast replaces a reference with the stored envelope it refers to
+func (b *PostgresBroadcaster) broadcast(envelope *Envelope) error {
+	var payload string
+	err := b.db.QueryRow(
+		`SELECT "payload" FROM "broadcasts" WHERE "id" = $1`, envelope.Broadcast,
+	).Scan(&payload)
+	if err != nil {
+		return err
+	}
+	*envelope = Envelope{}
+	return json.Unmarshal([]byte(payload), envelope)
+}
+
+// load sets the content of a stored envelope's message from its event
+func (b *PostgresBroadcaster) load(envelope *Envelope) error {
+	var content string
+	err := b.db.QueryRow(
+		`SELECT "content" FROM "events" WHERE "list_id" = $1 AND "sequence" = $2`,
+		envelope.ListID, envelope.Message.Sequence,
+	).Scan(&content)
+	if err != nil {
+		return err
+	}
+	envelope.Message.Content = json.RawMessage(content)
+	envelope.Stored = false
+	return nil
+}
+
+func (b *PostgresBroadcaster) Subscribe(subscriber func(Envelope)) {
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+func (b *PostgresBroadcaster) Close() error {
+	if err := b.listener.Close(); err != nil {
+		return err
+	}
+	return b.db.Close()
+}
+
+func (b *PostgresBroadcaster) deliver(envelope Envelope) {
+	b.RLock()
+	defer b.RUnlock()
+	for _, subscriber := range b.subscribers {
+		subscriber(envelope)
+	}
+}
+
+// listen delivers notifications until the listener is closed
+func (b *PostgresBroadcaster) listen() {
+	for notification := range b.listener.Notify {
+		// A nil notification is sent after the listener reconnects - any
+		// notifications sent while disconnected were lost, so ask the
+		// other hubs for their presence
+		if notification == nil {
+			b.deliver(Envelope{Kind: SYNC})
+			continue
+		}
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(notification.Extra), &envelope); err != nil {
+			log.Printf("feeds: could not parse notification: %s", err)
+			continue
+		}
+		// Without its broadcast or content the message is lost, so the
+		// connections of the list must catch up on their own. Lost presence
+		// is sent again by the next heartbeat.
+		if envelope.Broadcast != 0 {
+			if err := b.broadcast(&envelope); err != nil {
+				log.Printf("feeds: could not load broadcast %d: %s", envelope.Broadcast, err)
+				if envelope.Kind == MESSAGE {
+					b.deliver(Envelope{Kind: RESYNC, ListID: envelope.ListID})
+				}
+				continue
+			}
+		}
+		if envelope.Stored && envelope.Message != nil {
+			if err := b.load(&envelope); err != nil {
+				log.Printf("feeds: could not load event %d: %s", envelope.Message.Sequence, err)
+				b.deliver(Envelope{Kind: RESYNC, ListID: envelope.ListID})
+				continue
+			}
+		}
+		b.deliver(envelope)
+	}
+}
+
+// NewPostgresBroadcaster creates a broadcaster that listens on the given
+// channel with the given credentials
+func NewPostgresBroadcaster(credentials, channel string) (*PostgresBroadcaster, error) {
+	conn, err := sql.Open("postgres", credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	report := func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("feeds: postgres listener: %s", err)
+		}
+	}
+	listener := pq.NewListener(credentials, 10*time.Second, time.Minute, report)
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	b := &PostgresBroadcaster{
+		db:       conn,
+		listener: listener,
+		channel:  channel,
+	}
+	go b.listen()
+	return b, nil
+}
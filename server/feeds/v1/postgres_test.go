@@ -0,0 +1,102 @@
+package v1
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+func TestNotifyPayload(t *testing.T) {
+	// Messages with an event are sent by reference, whatever their size
+	msg := &OutgoingMessage{
+		Resource:  "things",
+		Event:     CLEAR,
+		RequestID: "a1",
+		Sequence:  42,
+		Content:   strings.Repeat("x", 2*MaxNotifyPayload),
+	}
+	envelope := Envelope{Kind: MESSAGE, Origin: "hub", ListID: 1, Message: msg}
+	payload, err := notifyPayload(envelope)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var sent Envelope
+	if err = json.Unmarshal(payload, &sent); err != nil {
+		t.Fatalf("could not decode the payload: %s", err)
+	}
+	if !sent.Stored || sent.Message == nil {
+		t.Fatalf("the message was not sent by reference: %s", payload)
+	}
+	if sent.Message.Content != nil {
+		t.Errorf("the content was sent: %s", payload)
+	}
+	if sent.Message.Sequence != 42 || sent.Message.RequestID != "a1" || sent.ListID != 1 {
+		t.Errorf("the reference is incomplete: %s", payload)
+	}
+	if envelope.Message.Content == nil {
+		t.Error("the published message was changed")
+	}
+
+	// Other envelopes are sent whole, if they fit
+	presence := Envelope{Kind: PRESENCE, Origin: "hub", ListID: 1, Presence: []Presence{{}}}
+	if payload, err = notifyPayload(presence); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var whole Envelope
+	if err = json.Unmarshal(payload, &whole); err != nil || whole.Stored {
+		t.Errorf("the presence was not sent whole: %s", payload)
+	}
+	reminder := Envelope{Kind: MESSAGE, Origin: "hub", ListID: 1, UserID: 1, Message: &OutgoingMessage{
+		Resource: "things",
+		Event:    REMINDER,
+		Content:  strings.Repeat("x", MaxNotifyPayload),
+	}}
+	if payload, err = notifyPayload(reminder); err != nil || len(payload) <= MaxNotifyPayload {
+		t.Fatalf("unexpected payload of %d bytes: %v", len(payload), err)
+	}
+
+	// Envelopes over the limit are sent by reference to their broadcast
+	if payload, err = reference(reminder, 7); err != nil || len(payload) > MaxNotifyPayload {
+		t.Fatalf("unexpected reference of %d bytes: %v", len(payload), err)
+	}
+	var ref Envelope
+	if err = json.Unmarshal(payload, &ref); err != nil || ref.Broadcast != 7 || ref.Message != nil {
+		t.Errorf("unexpected reference %s", payload)
+	}
+}
+
+func TestLargeEnvelopes(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	b, err := NewPostgresBroadcaster(os.Getenv("LISTOFTHINGS_TEST_DB"), "test_large_envelopes")
+	if err != nil {
+		t.Fatalf("could not create a broadcaster: %s", err)
+	}
+	defer b.Close()
+	received := make(chan Envelope, 1)
+	b.Subscribe(func(envelope Envelope) {
+		if envelope.Kind == PRESENCE {
+			received <- envelope
+		}
+	})
+
+	presence := make([]Presence, 200)
+	for i := range presence {
+		presence[i] = Presence{ID: int64(i + 1), Name: strings.Repeat("x", 64)}
+	}
+	if err := b.Publish(Envelope{Kind: PRESENCE, Origin: "hub", ListID: 1, Presence: presence}); err != nil {
+		t.Fatalf("could not publish a large envelope: %s", err)
+	}
+	select {
+	case envelope := <-received:
+		if envelope.Broadcast != 0 || len(envelope.Presence) != len(presence) {
+			t.Errorf("the large envelope was not delivered whole: %d users", len(envelope.Presence))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the large envelope was not delivered")
+	}
+}
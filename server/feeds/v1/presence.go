@@ -0,0 +1,20 @@
+package v1
+
+import "time"
+
+// Presence is the content of the "users" resource. Only the ID and name of
+// users are shown to the others in a list, never their email. LastSeen is
+// the last time any message, including heartbeats, was received from the
+// user.
+type Presence struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// remotePresence is the presence of another hub instance. Instances re-send
+// their presence with every heartbeat and expire if they stop.
+type remotePresence struct {
+	users []Presence
+	at    time.Time
+}
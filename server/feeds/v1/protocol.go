@@ -0,0 +1,66 @@
+package v1
+
+import (
+	"time"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Request is a method that clients can send, with examples of its content
+// and of the content of its reply. Requests describe the protocol, such as
+// in the spec served by the server. Any request can instead be replied to
+// with an ERROR, and changes based on an outdated version with a CONFLICT.
+type Request struct {
+	Resource  string
+	Method    string
+	Content   interface{} // Nil for requests without content
+	Event     string      // The event of the reply, empty if there is none
+	Reply     interface{}
+	Broadcast bool // Replies to changes are sent to every user of the list
+}
+
+// Requests are every method of the protocol
+var Requests = []Request{
+	{"things", "subscribe", Subscription{}, LIST, Things{}, false},
+	{"things", "read", Query{}, LIST, Things{}, false},
+	{"things", "list_more", More{}, LIST_MORE, Things{}, false},
+	{"things", "search", Search{}, SEARCH, []Result{}, false},
+	{"things", "history", History{}, HISTORY, []Change{}, false},
+	{"things", "create", db.Thing{}, CREATE, db.Thing{}, true},
+	{"things", "update", db.Thing{}, UPDATE, db.Thing{}, true},
+	{"things", "revert", Revert{}, UPDATE, db.Thing{}, true},
+	{"things", "delete", Ref{}, DELETE, db.Thing{}, true},
+	{"things", "restore", Ref{}, RESTORE, db.Thing{}, true},
+	{"things", "move", Move{}, MOVE, Things{}, true},
+	{"things", "reparent", Reparent{}, REPARENT, db.Thing{}, true},
+	{"things", "collapse", Collapse{}, COLLAPSE, db.Thing{}, true},
+	{"things", "toggle", Toggle{}, TOGGLE, db.Thing{}, true},
+	{"things", "clear", nil, CLEAR, Things{}, true},
+	{"things", "schedule", Schedule{}, SCHEDULE, db.Thing{}, true},
+	{"things", "tag", Tagging{}, TAG, db.Thing{}, true},
+	{"things", "untag", Tagging{}, UNTAG, db.Thing{}, true},
+	{"trash", "read", nil, LIST, Things{}, false},
+	{"comments", "read", Thread{}, LIST, []db.Comment{}, false},
+	{"comments", "create", db.Comment{}, CREATE, db.Comment{}, true},
+	{"comments", "update", db.Comment{}, UPDATE, db.Comment{}, true},
+	{"comments", "delete", db.Comment{}, DELETE, db.Comment{}, true},
+	{"connection", PONG, nil, "", nil, false},
+}
+
+// Notice is an event that the server sends without a request
+type Notice struct {
+	Resource string
+	Event    string
+	Content  interface{}
+}
+
+// Notices are every event of the protocol that is not a reply
+var Notices = []Notice{
+	{"users", LIST, []Presence{}},
+	{"users", CONNECT, Presence{}},
+	{"users", DISCONNECT, Presence{}},
+	{"connection", PING, time.Time{}},
+	{"things", REMINDER, db.Thing{}},
+	{"attachments", CREATE, db.Attachment{}},
+	{"attachments", DELETE, db.Attachment{}},
+}
@@ -0,0 +1,106 @@
+package v1
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+// handled returns the string literals that the function of the file
+// compares with in.Event and in.Resource, either as switch cases or with ==
+func handled(t *testing.T, filename, function string) (events, resources []string) {
+	file, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if err != nil {
+		t.Fatalf("could not parse %s: %s", filename, err)
+	}
+	field := func(expr ast.Expr) string {
+		selector, ok := expr.(*ast.SelectorExpr)
+		if !ok {
+			return ""
+		}
+		if ident, ok := selector.X.(*ast.Ident); !ok || ident.Name != "in" {
+			return ""
+		}
+		return selector.Sel.Name
+	}
+	add := func(name string, expr ast.Expr) {
+		lit, ok := expr.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return
+		}
+		value, _ := strconv.Unquote(lit.Value)
+		switch name {
+		case "Event":
+			events = append(events, value)
+		case "Resource":
+			resources = append(resources, value)
+		}
+	}
+	var found bool
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != function {
+			continue
+		}
+		found = true
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.SwitchStmt:
+				name := field(node.Tag)
+				for _, stmt := range node.Body.List {
+					for _, expr := range stmt.(*ast.CaseClause).List {
+						add(name, expr)
+					}
+				}
+			case *ast.BinaryExpr:
+				if node.Op == token.EQL {
+					add(field(node.X), node.Y)
+				}
+			}
+			return true
+		})
+	}
+	if !found {
+		t.Fatalf("%s has no function %s", filename, function)
+	}
+	return
+}
+
+func TestEveryRequestIsDescribed(t *testing.T) {
+	described := make(map[string]bool)
+	for _, request := range Requests {
+		described[request.Resource+" "+request.Method] = true
+	}
+	handles := make(map[string]bool)
+	for _, source := range []struct{ filename, function, resource string }{
+		{"things.go", "handleThings", "things"},
+		{"comments.go", "handleComments", "comments"},
+		{"query.go", "Query", "things"},
+		{"hub.go", "HandleMessage", "things"},
+	} {
+		events, resources := handled(t, source.filename, source.function)
+		for _, event := range events {
+			handles[source.resource+" "+event] = true
+		}
+		// Queries of other resources read them
+		for _, resource := range resources {
+			handles[resource+" read"] = true
+		}
+	}
+
+	for method := range handles {
+		if !described[method] {
+			t.Errorf("%s is handled but not described in Requests", method)
+		}
+	}
+	for _, request := range Requests {
+		if request.Resource == "connection" {
+			continue
+		}
+		if method := request.Resource + " " + request.Method; !handles[method] {
+			t.Errorf("%s is described in Requests but not handled", method)
+		}
+	}
+}
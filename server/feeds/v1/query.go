@@ -0,0 +1,83 @@
+package v1
+
+import (
+	"encoding/json"
+)
+
+// isQuery returns true if the message only requests data. Queries are
+// answered to the sender only and are not recorded as events.
+func isQuery(in IncomingMessage) bool {
+	switch in.Event {
+	case "read", "search", "list_more", "history":
+		return true
+	}
+	return false
+}
+
+// Query answers a message that requests data, which is not recorded
+func (hub *Hub) Query(listID int64, in IncomingMessage) (out OutgoingMessage, err error) {
+	out.Resource = in.Resource
+	out.RequestID = in.RequestID
+	if in.Event == "search" {
+		out.Event = SEARCH
+		var search Search
+		if err = json.Unmarshal(in.Content, &search); err != nil {
+			return
+		}
+		out.Content, err = hub.searcher.Search(listID, search.Query, search.Limit)
+		return
+	}
+	if in.Event == "history" {
+		out.Event = HISTORY
+		var history History
+		if err = json.Unmarshal(in.Content, &history); err != nil {
+			return
+		}
+		out.Content, err = getHistory(hub.conn, listID, history.ID)
+		return
+	}
+	if in.Event == "list_more" {
+		var more More
+		if err = json.Unmarshal(in.Content, &more); err != nil {
+			return
+		}
+		if more.Cursor == "" {
+			err = invalid("Requests for more things must include a cursor")
+			return
+		}
+		if out, err = hub.listPage(listID, more); err != nil {
+			return
+		}
+		out.Event = LIST_MORE
+		out.RequestID = in.RequestID
+		return
+	}
+	switch in.Resource {
+	case "comments":
+		var thread Thread
+		if err = json.Unmarshal(in.Content, &thread); err != nil {
+			return
+		}
+		out.Event = LIST
+		out.Content, err = getComments(hub.conn, listID, thread.ThingID)
+	case "trash":
+		out.Event = LIST
+		out.Content = getTrash(hub.conn, listID)
+	case "things":
+		// Things can be filtered by their completion
+		var query Query
+		if len(in.Content) > 0 {
+			if err = json.Unmarshal(in.Content, &query); err != nil {
+				return
+			}
+		}
+		more := More{Filter: query.Filter, Tags: query.Tags}
+		if out, err = hub.listPage(listID, more); err != nil {
+			return
+		}
+		out.RequestID = in.RequestID
+	default:
+		err = invalid("Unknown resource: %s", in.Resource)
+	}
+	return
+}
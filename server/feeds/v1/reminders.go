@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Schedule is the content of "schedule" requests. A nil due date or a zero
+// assignee removes them.
+type Schedule struct {
+	ID         int64      `json:"id"`
+	DueAt      *time.Time `json:"due_at"`
+	AssigneeID int64      `json:"assignee_id"`
+}
+
+// scheduleThing sets the due date and assignee of a thing. Changing either
+// means the thing will be reminded again. Assignees must have opened the
+// list, since they are emailed its reminders.
+func scheduleThing(conn sql.Connection, listID int64, schedule Schedule) (db.Thing, error) {
+	values := sql.Values{
+		"due_at":      nil,
+		"assignee_id": nil,
+		"reminded_at": nil,
+	}
+	if schedule.DueAt != nil {
+		values["due_at"] = schedule.DueAt.UTC()
+	}
+	if schedule.AssigneeID != 0 {
+		user, err := checkAssignee(conn, listID, schedule.AssigneeID)
+		if err != nil {
+			return db.Thing{}, err
+		}
+		values["assignee_id"] = user.ID
+	}
+	return setThing(conn, listID, schedule.ID, values)
+}
+
+// checkAssignee returns the user with the given ID if they have opened the
+// list
+func checkAssignee(conn sql.Connection, listID, id int64) (user db.User, err error) {
+	stmt := db.Users.Select().Where(
+		db.Users.C["id"].Equals(id),
+		db.Users.C["deleted_at"].IsNull(),
+	)
+	if err = conn.QueryOne(stmt, &user); err != nil {
+		if err == sql.ErrNoResult {
+			err = invalid("Assignee does not exist")
+		}
+		return
+	}
+	var member db.Member
+	members := db.Members.Select().Where(
+		db.Members.C["list_id"].Equals(listID),
+		db.Members.C["user_id"].Equals(user.ID),
+	)
+	if err = conn.QueryOne(members, &member); err == sql.ErrNoResult {
+		err = invalid("Assignees must have opened the list")
+	}
+	return
+}
+
+// dueThings returns the open things that became due and have not been
+// reminded
+func dueThings(conn sql.Connection, now time.Time) (things Things, err error) {
+	things = Things{}
+	stmt := db.Things.Select().Where(
+		db.Things.C["due_at"].LessThan(now),
+		db.Things.C["reminded_at"].IsNull(),
+		db.Things.C["deleted_at"].IsNull(),
+		db.Things.C["completed"].Equals(false),
+	).OrderBy(db.Things.C["due_at"])
+	if err = conn.QueryAll(stmt, &things); err != nil {
+		return
+	}
+	things.Decode()
+	return
+}
+
+// claimReminder marks the thing as reminded. It returns false if another
+// hub instance already did.
+func claimReminder(conn sql.Connection, thing db.Thing, now time.Time) (bool, error) {
+	stmt := db.Things.Update().Values(sql.Values{
+		"reminded_at": now,
+	}).Where(
+		db.Things.C["id"].Equals(thing.ID),
+		db.Things.C["reminded_at"].IsNull(),
+	)
+	result, err := conn.Execute(stmt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// remindThings periodically reminds the assignees of things that became
+// due. Reminders are sent to the connections of the assignee, or to every
+// user of the list if the thing has no assignee, and emailed to assignees
+// with an email address.
+func (hub *Hub) remindThings() {
+	for now := range time.Tick(hub.settings.ReminderInterval) {
+		things, err := dueThings(hub.conn, now.UTC())
+		if err != nil {
+			log.Printf("error: could not get due things: %s", err)
+			continue
+		}
+		for _, thing := range things {
+			claimed, err := claimReminder(hub.conn, thing, now.UTC())
+			if err != nil {
+				log.Printf("error: could not claim reminder of thing %d: %s", thing.ID, err)
+			}
+			if !claimed {
+				continue
+			}
+			hub.remind(thing)
+		}
+	}
+}
+
+// remind sends the reminder of a single thing
+func (hub *Hub) remind(thing db.Thing) {
+	msg := OutgoingMessage{
+		Resource: "things",
+		Event:    REMINDER,
+		Content:  thing,
+	}
+	if thing.AssigneeID == nil {
+		hub.Broadcast(thing.ListID, msg)
+		return
+	}
+	hub.publish(Envelope{
+		Kind:    MESSAGE,
+		Origin:  hub.id,
+		ListID:  thing.ListID,
+		UserID:  *thing.AssigneeID,
+		Message: &msg,
+	})
+
+	if hub.mailer == nil {
+		return
+	}
+	var assignee db.User
+	stmt := db.Users.Select().Where(db.Users.C["id"].Equals(*thing.AssigneeID))
+	if err := hub.conn.QueryOne(stmt, &assignee); err != nil {
+		if err != sql.ErrNoResult {
+			log.Printf("error: could not get the assignee of thing %d: %s", thing.ID, err)
+		}
+		return
+	}
+	if assignee.Email == "" {
+		return
+	}
+	subject := fmt.Sprintf("Reminder: %s", thing)
+	body := fmt.Sprintf(
+		"%s was due at %s.\n\n%s/lists/%d\n",
+		thing,
+		thing.DueAt.Format(time.RFC1123),
+		hub.config.FullAddress(),
+		thing.ListID,
+	)
+	if err := hub.mailer.Send(assignee.Email, subject, body); err != nil {
+		log.Printf("error: could not email reminder of thing %d: %s", thing.ID, err)
+	}
+}
@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/server/mail"
+	"github.com/aodin/listofthings/server/mail/mailtest"
+)
+
+// usersConn is a connection that only answers queries for a single user
+type usersConn struct {
+	sql.Connection
+	user db.User
+	err  error
+}
+
+func (c usersConn) QueryOne(stmt sql.Executable, dst interface{}) error {
+	if user, ok := dst.(*db.User); ok && c.err == nil {
+		*user = c.user
+	}
+	return c.err
+}
+
+func TestAssigneesMustHaveOpenedTheList(t *testing.T) {
+	in := db.NewThing(1, "Buy milk")
+	due := time.Date(2015, 9, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	assignee := int64(2)
+	in.DueAt, in.AssigneeID = &due, &assignee
+
+	conn := &insertConn{}
+	if _, err := createThing(conn, 1, in); err == nil {
+		t.Fatal("a thing was assigned to a user who never opened the list")
+	}
+	if _, err := scheduleThing(conn, 1, Schedule{ID: 3, AssigneeID: 2}); err == nil {
+		t.Fatal("a thing was scheduled for a user who never opened the list")
+	}
+
+	conn.members = map[int64]bool{2: true}
+	if _, err := createThing(conn, 1, in); err != nil {
+		t.Fatalf("could not create an assigned thing: %s", err)
+	}
+	if id, ok := conn.values["assignee_id"].(*int64); !ok || *id != 2 {
+		t.Errorf("unexpected assignee %v", conn.values["assignee_id"])
+	}
+	if at, ok := conn.values["due_at"].(*time.Time); !ok || !at.Equal(due) || at.Location() != time.UTC {
+		t.Errorf("unexpected due date %v", conn.values["due_at"])
+	}
+}
+
+func TestRemindEmailsAssignee(t *testing.T) {
+	smtp, emails, stop := mailtest.Listen(t)
+	defer stop()
+
+	hub := testHub(Settings{PingInterval: time.Hour})
+	hub.mailer = mail.New(smtp)
+	assignee := db.User{ID: 2, Name: "Ann", Email: "ann@example.com"}
+	hub.conn = usersConn{user: assignee}
+
+	due := time.Date(2015, 9, 1, 12, 0, 0, 0, time.UTC)
+	thing := db.NewThing(1, "Buy milk")
+	thing.ID = 1
+	thing.DueAt = &due
+	thing.AssigneeID = &assignee.ID
+	hub.remind(thing)
+
+	select {
+	case email := <-emails:
+		if len(email.To) != 1 || email.To[0] != assignee.Email {
+			t.Errorf("the reminder was not sent to the assignee: %v", email.To)
+		}
+		if !strings.Contains(email.Data, "Subject: Reminder: Buy milk\r\n") {
+			t.Errorf("unexpected reminder: %s", email.Data)
+		}
+		if !strings.HasSuffix(email.Data, "/lists/1") {
+			t.Errorf("the reminder does not link to the list: %s", email.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reminder was emailed")
+	}
+
+	// The assignee cannot be loaded: the reminder is skipped
+	hub.conn = usersConn{err: errors.New("connection refused")}
+	hub.remind(thing)
+	select {
+	case email := <-emails:
+		t.Errorf("a reminder was emailed without an assignee: %+v", email)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// nextSequenceSQL numbers the next event of a list. The row of the list
+// stays locked until the transaction ends, so the events of a list are
+// committed in the order of their sequence.
+const nextSequenceSQL = `UPDATE "lists" SET "seq" = COALESCE("seq", 0) + 1
+WHERE "id" = $1 RETURNING "seq"`
+
+// lockList locks the row of the list until the transaction ends
+func lockList(conn sql.Connection, listID int64) error {
+	var id int64
+	stmt := rawStmt{
+		sql:  `SELECT "id" FROM "lists" WHERE "id" = $1 FOR UPDATE`,
+		args: []interface{}{listID},
+	}
+	err := conn.QueryOne(stmt, &id)
+	if err == sql.ErrNoResult {
+		err = invalid("List does not exist")
+	}
+	return err
+}
+
+// recordEvent adds the message to the event log of the list and sets the
+// message's sequence number. It must be called within a transaction.
+func recordEvent(conn sql.Connection, listID int64, out *OutgoingMessage) error {
+	content, err := json.Marshal(out.Content)
+	if err != nil {
+		return err
+	}
+	event := db.Event{
+		ListID:   listID,
+		Resource: out.Resource,
+		Event:    out.Event,
+		Content:  string(content),
+	}
+	next := rawStmt{sql: nextSequenceSQL, args: []interface{}{listID}}
+	if err := conn.QueryOne(next, &event.Sequence); err != nil {
+		return err
+	}
+	stmt := pg.Insert(db.Events).Values(event).Returning(db.Events)
+	if err := conn.QueryOne(stmt, &event); err != nil {
+		return err
+	}
+	out.Sequence = event.Sequence
+	return nil
+}
+
+// latestSequence returns the sequence of the most recent committed event
+// of the list, or zero if the list has no events
+func latestSequence(conn sql.Connection, listID int64) (sequence int64) {
+	stmt := rawStmt{
+		sql:  `SELECT COALESCE("seq", 0) FROM "lists" WHERE "id" = $1`,
+		args: []interface{}{listID},
+	}
+	conn.MustQueryOne(stmt, &sequence)
+	return
+}
+
+// replayEvents returns the events of the list that followed the given
+// sequence. It returns false if the events cannot be replayed because the
+// event of the given sequence has been pruned or too many events followed it.
+func replayEvents(conn sql.Connection, listID, since int64, limit int) ([]Message, bool) {
+	if since < 1 {
+		return nil, false
+	}
+
+	// Every event after a retained event is also retained
+	var retained int64
+	stmt := sql.Select(db.Events.C["sequence"]).Where(
+		db.Events.C["list_id"].Equals(listID),
+		db.Events.C["sequence"].Equals(since),
+	)
+	if !conn.MustQueryOne(stmt, &retained) {
+		return nil, false
+	}
+
+	events := []db.Event{}
+	conn.MustQueryAll(db.Events.Select().Where(
+		db.Events.C["list_id"].Equals(listID),
+		db.Events.C["sequence"].GreaterThan(since),
+	).OrderBy(db.Events.C["sequence"]).Limit(limit+1), &events)
+	if len(events) > limit {
+		return nil, false
+	}
+
+	msgs := make([]Message, len(events))
+	for i, event := range events {
+		msgs[i] = OutgoingMessage{
+			Resource: event.Resource,
+			Event:    event.Event,
+			Sequence: event.Sequence,
+			Content:  json.RawMessage(event.Content),
+		}
+	}
+	return msgs, true
+}
+
+// pruneEvents periodically deletes events older than the retention period
+func (hub *Hub) pruneEvents() {
+	interval := hub.settings.EventRetention / 24
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	for now := range time.Tick(interval) {
+		stmt := db.Events.Delete().Where(
+			db.Events.C["created_at"].LessThan(
+				now.UTC().Add(-hub.settings.EventRetention),
+			),
+		)
+		if _, err := hub.conn.Execute(stmt); err != nil {
+			log.Printf("error: could not prune events: %s", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+func TestEventsAreNumberedInCommitOrder(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+
+	first, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("could not begin: %s", err)
+	}
+	a := OutgoingMessage{Resource: "things", Event: CREATE, Content: "a"}
+	if err = recordEvent(first, list.ID, &a); err != nil {
+		first.Rollback()
+		t.Fatalf("could not record the first event: %s", err)
+	}
+
+	// An event of the same list waits for the first to commit
+	type result struct {
+		msg OutgoingMessage
+		err error
+	}
+	recorded := make(chan result, 1)
+	go func() {
+		second, err := conn.Begin()
+		if err != nil {
+			recorded <- result{err: err}
+			return
+		}
+		b := OutgoingMessage{Resource: "things", Event: CREATE, Content: "b"}
+		if err = recordEvent(second, list.ID, &b); err != nil {
+			second.Rollback()
+		} else {
+			err = second.Commit()
+		}
+		recorded <- result{msg: b, err: err}
+	}()
+	select {
+	case <-recorded:
+		t.Fatal("the second event did not wait for the first to commit")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err = first.Commit(); err != nil {
+		t.Fatalf("could not commit the first event: %s", err)
+	}
+	second := <-recorded
+	if second.err != nil {
+		t.Fatalf("could not record the second event: %s", second.err)
+	}
+	if a.Sequence != 1 || second.msg.Sequence != 2 {
+		t.Errorf("unexpected sequences %d and %d", a.Sequence, second.msg.Sequence)
+	}
+	if latest := latestSequence(conn, list.ID); latest != 2 {
+		t.Errorf("unexpected latest sequence %d", latest)
+	}
+
+	// Clients that saw the first event are sent the second
+	events, ok := replayEvents(conn, list.ID, 1, 10)
+	if !ok || len(events) != 1 || events[0].(OutgoingMessage).Sequence != 2 {
+		t.Errorf("unexpected replay %v (ok: %t)", events, ok)
+	}
+
+	// Other lists have their own sequence
+	other, removeOther := dbtest.List(t, conn)
+	defer removeOther()
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("could not begin: %s", err)
+	}
+	c := OutgoingMessage{Resource: "things", Event: CREATE, Content: "c"}
+	if err = recordEvent(tx, other.ID, &c); err != nil {
+		tx.Rollback()
+		t.Fatalf("could not record the event of another list: %s", err)
+	}
+	tx.Commit()
+	if c.Sequence != 1 {
+		t.Errorf("unexpected sequence %d for another list", c.Sequence)
+	}
+}
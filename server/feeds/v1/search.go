@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	sql "github.com/aodin/aspect"
+	"github.com/aodin/volta/config"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Searches return at most this many results
+const MaxSearchResults = 100
+
+// Search is the content of a "search" request
+type Search struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// Result is a thing that matched a search. The snippet is escaped HTML with
+// the matching words wrapped in <mark> tags.
+type Result struct {
+	Thing   db.Thing `json:"thing"`
+	Rank    float64  `json:"rank"`
+	Snippet string   `json:"snippet"`
+}
+
+// Searcher finds the things of a list that match a query, best match first
+type Searcher interface {
+	Search(listID int64, query string, limit int) ([]Result, error)
+}
+
+// NewSearcher uses Postgres full-text search if the database is Postgres,
+// and otherwise an in-process index that follows the broadcaster
+func NewSearcher(database config.DatabaseConfig, conn sql.Connection, broadcaster Broadcaster) Searcher {
+	if database.Driver == "postgres" {
+		return &PostgresSearcher{conn: conn}
+	}
+	index := NewIndex(conn)
+	broadcaster.Subscribe(index.Receive)
+	return index
+}
+
+// searchLimit returns a limit within (0, MaxSearchResults]
+func searchLimit(limit int) int {
+	if limit < 1 || limit > MaxSearchResults {
+		return MaxSearchResults
+	}
+	return limit
+}
+
+// Markers of matches in snippets, which are replaced after escaping
+const (
+	startMatch = "\x02"
+	stopMatch  = "\x03"
+)
+
+// markSnippet escapes the snippet and wraps the marked matches
+func markSnippet(snippet string) string {
+	return strings.NewReplacer(
+		startMatch, "<mark>",
+		stopMatch, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// words splits text into lowercase words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// document returns the searchable text of a thing's content fields
+func document(thing db.Thing) string {
+	values := make([]string, 0, len(thing.Fields))
+	for _, value := range thing.Fields {
+		values = append(values, fmt.Sprint(value))
+	}
+	return strings.Join(values, " ")
+}
+
+// PostgresSearcher ranks things with Postgres full-text search over the
+// values of their JSON content
+type PostgresSearcher struct {
+	conn sql.Connection
+}
+
+// rawStmt is SQL that aspect cannot build, with its parameters in order
+type rawStmt struct {
+	sql  string
+	args []interface{}
+}
+
+func (stmt rawStmt) String() string {
+	return stmt.sql
+}
+
+func (stmt rawStmt) Compile(d sql.Dialect, params *sql.Parameters) (string, error) {
+	for _, arg := range stmt.args {
+		params.Add(arg)
+	}
+	return stmt.sql, nil
+}
+
+// The document of each thing is the text of its content values
+const searchSQL = `SELECT "things".*,
+  ts_rank(to_tsvector('english', "doc"."text"), "query") AS "rank",
+  ts_headline('english', "doc"."text", "query", $3) AS "snippet"
+FROM "things"
+  CROSS JOIN LATERAL (
+    SELECT COALESCE(string_agg("value", ' '), '') AS "text"
+    FROM json_each_text("things"."content")
+  ) AS "doc"
+  CROSS JOIN plainto_tsquery('english', $2) AS "query"
+WHERE "things"."list_id" = $1
+  AND "things"."deleted_at" IS NULL
+  AND to_tsvector('english', "doc"."text") @@ "query"
+ORDER BY "rank" DESC, "things"."position"
+LIMIT $4`
+
+// Search returns the best matching things of the list
+func (s *PostgresSearcher) Search(listID int64, query string, limit int) ([]Result, error) {
+	var rows []struct {
+		db.Thing
+		Rank    float64 `db:"rank"`
+		Snippet string  `db:"snippet"`
+	}
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2", startMatch, stopMatch)
+	stmt := rawStmt{
+		sql:  searchSQL,
+		args: []interface{}{listID, query, options, searchLimit(limit)},
+	}
+	if err := s.conn.QueryAll(stmt, &rows); err != nil {
+		return nil, err
+	}
+	things := make(Things, len(rows))
+	for i, row := range rows {
+		things[i] = row.Thing
+	}
+	things.Decode()
+	if err := loadRelated(s.conn, things); err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(rows))
+	for i, row := range rows {
+		results[i] = Result{
+			Thing:   things[i],
+			Rank:    row.Rank,
+			Snippet: markSnippet(row.Snippet),
+		}
+	}
+	return results, nil
+}
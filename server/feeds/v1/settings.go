@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"time"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Settings configure the feeds. They are parsed from the "feeds" key of the
+// configuration file.
+type Settings struct {
+	Broadcaster  string        `json:"broadcaster"`   // Either "local" or "postgres"
+	Channel      string        `json:"channel"`       // The Postgres NOTIFY channel
+	QueueSize    int           `json:"queue_size"`    // Outbound messages per connection
+	PingInterval time.Duration `json:"ping_interval"` // Time between heartbeats
+	ReadTimeout  time.Duration `json:"read_timeout"`  // Time allowed between client messages
+	WriteTimeout time.Duration `json:"write_timeout"` // Time allowed for a single write
+	IdleTimeout  time.Duration `json:"idle_timeout"`  // Time allowed without activity, zero never expires
+
+	// Reconnecting clients are sent the events they missed, unless there
+	// are more than the replay limit or they are older than the retention
+	ReplayLimit    int           `json:"replay_limit"`
+	EventRetention time.Duration `json:"event_retention"`
+
+	// Deleted things are purged from the trash after the retention, zero
+	// keeps them forever
+	TrashRetention time.Duration `json:"trash_retention"`
+
+	// Due things are checked for reminders at this interval, zero never
+	// sends reminders
+	ReminderInterval time.Duration `json:"reminder_interval"`
+
+	// Things are sent in pages of this size, zero sends every thing at once
+	PageSize int `json:"page_size"`
+
+	// Either "cascade" to delete the sub-items of deleted things, or
+	// "refuse" to only delete things without sub-items
+	DeletePolicy string `json:"delete_policy"`
+
+	// The fields things may have, db.DefaultSchema if none are declared
+	Schema db.Schema `json:"schema"`
+}
+
+// DefaultSettings only broadcast to connections of the local process
+var DefaultSettings = Settings{
+	Broadcaster:  "local",
+	Channel:      "listofthings",
+	QueueSize:    64,
+	PingInterval: 30 * time.Second,
+	ReadTimeout:  75 * time.Second,
+	WriteTimeout: 10 * time.Second,
+
+	ReplayLimit:    500,
+	EventRetention: 24 * time.Hour,
+	TrashRetention: 30 * 24 * time.Hour,
+
+	ReminderInterval: time.Minute,
+	DeletePolicy:     Cascade,
+	PageSize:         200,
+}
@@ -0,0 +1,215 @@
+package v1
+
+import (
+	"encoding/json"
+	"log"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Subscription limits the things sent to a connection to those with any of
+// its tags. An empty subscription is sent every thing. New things have no
+// tags, so their CREATE is not sent: a TAG, which has the whole thing, is
+// the first event of a thing that starts to match.
+type Subscription struct {
+	Tags []string `json:"tags"`
+}
+
+// Matches returns true if the thing has any of the subscribed tags
+func (s Subscription) Matches(thing db.Thing) bool {
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, tag := range thing.Tags {
+		for _, subscribed := range s.Tags {
+			if tag == subscribed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// taggedClause selects the things with any of the tags. Aspect cannot build
+// subqueries, so the inner select is compiled with the outer parameters.
+type taggedClause []string
+
+func (tags taggedClause) String() string {
+	compiled, _ := tags.Compile(&pg.PostGres{}, sql.Params())
+	return compiled
+}
+
+func (tags taggedClause) Compile(d sql.Dialect, params *sql.Parameters) (string, error) {
+	stmt := sql.Select(db.ThingTags.C["thing_id"]).JoinOn(
+		db.Tags, db.Tags.C["id"].Equals(db.ThingTags.C["tag_id"]),
+	).Where(
+		db.Tags.C["name"].In([]string(tags)),
+	)
+	inner, err := stmt.Compile(d, params)
+	if err != nil {
+		return "", err
+	}
+	return `"things"."id" IN (` + inner + `)`, nil
+}
+
+// tagClauses returns the clauses that select things with any of the tags
+func tagClauses(tags []string) []sql.Clause {
+	if len(tags) == 0 {
+		return nil
+	}
+	return []sql.Clause{taggedClause(tags)}
+}
+
+// scope adds the tags of the subscription to a request for a page of
+// things, so the page is cut from the things that will be sent
+func (s Subscription) scope(in IncomingMessage) IncomingMessage {
+	if len(s.Tags) == 0 || in.Resource != "things" {
+		return in
+	}
+	var content interface{}
+	switch in.Event {
+	case "list_more":
+		var more More
+		if json.Unmarshal(in.Content, &more) != nil {
+			return in
+		}
+		more.Tags = s.Tags
+		content = more
+	case "read":
+		var query Query
+		if len(in.Content) > 0 && json.Unmarshal(in.Content, &query) != nil {
+			return in
+		}
+		query.Tags = s.Tags
+		content = query
+	default:
+		return in
+	}
+	in.Content, _ = json.Marshal(content)
+	return in
+}
+
+func (s Subscription) matching(things Things) Things {
+	matched := Things{}
+	for _, thing := range things {
+		if s.Matches(thing) {
+			matched = append(matched, thing)
+		}
+	}
+	return matched
+}
+
+// Filter returns the message with only the things that match the
+// subscription, or false if none of them do. Changes to tags are always
+// sent, so clients can drop things that stop matching.
+func (s Subscription) Filter(msg Message) (Message, bool) {
+	out, ok := msg.(OutgoingMessage)
+	if !ok || len(s.Tags) == 0 || out.Resource != "things" {
+		return msg, true
+	}
+	switch out.Event {
+	case TAG, UNTAG, ERROR, CONFLICT, SEARCH, HISTORY, REMINDER:
+		return msg, true
+	case LIST, LIST_MORE:
+		// Sub-items are kept if they match, even if their parent does not
+		var things Things
+		if !decodeContent(out.Content, &things) {
+			return msg, true
+		}
+		out.Content = buildTree(s.matching(flatten(things)))
+		return out, true
+	case MOVE, CLEAR:
+		var things Things
+		if !decodeContent(out.Content, &things) {
+			return msg, true
+		}
+		if out.Content = s.matching(things); len(out.Content.(Things)) == 0 {
+			return nil, false
+		}
+		return out, true
+	}
+	var thing db.Thing
+	if !decodeContent(out.Content, &thing) {
+		return msg, true
+	}
+	if !s.Matches(thing) {
+		return nil, false
+	}
+	return msg, true
+}
+
+// decodeMessage converts the content of a message about things to the type
+// that the subscriptions of connections filter. Messages from other instances
+// are decoded once, instead of once for every connection.
+func decodeMessage(msg OutgoingMessage) OutgoingMessage {
+	if msg.Resource != "things" {
+		return msg
+	}
+	switch msg.Event {
+	case ERROR, CONFLICT, SEARCH, HISTORY, REMINDER:
+	case LIST, LIST_MORE, MOVE, CLEAR:
+		var things Things
+		if decodeContent(msg.Content, &things) {
+			msg.Content = things
+		}
+	default:
+		var thing db.Thing
+		if decodeContent(msg.Content, &thing) {
+			msg.Content = thing
+		}
+	}
+	return msg
+}
+
+// decodeContent copies the content of a message into the destination.
+// Content from other instances or the event log must be converted from JSON.
+func decodeContent(content interface{}, dst interface{}) bool {
+	switch v := content.(type) {
+	case db.Thing:
+		if thing, ok := dst.(*db.Thing); ok {
+			*thing = v
+			return true
+		}
+	case Things:
+		if things, ok := dst.(*Things); ok {
+			*things = v
+			return true
+		}
+	}
+	b, err := json.Marshal(content)
+	if err != nil {
+		log.Printf("error: could not encode content: %s", err)
+		return false
+	}
+	if err = json.Unmarshal(b, dst); err != nil {
+		log.Printf("error: could not decode content: %s", err)
+		return false
+	}
+	return true
+}
+
+// subscribe changes the subscription of the connection and sends it the
+// first page of the things of its list that match, with the filter it
+// connected with
+func (hub *Hub) subscribe(connection *Connection, in IncomingMessage) error {
+	var subscription Subscription
+	if len(in.Content) > 0 {
+		if err := json.Unmarshal(in.Content, &subscription); err != nil {
+			return err
+		}
+	}
+	connection.Subscribe(subscription)
+	out, err := hub.listPage(connection.ListID, More{
+		Filter: connection.filter,
+		Tags:   subscription.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	out.RequestID = in.RequestID
+	hub.Send(connection, out)
+	return nil
+}
@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+func TestSubscriptionFiltersRemoteMessages(t *testing.T) {
+	subscription := Subscription{Tags: []string{"backend"}}
+
+	// Envelopes from other instances have JSON content
+	remote := func(event, content string) OutgoingMessage {
+		var msg OutgoingMessage
+		raw := `{"resource": "things", "method": "` + event + `", "content": ` + content + `}`
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatalf("could not decode a message: %s", err)
+		}
+		return decodeMessage(msg)
+	}
+
+	created := remote(CREATE, `{"id": 1, "name": "Deploy", "tags": []}`)
+	thing, ok := created.Content.(db.Thing)
+	if !ok || thing.ID != 1 || thing.String() != "Deploy" {
+		t.Fatalf("unexpected decoded content %#v", created.Content)
+	}
+	if _, ok = subscription.Filter(created); ok {
+		t.Error("a thing without tags was sent to a tag subscription")
+	}
+
+	tagged := remote(TAG, `{"id": 1, "name": "Deploy", "tags": ["backend"]}`)
+	if _, ok = subscription.Filter(tagged); !ok {
+		t.Error("the thing was not sent when it was tagged")
+	}
+	updated := remote(UPDATE, `{"id": 1, "name": "Deploy!", "tags": ["backend"]}`)
+	if _, ok = subscription.Filter(updated); !ok {
+		t.Error("a matching update was not sent")
+	}
+
+	moved := remote(MOVE, `[{"id": 1, "tags": ["backend"]}, {"id": 2, "tags": []}]`)
+	msg, ok := subscription.Filter(moved)
+	if !ok {
+		t.Fatal("a move of a matching thing was not sent")
+	}
+	if things := msg.(OutgoingMessage).Content.(Things); len(things) != 1 || things[0].ID != 1 {
+		t.Errorf("unexpected moved things %v", things)
+	}
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"strings"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Tagging is the content of "tag" and "untag" requests
+type Tagging struct {
+	ID  int64  `json:"id"`
+	Tag string `json:"tag"`
+}
+
+// loadTags sets the tag names of each thing
+func loadTags(conn sql.Connection, things Things) error {
+	if len(things) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(things))
+	for i := range things {
+		ids[i] = things[i].ID
+		things[i].Tags = []string{}
+	}
+	var tagged []struct {
+		ThingID int64  `db:"thing_id"`
+		TagID   int64  `db:"tag_id"`
+		Name    string `db:"name"`
+	}
+	stmt := db.ThingTags.Select(db.Tags.C["name"]).JoinOn(
+		db.Tags, db.Tags.C["id"].Equals(db.ThingTags.C["tag_id"]),
+	).Where(
+		db.ThingTags.C["thing_id"].In(ids),
+	).OrderBy(db.Tags.C["name"])
+	if err := conn.QueryAll(stmt, &tagged); err != nil {
+		return err
+	}
+	index := make(map[int64]int)
+	for i, thing := range things {
+		index[thing.ID] = i
+	}
+	for _, tag := range tagged {
+		i := index[tag.ThingID]
+		things[i].Tags = append(things[i].Tags, tag.Name)
+	}
+	return nil
+}
+
+// getTag returns the tag of the list with the given name, creating it
+// if it does not exist
+func getTag(conn sql.Connection, listID int64, name string) (tag db.Tag, err error) {
+	stmt := db.Tags.Select().Where(
+		db.Tags.C["list_id"].Equals(listID),
+		db.Tags.C["name"].Equals(name),
+	)
+	if err = conn.QueryOne(stmt, &tag); err != sql.ErrNoResult {
+		return
+	}
+	tag = db.NewTag(listID, name)
+	err = conn.QueryOne(pg.Insert(db.Tags).Values(tag).Returning(db.Tags), &tag)
+	return
+}
+
+// tagThing adds the tag to a thing that is not in the trash and returns its
+// new copy. Tagging a thing twice has no effect.
+func tagThing(conn sql.Connection, listID int64, tagging Tagging) (thing db.Thing, err error) {
+	if thing, err = getThing(conn, listID, tagging.ID); err != nil {
+		return
+	}
+	if thing.IsDeleted() {
+		err = invalid("Thing has been deleted")
+		return
+	}
+	tag := db.NewTag(listID, strings.TrimSpace(tagging.Tag))
+	if err = tag.Error(); err != nil {
+		return
+	}
+	if tag, err = getTag(conn, listID, tag.Name); err != nil {
+		return
+	}
+	var existing int64
+	stmt := sql.Select(db.ThingTags.C["tag_id"]).Where(
+		db.ThingTags.C["thing_id"].Equals(thing.ID),
+		db.ThingTags.C["tag_id"].Equals(tag.ID),
+	)
+	if err = conn.QueryOne(stmt, &existing); err != sql.ErrNoResult {
+		return // Already tagged, or the query failed
+	}
+	insert := db.ThingTags.Insert().Values(sql.Values{
+		"thing_id": thing.ID,
+		"tag_id":   tag.ID,
+	})
+	if _, err = conn.Execute(insert); err != nil {
+		return
+	}
+	return getThing(conn, listID, thing.ID)
+}
+
+// untagThing removes the tag from a thing and returns its new copy
+func untagThing(conn sql.Connection, listID int64, tagging Tagging) (thing db.Thing, err error) {
+	if thing, err = getThing(conn, listID, tagging.ID); err != nil {
+		return
+	}
+	var tag db.Tag
+	stmt := db.Tags.Select().Where(
+		db.Tags.C["list_id"].Equals(listID),
+		db.Tags.C["name"].Equals(strings.TrimSpace(tagging.Tag)),
+	)
+	if err = conn.QueryOne(stmt, &tag); err != nil {
+		if err == sql.ErrNoResult {
+			err = invalid("Tag does not exist")
+		}
+		return
+	}
+	remove := db.ThingTags.Delete().Where(
+		db.ThingTags.C["thing_id"].Equals(thing.ID),
+		db.ThingTags.C["tag_id"].Equals(tag.ID),
+	)
+	if _, err = conn.Execute(remove); err != nil {
+		return
+	}
+	return getThing(conn, listID, thing.ID)
+}
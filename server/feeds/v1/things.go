@@ -1,116 +1,378 @@
 package v1
 
-// import (
-// 	"fmt"
-// 	"html"
-// 	"sync"
-
-// 	"github.com/aodin/listofthings/db"
-// 	"github.com/aodin/listofthings/db/fields"
-// )
-
-// type Store interface {
-// 	List() []*db.Thing
-// 	Create(*db.Thing) (*db.Thing, error)
-// 	Delete(*db.Thing) (*db.Thing, error)
-// 	Update(*db.Thing) (*db.Thing, error)
-// }
-
-// type InMemoryStore struct {
-// 	sync.Mutex
-// 	things []*db.Thing
-// }
-
-// func (m *InMemoryStore) List() []*db.Thing {
-// 	// TODO Read-writer mutex? Do we need to lock on read?
-
-// 	// Remove nil things
-// 	things := make([]*db.Thing, 0)
-// 	for _, thing := range m.things {
-// 		if thing == nil {
-// 			continue
-// 		}
-// 		things = append(things, thing)
-// 	}
-// 	return things
-// }
-
-// // TODO Should validation be methods of the Resource or the Store?
-
-// func (m *InMemoryStore) Create(thing *db.Thing) (*db.Thing, error) {
-// 	// Lock the memory storage while this occurs
-// 	m.Lock()
-// 	defer m.Unlock()
-
-// 	// Find an open slot
-// 	var index int64
-// 	for i, t := range m.things {
-// 		if t == nil {
-// 			index = int64(i) + 1
-// 			break
-// 		}
-// 	}
-
-// 	if index == 0 {
-// 		// There are no more open slots
-// 		return nil, fmt.Errorf("Only %d items can be stored.", len(m.things))
-// 	}
-
-// 	// Escape all items on input
-// 	thing.ID = index
-// 	thing.Name = html.EscapeString(thing.Name)
-// 	thing.Timestamp = fields.NewTimestamp()
-
-// 	// Add it to the memory store
-// 	m.things[index-1] = thing
-// 	return thing, nil
-// }
-
-// func (m *InMemoryStore) Delete(thing *db.Thing) (*db.Thing, error) {
-// 	// Lock the memory storage while this occurs
-// 	m.Lock()
-// 	defer m.Unlock()
-
-// 	// Does the thing exist?
-// 	index := int(thing.ID)
-// 	if index > len(m.things) || index < 1 {
-// 		return nil, fmt.Errorf("Invalid id")
-// 	}
-
-// 	thing = m.things[index-1]
-// 	if thing == nil {
-// 		return nil, fmt.Errorf("Thing does not exist")
-// 	}
-
-// 	// Delete it
-// 	m.things[index-1] = nil
-// 	return thing, nil
-// }
-
-// func (m *InMemoryStore) Update(thing *db.Thing) (*db.Thing, error) {
-// 	// Lock the memory storage while this occurs
-// 	m.Lock()
-// 	defer m.Unlock()
-
-// 	// Does the index make sense
-// 	index := int(thing.ID)
-// 	if index > len(m.things) || index < 1 {
-// 		return nil, fmt.Errorf("Invalid id")
-// 	}
-
-// 	original := m.things[index-1]
-// 	if original == nil {
-// 		return nil, fmt.Errorf("Thing does not exist")
-// 	}
-
-// 	// Maintain the timestamp and id from the original
-// 	original.Name = html.EscapeString(thing.Name)
-
-// 	// Update it
-// 	m.things[index-1] = original
-// 	return original, nil
-// }
-
-// func NewMemoryStore(n int) *InMemoryStore {
-// 	return &InMemoryStore{things: make([]*db.Thing, n)}
-// }
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// ErrNoThing is returned for things that are not in the list
+var ErrNoThing = errors.New("Thing does not exist")
+
+// ConflictError is returned when a change was based on a version of a thing
+// that is no longer current
+type ConflictError struct {
+	Version int64
+	Current db.Thing
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf(
+		"Thing %d was changed by someone else (version %d, not %d)",
+		e.Current.ID, e.Current.Version, e.Version,
+	)
+}
+
+// InvalidError is returned for requests that cannot be applied as they were
+// sent, such as content that does not match the schema
+type InvalidError struct {
+	Message string
+}
+
+func (e InvalidError) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...interface{}) error {
+	return InvalidError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Ref is the content of "delete" and "restore" requests
+type Ref struct {
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+}
+
+// unmarshalThing reads the system fields of a thing from the message and
+// validates its content fields through the schema
+func unmarshalThing(msg IncomingMessage, schema db.Schema) (thing db.Thing, err error) {
+	if err = json.Unmarshal(msg.Content, &thing); err != nil {
+		return
+	}
+	var content map[string]interface{}
+	if content, err = schema.Clean(thing.Fields); err != nil {
+		return thing, InvalidError{Message: err.Error()}
+	}
+	err = thing.SetFields(content)
+	return
+}
+
+type Things []db.Thing
+
+// Decode sets the fields of each thing from its saved content
+func (t Things) Decode() {
+	for i := range t {
+		if err := t[i].Decode(); err != nil {
+			log.Printf("error: could not decode thing %d: %s", t[i].ID, err)
+		}
+	}
+}
+
+// loadRelated sets the tags and comment counts of each thing
+func loadRelated(conn sql.Connection, things Things) error {
+	if err := loadTags(conn, things); err != nil {
+		return err
+	}
+	return loadComments(conn, things)
+}
+
+// getThings returns the things of the list that are not in the trash
+func getThings(conn sql.Connection, listID int64, filters ...sql.Clause) (things Things, err error) {
+	things = Things{}
+	clauses := append([]sql.Clause{
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+	}, filters...)
+	stmt := db.Things.Select().Where(clauses...).OrderBy(
+		db.Things.C["position"], db.Things.C["id"],
+	)
+	if err = conn.QueryAll(stmt, &things); err != nil {
+		return
+	}
+	things.Decode()
+	err = loadRelated(conn, things)
+	return
+}
+
+// getThing returns the current copy of a thing in the given list
+func getThing(conn sql.Connection, listID, id int64) (thing db.Thing, err error) {
+	stmt := db.Things.Select().Where(
+		db.Things.C["id"].Equals(id),
+		db.Things.C["list_id"].Equals(listID),
+	)
+	if err = conn.QueryOne(stmt, &thing); err != nil {
+		if err == sql.ErrNoResult {
+			err = ErrNoThing
+		}
+		return
+	}
+	if err = thing.Decode(); err != nil {
+		return
+	}
+	things := Things{thing}
+	err = loadRelated(conn, things)
+	return things[0], err
+}
+
+func handleThings(conn sql.Connection, settings Settings, user db.User, listID int64, in IncomingMessage) (out OutgoingMessage, err error) {
+	if in.Resource != "things" {
+		err = invalid("Unknown resource: %s", in.Resource)
+		return
+	}
+	out.Resource = "things"
+	out.RequestID = in.RequestID
+
+	// Things can only be changed within the list of the connection. Updates
+	// and deletes must include the version they were based on.
+	var thing db.Thing
+	switch in.Event {
+	case "create":
+		out.Event = CREATE
+		if thing, err = unmarshalThing(in, settings.Schema); err != nil {
+			return
+		}
+		if thing, err = createThing(conn, listID, thing); err != nil {
+			return
+		}
+		out.Content = thing
+	case "move":
+		// Moves, reparents and collapses do not conflict with other changes,
+		// so no version is needed
+		out.Event = MOVE
+		var move Move
+		if err = json.Unmarshal(in.Content, &move); err != nil {
+			return
+		}
+		out.Content, err = moveThing(conn, listID, move)
+	case "reparent":
+		out.Event = REPARENT
+		var reparent Reparent
+		if err = json.Unmarshal(in.Content, &reparent); err != nil {
+			return
+		}
+		out.Content, err = reparentThing(conn, listID, reparent)
+	case "collapse":
+		// Collapsed subtrees are shared by everyone viewing the list
+		out.Event = COLLAPSE
+		var collapse Collapse
+		if err = json.Unmarshal(in.Content, &collapse); err != nil {
+			return
+		}
+		out.Content, err = arrangeThing(conn, listID, collapse.ID, sql.Values{
+			"collapsed": collapse.Collapsed,
+		})
+	case "toggle":
+		out.Event = TOGGLE
+		var toggle Toggle
+		if err = json.Unmarshal(in.Content, &toggle); err != nil {
+			return
+		}
+		out.Content, err = toggleThing(conn, listID, user, toggle)
+	case "schedule":
+		out.Event = SCHEDULE
+		var schedule Schedule
+		if err = json.Unmarshal(in.Content, &schedule); err != nil {
+			return
+		}
+		out.Content, err = scheduleThing(conn, listID, schedule)
+	case "tag":
+		out.Event = TAG
+		var tagging Tagging
+		if err = json.Unmarshal(in.Content, &tagging); err != nil {
+			return
+		}
+		out.Content, err = tagThing(conn, listID, tagging)
+	case "untag":
+		out.Event = UNTAG
+		var tagging Tagging
+		if err = json.Unmarshal(in.Content, &tagging); err != nil {
+			return
+		}
+		out.Content, err = untagThing(conn, listID, tagging)
+	case "clear":
+		// Clear every completed thing at once
+		out.Event = CLEAR
+		out.Content, err = clearCompleted(conn, settings, listID)
+	case "delete":
+		// Deleted things are moved to the trash until they are purged
+		out.Event = DELETE
+		var ref Ref
+		if err = json.Unmarshal(in.Content, &ref); err != nil {
+			return
+		}
+		thing = db.Thing{ID: ref.ID, Version: ref.Version}
+		if settings.DeletePolicy == Refuse {
+			var descendants Things
+			if descendants, err = subtree(conn, listID, thing.ID, nil); err != nil {
+				return
+			}
+			if len(descendants) > 0 {
+				err = invalid("Things with sub-items cannot be deleted")
+				return
+			}
+		}
+		if thing, err = trashThing(conn, listID, thing, time.Now().UTC()); err != nil {
+			return
+		}
+		out.Content = thing
+	case "restore":
+		out.Event = RESTORE
+		var ref Ref
+		if err = json.Unmarshal(in.Content, &ref); err != nil {
+			return
+		}
+		thing = db.Thing{ID: ref.ID, Version: ref.Version}
+		var current db.Thing
+		if current, err = getThing(conn, listID, thing.ID); err != nil {
+			return
+		}
+		if current.ParentID != nil {
+			var parent db.Thing
+			if parent, err = getThing(conn, listID, *current.ParentID); err != nil {
+				return
+			}
+			if parent.IsDeleted() {
+				err = invalid("The parent of this thing is in the trash")
+				return
+			}
+		}
+		now := time.Now().UTC()
+		values := sql.Values{
+			"version":    thing.Version + 1,
+			"updated_at": now,
+			"deleted_at": nil,
+		}
+		if thing, err = changeThing(conn, listID, thing, values, true); err != nil {
+			return
+		}
+		thing.Children, err = changeSubtree(conn, listID, thing.ID, current.DeletedAt, sql.Values{
+			"updated_at": now,
+			"deleted_at": nil,
+		})
+		out.Content = thing
+	case "update":
+		out.Event = UPDATE
+		if thing, err = unmarshalThing(in, settings.Schema); err != nil {
+			return
+		}
+		values := thing.Values()
+		values["version"] = thing.Version + 1
+		values["updated_at"] = time.Now().UTC()
+		if thing, err = changeThing(conn, listID, thing, values, false); err != nil {
+			return
+		}
+		out.Content = thing
+	case "revert":
+		// Reverts are broadcast as updates
+		out.Event = UPDATE
+		var revert Revert
+		if err = json.Unmarshal(in.Content, &revert); err != nil {
+			return
+		}
+		out.Content, err = revertThing(conn, settings.Schema, listID, revert)
+	default:
+		err = invalid("Unknown method: %s", in.Event)
+	}
+	if err == nil {
+		err = revise(conn, user, in.Event, out.Content)
+	}
+	return
+}
+
+// createThing inserts a thing at the end of the list. Only the content
+// fields, parent and schedule of the given thing are used, every other
+// field is set by the server.
+func createThing(conn sql.Connection, listID int64, in db.Thing) (thing db.Thing, err error) {
+	thing = db.Thing{ListID: listID, Version: 1, Content: in.Content}
+	if in.ParentID != nil && *in.ParentID != 0 {
+		if err = checkParent(conn, listID, 0, *in.ParentID); err != nil {
+			return
+		}
+		thing.ParentID = in.ParentID
+	}
+	if in.DueAt != nil {
+		due := in.DueAt.UTC()
+		thing.DueAt = &due
+	}
+	if in.AssigneeID != nil && *in.AssigneeID != 0 {
+		if _, err = checkAssignee(conn, listID, *in.AssigneeID); err != nil {
+			return
+		}
+		thing.AssigneeID = in.AssigneeID
+	}
+	if thing.Position, err = lastPosition(conn, listID); err != nil {
+		return
+	}
+	stmt := pg.Insert(db.Things).Values(thing).Returning(db.Things)
+	if err = conn.QueryOne(stmt, &thing); err != nil {
+		return
+	}
+	err = thing.Decode()
+	return
+}
+
+// trashThing moves a thing to the trash along with its sub-items. The
+// sub-items are deleted at the same time, so they are restored with it.
+func trashThing(conn sql.Connection, listID int64, thing db.Thing, now time.Time) (db.Thing, error) {
+	values := sql.Values{
+		"version":    thing.Version + 1,
+		"updated_at": now,
+		"deleted_at": now,
+	}
+	thing, err := changeThing(conn, listID, thing, values, false)
+	if err != nil {
+		return thing, err
+	}
+	thing.Children, err = changeSubtree(conn, listID, thing.ID, nil, sql.Values{
+		"updated_at": now,
+		"deleted_at": thing.DeletedAt,
+	})
+	return thing, err
+}
+
+// changeThing updates the thing with the given values and returns its new
+// copy. The thing must be at the given version and either in the trash or
+// not. Otherwise an error is returned, which is a ConflictError if only the
+// version is no longer current.
+func changeThing(conn sql.Connection, listID int64, thing db.Thing, values sql.Values, trashed bool) (db.Thing, error) {
+	var inTrash sql.Clause = db.Things.C["deleted_at"].IsNull()
+	if trashed {
+		inTrash = db.Things.C["deleted_at"].IsNotNull()
+	}
+	stmt := db.Things.Update().Values(values).Where(
+		db.Things.C["id"].Equals(thing.ID),
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["version"].Equals(thing.Version),
+		inTrash,
+	)
+	result, err := conn.Execute(stmt)
+	if err != nil {
+		return thing, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return thing, err
+	}
+
+	current, err := getThing(conn, listID, thing.ID)
+	if err != nil || n > 0 {
+		return current, err
+	}
+	if thing.Version == 0 {
+		return thing, invalid("Changes to things must include their version")
+	}
+	if current.IsDeleted() != trashed {
+		if trashed {
+			return thing, invalid("Thing is not in the trash")
+		}
+		return thing, invalid("Thing has been deleted")
+	}
+	return thing, ConflictError{Version: thing.Version, Current: current}
+}
@@ -0,0 +1,306 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+// dbHub creates a hub that commits to the given database without running
+func dbHub(conn sql.Connection) *Hub {
+	settings := DefaultSettings
+	settings.Schema = db.DefaultSchema
+	return &Hub{conn: conn, settings: settings}
+}
+
+// change commits a change to a thing and returns the changed thing
+func change(hub *Hub, listID int64, user db.User, event, content string) (db.Thing, error) {
+	out, err := hub.commit(listID, user, IncomingMessage{
+		Resource: "things",
+		Event:    event,
+		Content:  json.RawMessage(content),
+	})
+	if err != nil {
+		return db.Thing{}, err
+	}
+	thing, _ := out.Content.(db.Thing)
+	return thing, nil
+}
+
+// insertConn records the columns and values of inserted things. The list
+// has a single thing with ID 3, and its members are the users that opened
+// it.
+type insertConn struct {
+	sql.Connection
+	values  map[string]interface{}
+	members map[int64]bool
+}
+
+var insertColumns = regexp.MustCompile(`^INSERT INTO "things" \(([^)]*)\)`)
+
+func (c *insertConn) QueryAll(stmt sql.Executable, dst interface{}) error {
+	if things, ok := dst.(*Things); ok {
+		*things = Things{{ID: 3, Version: 1}}
+	}
+	return nil
+}
+
+func (c *insertConn) QueryOne(stmt sql.Executable, dst interface{}) error {
+	switch dst := dst.(type) {
+	case *float64:
+		*dst = 1024
+	case *db.User:
+		dst.ID = 2 // Every user exists
+	case *db.Member:
+		if !c.members[2] {
+			return sql.ErrNoResult
+		}
+		dst.ListID, dst.UserID = 1, 2
+	case *db.Thing:
+		params := sql.Params()
+		compiled, err := stmt.Compile(&pg.PostGres{}, params)
+		if err != nil {
+			return err
+		}
+		match := insertColumns.FindStringSubmatch(compiled)
+		if match == nil {
+			return fmt.Errorf("unexpected insert: %s", compiled)
+		}
+		c.values = make(map[string]interface{})
+		for i, column := range strings.Split(match[1], ", ") {
+			c.values[strings.Trim(column, `"`)] = params.Args()[i]
+		}
+		dst.ID = 1
+		dst.Content, _ = c.values["content"].(string)
+	}
+	return nil
+}
+
+func TestCreateIgnoresSystemFields(t *testing.T) {
+	in := IncomingMessage{Content: json.RawMessage(`{
+		"id": 99, "list_id": 5, "version": 7, "parent_id": 3, "name": "Milk",
+		"completed": true, "completed_at": "2015-01-01T00:00:00Z", "completed_by": 2,
+		"created_at": "2015-01-01T00:00:00Z", "updated_at": "2015-01-01T00:00:00Z",
+		"deleted_at": "2015-01-01T00:00:00Z", "reminded_at": "2015-01-01T00:00:00Z"
+	}`)}
+	thing, err := unmarshalThing(in, db.DefaultSchema)
+	if err != nil {
+		t.Fatalf("could not unmarshal a thing: %s", err)
+	}
+	conn := &insertConn{}
+	if thing, err = createThing(conn, 1, thing); err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	if thing.String() != "Milk" {
+		t.Errorf("unexpected created thing %+v", thing)
+	}
+
+	// Only the content and parent are taken from the request
+	for column, value := range conn.values {
+		switch column {
+		case "content":
+		case "list_id", "version":
+			if value != int64(1) {
+				t.Errorf("unexpected %s %v", column, value)
+			}
+		case "parent_id":
+			if parent, ok := value.(*int64); !ok || *parent != 3 {
+				t.Errorf("unexpected parent %v", value)
+			}
+		case "position":
+			if value != float64(1024+PositionGap) {
+				t.Errorf("unexpected position %v", value)
+			}
+		default:
+			if v := reflect.ValueOf(value); v.IsValid() && v.Kind() == reflect.Ptr && !v.IsNil() ||
+				v.Kind() == reflect.Bool && v.Bool() {
+				t.Errorf("%s was set by the request: %v", column, v.Elem())
+			}
+		}
+	}
+	for _, column := range []string{"id", "created_at"} {
+		if _, ok := conn.values[column]; ok {
+			t.Errorf("%s was set by the request", column)
+		}
+	}
+}
+
+func TestUnmarshalThing(t *testing.T) {
+	in := IncomingMessage{Content: json.RawMessage(
+		`{"id": 1, "version": 2, "name": "Milk", "unknown": true}`,
+	)}
+	thing, err := unmarshalThing(in, db.DefaultSchema)
+	if err != nil {
+		t.Fatalf("could not unmarshal a thing: %s", err)
+	}
+	if thing.ID != 1 || thing.Version != 2 || thing.Content != `{"name":"Milk"}` {
+		t.Errorf("unexpected thing %+v", thing)
+	}
+
+	in.Content = json.RawMessage(`{"id": 1, "version": 2}`)
+	if _, err = unmarshalThing(in, db.DefaultSchema); err == nil {
+		t.Error("a thing without a name was valid")
+	}
+}
+
+func TestDeleteAndRestoreByVersion(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "trash@example.com")
+	hub := dbHub(conn)
+
+	thing, err := change(hub, list.ID, user, "create", `{"name": "Milk"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+
+	// Only the id and version are needed, not the content of the thing
+	ref := fmt.Sprintf(`{"id": %d, "version": 1}`, thing.ID)
+	if thing, err = change(hub, list.ID, user, "delete", ref); err != nil {
+		t.Fatalf("could not delete the thing: %s", err)
+	}
+	if thing.Version != 2 || !thing.IsDeleted() || thing.String() != "Milk" {
+		t.Errorf("unexpected deleted thing %+v", thing)
+	}
+
+	ref = fmt.Sprintf(`{"id": %d, "version": 2}`, thing.ID)
+	if thing, err = change(hub, list.ID, user, "restore", ref); err != nil {
+		t.Fatalf("could not restore the thing: %s", err)
+	}
+	if thing.Version != 3 || thing.IsDeleted() || thing.String() != "Milk" {
+		t.Errorf("unexpected restored thing %+v", thing)
+	}
+}
+
+func TestStaleChangesConflict(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "conflicts@example.com")
+	hub := dbHub(conn)
+
+	thing, err := change(hub, list.ID, user, "create", `{"name": "Milk"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	if thing.Version != 1 {
+		t.Errorf("unexpected version %d of a new thing", thing.Version)
+	}
+
+	update := fmt.Sprintf(`{"id": %d, "version": 1, "name": "Oat milk"}`, thing.ID)
+	if thing, err = change(hub, list.ID, user, "update", update); err != nil {
+		t.Fatalf("could not update the thing: %s", err)
+	}
+	if thing.Version != 2 {
+		t.Errorf("unexpected version %d after an update", thing.Version)
+	}
+
+	// Changes based on the first version conflict with the current thing
+	stale := fmt.Sprintf(`{"id": %d, "version": 1, "name": "Soy milk"}`, thing.ID)
+	_, err = change(hub, list.ID, user, "update", stale)
+	conflict, ok := err.(ConflictError)
+	if !ok {
+		t.Fatalf("a stale update did not conflict: %v", err)
+	}
+	if conflict.Current.Version != 2 || conflict.Current.String() != "Oat milk" {
+		t.Errorf("unexpected current thing %+v", conflict.Current)
+	}
+	ref := fmt.Sprintf(`{"id": %d, "version": 1}`, thing.ID)
+	if _, err = change(hub, list.ID, user, "delete", ref); err == nil {
+		t.Fatal("a stale delete did not conflict")
+	} else if _, ok = err.(ConflictError); !ok {
+		t.Errorf("a stale delete failed without a conflict: %s", err)
+	}
+
+	// Nothing was changed by the conflicting requests
+	current, err := hub.Thing(list.ID, thing.ID)
+	if err != nil {
+		t.Fatalf("could not get the thing: %s", err)
+	}
+	if current.Version != 2 || current.IsDeleted() {
+		t.Errorf("the thing was changed by a stale request: %+v", current)
+	}
+}
+
+func TestToggleBumpsVersion(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "toggles@example.com")
+	hub := dbHub(conn)
+
+	thing, err := change(hub, list.ID, user, "create", `{"name": "Milk"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	toggle := fmt.Sprintf(`{"id": %d, "completed": true}`, thing.ID)
+	if thing, err = change(hub, list.ID, user, "toggle", toggle); err != nil {
+		t.Fatalf("could not complete the thing: %s", err)
+	}
+	if thing.Version != 2 || !thing.Completed {
+		t.Errorf("unexpected completed thing %+v", thing)
+	}
+
+	// An update based on the version before completion conflicts
+	stale := fmt.Sprintf(`{"id": %d, "version": 1, "name": "Oat milk"}`, thing.ID)
+	if _, err = change(hub, list.ID, user, "update", stale); err == nil {
+		t.Error("an update based on an incomplete thing did not conflict")
+	}
+}
+
+func TestArrangingKeepsVersion(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "arrange@example.com")
+	hub := dbHub(conn)
+
+	parent, err := change(hub, list.ID, user, "create", `{"name": "Groceries"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	thing, err := change(hub, list.ID, user, "create", `{"name": "Milk"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	for _, arrange := range []struct{ event, content string }{
+		{"move", fmt.Sprintf(`{"id": %d}`, thing.ID)},
+		{"reparent", fmt.Sprintf(`{"id": %d, "parent_id": %d}`, thing.ID, parent.ID)},
+		{"collapse", fmt.Sprintf(`{"id": %d, "collapsed": true}`, parent.ID)},
+	} {
+		if _, err = hub.commit(list.ID, user, IncomingMessage{
+			Resource: "things",
+			Event:    arrange.event,
+			Content:  json.RawMessage(arrange.content),
+		}); err != nil {
+			t.Fatalf("could not %s: %s", arrange.event, err)
+		}
+	}
+
+	// Edits based on the version before the changes do not conflict
+	update := fmt.Sprintf(`{"id": %d, "version": 1, "name": "Oat milk"}`, thing.ID)
+	if thing, err = change(hub, list.ID, user, "update", update); err != nil {
+		t.Fatalf("an update conflicted with a move: %s", err)
+	}
+	if thing.Version != 2 || thing.ParentID == nil || *thing.ParentID != parent.ID {
+		t.Errorf("unexpected updated thing %+v", thing)
+	}
+	rename := fmt.Sprintf(`{"id": %d, "version": 1, "name": "Food"}`, parent.ID)
+	if _, err = change(hub, list.ID, user, "update", rename); err != nil {
+		t.Errorf("an update conflicted with a collapse: %s", err)
+	}
+}
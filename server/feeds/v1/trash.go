@@ -0,0 +1,82 @@
+package v1
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// getTrash returns the deleted things of the list, most recently deleted
+// first
+func getTrash(conn sql.Connection, listID int64) (things Things) {
+	things = Things{}
+	conn.MustQueryAll(db.Things.Select().Where(
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNotNull(),
+	).OrderBy(db.Things.C["deleted_at"].Desc()), &things)
+	things.Decode()
+	if err := loadRelated(conn, things); err != nil {
+		log.Printf("error: could not load tags and comments: %s", err)
+	}
+	return
+}
+
+// purgeTrash periodically deletes things that have been in the trash for
+// longer than the retention period
+func (hub *Hub) purgeTrash() {
+	interval := hub.settings.TrashRetention / 24
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	for now := range time.Tick(interval) {
+		if err := hub.purge(now.UTC().Add(-hub.settings.TrashRetention)); err != nil {
+			log.Printf("error: could not purge the trash: %s", err)
+		}
+	}
+}
+
+// purge deletes the things that were put in the trash before the given
+// time, along with the files attached to them
+func (hub *Hub) purge(before time.Time) error {
+	expired := db.Things.C["deleted_at"].LessThan(before)
+	tx, err := hub.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attachments []db.Attachment
+	stmt := db.Attachments.Select().JoinOn(
+		db.Things, db.Things.C["id"].Equals(db.Attachments.C["thing_id"]),
+	).Where(expired)
+	if err = tx.QueryAll(stmt, &attachments); err != nil {
+		return err
+	}
+	if _, err = tx.Execute(db.Things.Delete().Where(expired)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// Files are removed once their rows are gone, along with the emptied
+	// directories of their things
+	for _, attachment := range attachments {
+		for _, p := range []string{attachment.Path, attachment.Thumbnail} {
+			if p == "" {
+				continue
+			}
+			file := filepath.Join(hub.config.MediaDir, filepath.FromSlash(p))
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Printf("error: could not remove attachment %d: %s", attachment.ID, err)
+			}
+			os.Remove(filepath.Dir(file))
+		}
+	}
+	return nil
+}
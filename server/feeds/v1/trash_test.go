@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+func TestPurgeRemovesAttachedFiles(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "purges@example.com")
+	hub := dbHub(conn)
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hub.config.MediaDir = dir
+
+	thing, err := change(hub, list.ID, user, "create", `{"name": "Receipt"}`)
+	if err != nil {
+		t.Fatalf("could not create a thing: %s", err)
+	}
+	attachment := db.Attachment{
+		ThingID:     thing.ID,
+		UserID:      user.ID,
+		Name:        "receipt.txt",
+		ContentType: "text/plain",
+		Path:        filepath.ToSlash(filepath.Join("things", fmt.Sprint(thing.ID), "receipt.txt")),
+	}
+	file := filepath.Join(dir, filepath.FromSlash(attachment.Path))
+	os.MkdirAll(filepath.Dir(file), 0755)
+	if err = ioutil.WriteFile(file, []byte("milk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Execute(db.Attachments.Insert().Values(attachment)); err != nil {
+		t.Fatalf("could not attach a file: %s", err)
+	}
+
+	trashed := time.Now().UTC().Add(-time.Hour)
+	conn.Execute(db.Things.Update().Values(sql.Values{"deleted_at": trashed}).Where(
+		db.Things.C["id"].Equals(thing.ID),
+	))
+	if err = hub.purge(time.Now().UTC()); err != nil {
+		t.Fatalf("could not purge the trash: %s", err)
+	}
+	if _, err = getThing(conn, list.ID, thing.ID); err != ErrNoThing {
+		t.Errorf("the thing was not purged: %v", err)
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Error("the attached file was not removed")
+	}
+	if _, err = os.Stat(filepath.Dir(file)); !os.IsNotExist(err) {
+		t.Error("the directory of the thing was not removed")
+	}
+}
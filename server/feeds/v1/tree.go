@@ -0,0 +1,201 @@
+package v1
+
+import (
+	"time"
+
+	sql "github.com/aodin/aspect"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+// Policies for deleting things that have sub-items
+const (
+	Cascade = "cascade" // Sub-items are moved to the trash with their parent
+	Refuse  = "refuse"  // Things with sub-items cannot be deleted
+)
+
+// Reparent is the content of a "reparent" request. A zero ParentID moves
+// the thing to the top level.
+type Reparent struct {
+	ID       int64 `json:"id"`
+	ParentID int64 `json:"parent_id"`
+}
+
+// Collapse is the content of a "collapse" request
+type Collapse struct {
+	ID        int64 `json:"id"`
+	Collapsed bool  `json:"collapsed"`
+}
+
+// buildTree nests things under their parents, keeping their order. Things
+// whose parent is not included are returned at the top level.
+func buildTree(things Things) Things {
+	included := make(map[int64]bool)
+	for _, thing := range things {
+		included[thing.ID] = true
+	}
+	roots := Things{}
+	children := make(map[int64]Things)
+	for _, thing := range things {
+		if thing.ParentID != nil && included[*thing.ParentID] {
+			children[*thing.ParentID] = append(children[*thing.ParentID], thing)
+		} else {
+			roots = append(roots, thing)
+		}
+	}
+	var attach func(Things) Things
+	attach = func(level Things) Things {
+		for i := range level {
+			level[i].Children = attach(children[level[i].ID])
+		}
+		return level
+	}
+	return attach(roots)
+}
+
+// flatten returns the things followed by their nested children, in order
+func flatten(things Things) (flat Things) {
+	for _, thing := range things {
+		children := thing.Children
+		thing.Children = nil
+		flat = append(flat, thing)
+		flat = append(flat, flatten(children)...)
+	}
+	return
+}
+
+// subtree returns the descendants of the thing in order. If deletedAt is
+// nil only descendants still in the list are returned, otherwise only
+// those deleted at the same time.
+func subtree(conn sql.Connection, listID, id int64, deletedAt *time.Time) (descendants Things, err error) {
+	var inTrash sql.Clause = db.Things.C["deleted_at"].IsNull()
+	if deletedAt != nil {
+		inTrash = db.Things.C["deleted_at"].Equals(*deletedAt)
+	}
+	var things Things
+	stmt := db.Things.Select().Where(
+		db.Things.C["list_id"].Equals(listID),
+		inTrash,
+	).OrderBy(db.Things.C["position"], db.Things.C["id"])
+	if err = conn.QueryAll(stmt, &things); err != nil {
+		return
+	}
+
+	children := make(map[int64][]int64)
+	for _, thing := range things {
+		if thing.ParentID != nil {
+			children[*thing.ParentID] = append(children[*thing.ParentID], thing.ID)
+		}
+	}
+	included := make(map[int64]bool)
+	for queue := children[id]; len(queue) > 0; queue = queue[1:] {
+		if !included[queue[0]] {
+			included[queue[0]] = true
+			queue = append(queue, children[queue[0]]...)
+		}
+	}
+	for _, thing := range things {
+		if included[thing.ID] {
+			descendants = append(descendants, thing)
+		}
+	}
+	descendants.Decode()
+	return
+}
+
+// changeSubtree applies the values to every descendant of the thing that
+// matches the trash state and returns them nested under their parents
+func changeSubtree(conn sql.Connection, listID, id int64, deletedAt *time.Time, values sql.Values) (Things, error) {
+	descendants, err := subtree(conn, listID, id, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	for i, descendant := range descendants {
+		values["version"] = descendant.Version + 1
+		stmt := db.Things.Update().Values(values).Where(
+			db.Things.C["id"].Equals(descendant.ID),
+		)
+		if _, err = conn.Execute(stmt); err != nil {
+			return nil, err
+		}
+		if descendants[i], err = getThing(conn, listID, descendant.ID); err != nil {
+			return nil, err
+		}
+	}
+	return buildTree(descendants), nil
+}
+
+// checkParent returns an error if the thing cannot be nested under the
+// parent. New things have an ID of zero.
+func checkParent(conn sql.Connection, listID, id, parentID int64) error {
+	var things Things
+	stmt := db.Things.Select().Where(
+		db.Things.C["list_id"].Equals(listID),
+		db.Things.C["deleted_at"].IsNull(),
+	)
+	if err := conn.QueryAll(stmt, &things); err != nil {
+		return err
+	}
+	parents := make(map[int64]*int64)
+	for _, thing := range things {
+		parents[thing.ID] = thing.ParentID
+	}
+	if _, exists := parents[parentID]; !exists {
+		return invalid("Parent thing does not exist")
+	}
+	// Walk up from the parent, which must not pass through the thing
+	ancestor := &parentID
+	for i := 0; ancestor != nil && i <= len(things); i++ {
+		if *ancestor == id {
+			return invalid("Things cannot be nested under themselves")
+		}
+		ancestor = parents[*ancestor]
+	}
+	return nil
+}
+
+// reparentThing nests a thing under a new parent, or moves it to the top
+// level, and returns its new copy
+func reparentThing(conn sql.Connection, listID int64, reparent Reparent) (thing db.Thing, err error) {
+	var parentID *int64
+	if reparent.ParentID != 0 {
+		if err = checkParent(conn, listID, reparent.ID, reparent.ParentID); err != nil {
+			return
+		}
+		parentID = &reparent.ParentID
+	}
+	return arrangeThing(conn, listID, reparent.ID, sql.Values{
+		"parent_id": parentID,
+	})
+}
+
+// setThing updates a thing that is not in the trash and returns its new
+// copy, with the next version
+func setThing(conn sql.Connection, listID, id int64, values sql.Values) (thing db.Thing, err error) {
+	if thing, err = getThing(conn, listID, id); err != nil {
+		return
+	}
+	if thing.IsDeleted() {
+		err = ErrNoThing
+		return
+	}
+	values["version"] = thing.Version + 1
+	values["updated_at"] = time.Now().UTC()
+	return changeThing(conn, listID, thing, values, false)
+}
+
+// arrangeThing changes where a thing is shown, such as its parent or whether
+// its sub-items are collapsed, and returns its new copy. Only changes to
+// the thing itself are versioned, so its version is unchanged and it never
+// conflicts with edits.
+func arrangeThing(conn sql.Connection, listID, id int64, values sql.Values) (thing db.Thing, err error) {
+	if thing, err = getThing(conn, listID, id); err != nil {
+		return
+	}
+	if thing.IsDeleted() {
+		err = ErrNoThing
+		return
+	}
+	values["updated_at"] = time.Now().UTC()
+	return changeThing(conn, listID, thing, values, false)
+}
@@ -0,0 +1,38 @@
+package v1
+
+import "testing"
+
+func TestReparentCycles(t *testing.T) {
+	// Milk is nested under Groceries, which is nested under Errands
+	parent := func(id int64) *int64 { return &id }
+	conn := thingsConn{things: func() (Things, error) {
+		return Things{
+			{ID: 1, Content: `{"name": "Errands"}`},
+			{ID: 2, ParentID: parent(1), Content: `{"name": "Groceries"}`},
+			{ID: 3, ParentID: parent(2), Content: `{"name": "Milk"}`},
+			{ID: 4, Content: `{"name": "Laundry"}`},
+		}, nil
+	}}
+
+	for _, reparent := range []Reparent{
+		{ID: 1, ParentID: 1}, // Under itself
+		{ID: 1, ParentID: 2}, // Under its child
+		{ID: 1, ParentID: 3}, // Under its grandchild
+		{ID: 2, ParentID: 3},
+	} {
+		_, err := reparentThing(conn, 1, reparent)
+		if err == nil || err.Error() != "Things cannot be nested under themselves" {
+			t.Errorf("unexpected error of the cycle %+v: %v", reparent, err)
+		}
+	}
+	if _, err := reparentThing(conn, 1, Reparent{ID: 4, ParentID: 5}); err == nil {
+		t.Error("a thing was nested under a parent that does not exist")
+	}
+
+	// Siblings, ancestors and new things can be nested anywhere
+	for _, reparent := range []Reparent{{ID: 3, ParentID: 1}, {ID: 4, ParentID: 3}, {ID: 0, ParentID: 3}} {
+		if err := checkParent(conn, 1, reparent.ID, reparent.ParentID); err != nil {
+			t.Errorf("could not nest %+v: %s", reparent, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package lists
+
+import (
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+)
+
+type ListManager struct {
+	conn sql.Connection
+}
+
+// Create creates a new list owned by the given user. It will return an
+// error if the list is invalid.
+func (m *ListManager) Create(name string, owner db.User) (list db.List, err error) {
+	list = db.NewList(name)
+	if err = list.Error(); err != nil {
+		return
+	}
+	tx, err := m.conn.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	stmt := pg.Insert(db.Lists).Values(list).Returning(db.Lists)
+	if err = tx.QueryOne(stmt, &list); err != nil {
+		return
+	}
+	member := db.Member{ListID: list.ID, UserID: owner.ID, Owner: true}
+	if _, err = tx.Execute(db.Members.Insert().Values(member)); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// Member returns the membership of the user in the list, which will not
+// exist if the user has never opened the list
+func (m *ListManager) Member(listID int64, user db.User) (member db.Member, err error) {
+	stmt := db.Members.Select().Where(
+		db.Members.C["list_id"].Equals(listID),
+		db.Members.C["user_id"].Equals(user.ID),
+	)
+	if err = m.conn.QueryOne(stmt, &member); err == sql.ErrNoResult {
+		err = nil
+	}
+	return
+}
+
+// Owner returns the owner of the list, which will not exist if nobody has
+// opened the list since it was created without one
+func (m *ListManager) Owner(listID int64) (member db.Member, err error) {
+	stmt := db.Members.Select().Where(
+		db.Members.C["list_id"].Equals(listID),
+		db.Members.C["owner"].Equals(true),
+	)
+	if err = m.conn.QueryOne(stmt, &member); err == sql.ErrNoResult {
+		err = nil
+	}
+	return
+}
+
+// Join records that the user opened the list, if they have not already.
+// Every user who opens a list joins it. Lists without an owner, such as the
+// default list, are owned by the first user to open them.
+func (m *ListManager) Join(list db.List, user db.User) error {
+	member, err := m.Member(list.ID, user)
+	if err != nil || member.Exists() {
+		return err
+	}
+	owner, err := m.Owner(list.ID)
+	if err != nil {
+		return err
+	}
+	member = db.Member{ListID: list.ID, UserID: user.ID, Owner: !owner.Exists()}
+	_, err = m.conn.Execute(db.Members.Insert().Values(member))
+	if err != nil && member.Owner {
+		// Another user became the owner first, which the unique index on
+		// owners refuses
+		member.Owner = false
+		_, err = m.conn.Execute(db.Members.Insert().Values(member))
+	}
+	return err
+}
+
+func (m *ListManager) Get(id int64) (list db.List) {
+	stmt := db.Lists.Select().Where(
+		db.Lists.C["id"].Equals(id),
+		db.Lists.C["deleted_at"].IsNull(),
+	)
+	m.conn.MustQueryOne(stmt, &list)
+	return
+}
+
+// Default returns the oldest list, which is used by routes that predate
+// multiple lists
+func (m *ListManager) Default() (list db.List) {
+	stmt := db.Lists.Select().Where(
+		db.Lists.C["deleted_at"].IsNull(),
+	).OrderBy(db.Lists.C["id"]).Limit(1)
+	m.conn.MustQueryOne(stmt, &list)
+	return
+}
+
+func (m *ListManager) All() (lists []db.List) {
+	lists = []db.List{}
+	stmt := db.Lists.Select().Where(
+		db.Lists.C["deleted_at"].IsNull(),
+	).OrderBy(db.Lists.C["id"])
+	m.conn.MustQueryAll(stmt, &lists)
+	return
+}
+
+// Lists creates a new list manager
+func Lists(conn sql.Connection) *ListManager {
+	return &ListManager{
+		conn: conn,
+	}
+}
@@ -0,0 +1,63 @@
+package lists
+
+import (
+	"testing"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+)
+
+func TestMembers(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	owner := dbtest.User(t, conn, "owner@example.com")
+	other := dbtest.User(t, conn, "other@example.com")
+	m := Lists(conn)
+
+	list, err := m.Create("Groceries", owner)
+	if err != nil {
+		t.Fatalf("could not create a list: %s", err)
+	}
+	defer conn.Execute(db.Lists.Delete().Where(db.Lists.C["id"].Equals(list.ID)))
+
+	if member, err := m.Member(list.ID, owner); err != nil || !member.Owner {
+		t.Errorf("the creator does not own the list: %+v, %v", member, err)
+	}
+	if member, err := m.Member(list.ID, other); err != nil || member.Exists() {
+		t.Errorf("another user is a member before opening the list: %+v, %v", member, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = m.Join(list, other); err != nil {
+			t.Fatalf("could not join the list: %s", err)
+		}
+	}
+	if member, err := m.Member(list.ID, other); err != nil || !member.Exists() || member.Owner {
+		t.Errorf("unexpected membership %+v, %v", member, err)
+	}
+}
+
+func TestOwnerlessLists(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	first := dbtest.User(t, conn, "first@example.com")
+	second := dbtest.User(t, conn, "second@example.com")
+	m := Lists(conn)
+
+	// The first user to open a list without an owner owns it
+	if owner, err := m.Owner(list.ID); err != nil || owner.Exists() {
+		t.Fatalf("unexpected owner of a new list %+v, %v", owner, err)
+	}
+	for _, user := range []db.User{first, second} {
+		if err := m.Join(list, user); err != nil {
+			t.Fatalf("could not join the list: %s", err)
+		}
+	}
+	if owner, err := m.Owner(list.ID); err != nil || owner.UserID != first.ID {
+		t.Errorf("the first user to open the list does not own it: %+v, %v", owner, err)
+	}
+	if member, err := m.Member(list.ID, second); err != nil || member.Owner {
+		t.Errorf("unexpected membership %+v, %v", member, err)
+	}
+}
@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aodin/volta/config"
+)
+
+// Mailer sends plain text and HTML emails
+type Mailer interface {
+	Send(to, subject, body string) error
+	SendHTML(to, subject, body string) error
+}
+
+// SMTPMailer sends emails through the SMTP server of volta's config
+type SMTPMailer struct {
+	config config.SMTPConfig
+}
+
+// Send sends a plain text email
+func (m SMTPMailer) Send(to, subject, body string) error {
+	return m.send(to, Message(m.config.FromAddress(), to, subject, "text/plain", body))
+}
+
+// SendHTML sends an HTML email
+func (m SMTPMailer) SendHTML(to, subject, body string) error {
+	return m.send(to, Message(m.config.FromAddress(), to, subject, "text/html", body))
+}
+
+// send sends the message. Servers that do not offer STARTTLS, such as a
+// local test listener, are sent the message unencrypted.
+func (m SMTPMailer) send(to string, msg []byte) error {
+	var auth smtp.Auth
+	if m.config.User != "" {
+		auth = smtp.PlainAuth("", m.config.User, m.config.Password, m.config.Host)
+	}
+	return smtp.SendMail(
+		m.config.Address(),
+		auth,
+		m.config.From,
+		[]string{to},
+		msg,
+	)
+}
+
+// Message creates the email with its headers. Line breaks are removed from
+// the headers so they cannot add headers of their own.
+func Message(from, to, subject, contentType, body string) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&msg, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", encodeHeader(clean.Replace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s; charset=utf-8\r\n\r\n", contentType)
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return msg.Bytes()
+}
+
+// Encoded words of headers hold at most this many bytes, which are 60
+// characters of base64 within the 75 characters allowed by RFC 2047
+const encodedWordBytes = 45
+
+// encodeHeader returns the value of a header as RFC 2047 encoded words,
+// unless it is printable ASCII. Words are split between characters and
+// folded onto their own lines.
+func encodeHeader(value string) string {
+	printable := true
+	for i := 0; i < len(value); i++ {
+		if value[i] < ' ' || value[i] > '~' {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return value
+	}
+	var words []string
+	for len(value) > 0 {
+		n := 0
+		for n < len(value) {
+			_, size := utf8.DecodeRuneInString(value[n:])
+			if n+size > encodedWordBytes {
+				break
+			}
+			n += size
+		}
+		words = append(words, "=?utf-8?b?"+base64.StdEncoding.EncodeToString([]byte(value[:n]))+"?=")
+		value = value[n:]
+	}
+	return strings.Join(words, "\r\n ")
+}
+
+// New returns a mailer for the given SMTP config, or nil if no host is
+// configured
+func New(config config.SMTPConfig) Mailer {
+	if config.Host == "" {
+		return nil
+	}
+	return SMTPMailer{config: config}
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aodin/listofthings/server/mail/mailtest"
+)
+
+func TestEncodeHeader(t *testing.T) {
+	if encoded := encodeHeader("Reminder: Milk"); encoded != "Reminder: Milk" {
+		t.Errorf("a printable header was encoded: %s", encoded)
+	}
+
+	subject := "Reminder: " + strings.Repeat("Café au lait ", 8)
+	var decoded string
+	for _, word := range strings.Split(encodeHeader(subject), "\r\n ") {
+		if len(word) > 75 {
+			t.Errorf("encoded word is longer than 75 characters: %s", word)
+		}
+		if !strings.HasPrefix(word, "=?utf-8?b?") || !strings.HasSuffix(word, "?=") {
+			t.Fatalf("unexpected encoded word %s", word)
+		}
+		b, err := base64.StdEncoding.DecodeString(word[10 : len(word)-2])
+		if err != nil {
+			t.Fatalf("could not decode %s: %s", word, err)
+		}
+		decoded += string(b)
+	}
+	if decoded != subject {
+		t.Errorf("unexpected decoded subject %q", decoded)
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	smtp, emails, stop := mailtest.Listen(t)
+	defer stop()
+
+	mailer := New(smtp)
+	if err := mailer.Send("ann@example.com", "Café\r\nBcc: eve@example.com", "Milk\nEggs"); err != nil {
+		t.Fatalf("could not send an email: %s", err)
+	}
+	select {
+	case email := <-emails:
+		if email.From != "things@example.com" || len(email.To) != 1 || email.To[0] != "ann@example.com" {
+			t.Errorf("unexpected envelope %+v", email)
+		}
+		if !strings.Contains(email.Data, "Subject: =?utf-8?b?Q2Fmw6lCY2M6IGV2ZUBleGFtcGxlLmNvbQ==?=\r\n") {
+			t.Errorf("unexpected headers: %s", email.Data)
+		}
+		if !strings.HasSuffix(email.Data, "\r\n\r\nMilk\r\nEggs") {
+			t.Errorf("unexpected body: %q", email.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was received")
+	}
+}
@@ -0,0 +1,93 @@
+// Package mailtest runs an SMTP server for tests that send email
+package mailtest
+
+import (
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aodin/volta/config"
+)
+
+// Email is a message received by the server
+type Email struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Listen starts an SMTP server on a local port and returns the config of a
+// mailer that sends to it. Received emails are sent on the channel until
+// the returned function is called.
+func Listen(t *testing.T) (smtp config.SMTPConfig, emails <-chan Email, stop func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mailtest: could not listen: %s", err)
+	}
+	received := make(chan Email, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, received)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	smtp.Host = host
+	smtp.Port, _ = strconv.ParseInt(port, 10, 64)
+	smtp.From = "things@example.com"
+	return smtp, received, func() { ln.Close() }
+}
+
+// serve answers the commands of a single SMTP session
+func serve(conn net.Conn, received chan<- Email) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	var email Email
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			email = Email{From: address(line)}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			email.To = append(email.To, address(line))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			email.Data = strings.Join(lines, "\r\n")
+			received <- email
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// address returns the address of a MAIL or RCPT command
+func address(line string) string {
+	start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
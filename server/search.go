@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// writeJSON writes the value as JSON with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// SearchHandler searches the things of a list with
+// GET /api/v1/search?list={id}&q={query}&limit={limit}. Without a list, the
+// default list is searched.
+func (srv *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "Searches must use GET",
+		})
+		return
+	}
+	if !srv.user(r).Exists() {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "A session is required",
+		})
+		return
+	}
+	list, status, err := srv.requestList(r)
+	if err != nil {
+		writeJSON(w, status, feeds.ErrorContent{Message: err.Error()})
+		return
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	results, err := srv.searcher.Search(list.ID, r.FormValue("q"), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, feeds.ErrorContent{
+			Message: err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
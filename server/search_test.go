@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aodin/volta/config"
+)
+
+func TestSearchRequiresSession(t *testing.T) {
+	srv := &Server{config: config.Default}
+	r, _ := http.NewRequest("GET", "/api/v1/search?list=1&q=milk", nil)
+	w := httptest.NewRecorder()
+	srv.SearchHandler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status %d without a session", w.Code)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("a session was created by the search: %s", cookie)
+	}
+}
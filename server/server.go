@@ -1,7 +1,11 @@
 package server
 
 import (
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"code.google.com/p/go.net/websocket"
 	sql "github.com/aodin/aspect"
@@ -9,24 +13,37 @@ import (
 	"github.com/aodin/volta/templates"
 
 	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/server/attachments"
 	"github.com/aodin/listofthings/server/auth"
+	"github.com/aodin/listofthings/server/digests"
 	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/lists"
+	"github.com/aodin/listofthings/server/mail"
+	"github.com/aodin/listofthings/server/spec"
+	"github.com/aodin/listofthings/server/webhooks"
 )
 
 // Wrap HTTP methods
 type Server struct {
-	config    config.Config
-	sessions  *auth.SessionManager
-	templates *templates.Templates
-	users     *auth.UserManager
+	config      config.Config
+	attachments *attachments.AttachmentManager
+	digests     *digests.DigestManager
+	hub         *feeds.Hub
+	lists       *lists.ListManager
+	searcher    feeds.Searcher
+	sessions    *auth.SessionManager
+	spec        spec.Document
+	templates   *templates.Templates
+	users       *auth.UserManager
+	webhooks    *webhooks.WebhookManager
 }
 
 // TODO auth function?
 func (srv *Server) RequireSession(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var session db.Session
-		if cookie, err := r.Cookie(srv.config.Cookie.Name); err == nil {
-			session = srv.sessions.Get(cookie.Value)
+		if key := auth.RequestKey(r, srv.config.Cookie); key != "" {
+			session = srv.sessions.Get(key)
 		}
 
 		// If the cookie value is invalid, create a new user and session
@@ -40,34 +57,137 @@ func (srv *Server) RequireSession(f http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// user returns the user of the request's session cookie or token, which
+// will not exist if the request has no valid session
+func (srv *Server) user(r *http.Request) (user db.User) {
+	if key := auth.RequestKey(r, srv.config.Cookie); key != "" {
+		user = srv.sessions.GetUser(key)
+	}
+	return
+}
+
 func (srv *Server) ListenAndServe() error {
 	return http.ListenAndServe(srv.config.Address(), nil)
 }
 
-// Index is the handler for the index
+// Index is the handler for the index, which shows all lists
 func (srv *Server) IndexHandler(w http.ResponseWriter, r *http.Request) {
-	// Assign a session if one has not been set
-	srv.templates.Execute(w, "index")
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	user := srv.user(r)
+	srv.templates.Execute(w, "lists", templates.Attrs{
+		"Lists":  srv.lists.All(),
+		"User":   user,
+		"Digest": srv.digests.Get(user),
+	})
+}
+
+// ListHandler creates lists with a POST to /lists/ and shows a single list
+// at /lists/{id}
+func (srv *Server) ListHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/lists/"), "/")
+	if path == "" {
+		srv.CreateListHandler(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	list := srv.lists.Get(id)
+	if !list.Exists() {
+		http.NotFound(w, r)
+		return
+	}
+	user := srv.user(r)
+	if err := srv.lists.Join(list, user); err != nil {
+		log.Printf("error: %s could not join list %d: %s", user, list.ID, err)
+	}
+	// The page marks the things of its user, such as their comments
+	srv.templates.Execute(w, "index", templates.Attrs{
+		"List": list,
+		"User": user,
+	})
+}
+
+// CreateListHandler creates a new list from a form POST and redirects to it
+func (srv *Server) CreateListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	user := srv.user(r)
+	list, err := srv.lists.Create(strings.TrimSpace(r.FormValue("name")), user)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		srv.templates.Execute(w, "lists", templates.Attrs{
+			"Lists":  srv.lists.All(),
+			"User":   user,
+			"Digest": srv.digests.Get(user),
+			"Error":  err.Error(),
+		})
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/lists/%d", list.ID), http.StatusFound)
 }
 
 // New creates a new server. It will panic on error
-func New(config config.Config, conn sql.Connection) *Server {
+func New(config config.Config, settings Settings, conn sql.Connection) *Server {
 	srv := &Server{
 		config:   config,
+		lists:    lists.Lists(conn),
 		sessions: auth.Sessions(config, conn),
 		templates: templates.New(
 			config.TemplateDir,
 			templates.Attrs{"StaticURL": config.StaticURL},
 		),
-		users: auth.Users(conn),
+		users:       auth.Users(conn),
+		attachments: attachments.Attachments(config, settings.Attachments, conn),
+		webhooks:    webhooks.Webhooks(settings.Webhooks, conn, nil),
+	}
+	schema := settings.Feeds.Schema
+	if len(schema.Fields) == 0 {
+		schema = db.DefaultSchema
 	}
+	srv.spec = describe(schema)
 
 	// Routes
 	http.HandleFunc("/", srv.RequireSession(srv.IndexHandler))
+	http.HandleFunc("/lists/", srv.RequireSession(srv.ListHandler))
+	http.HandleFunc(SpecURL, srv.SpecHandler)
+	http.HandleFunc("/digests/", srv.RequireSession(srv.DigestsHandler))
+	http.HandleFunc("/digests/verify", srv.VerifyHandler)
+	http.HandleFunc("/digests/unsubscribe", srv.UnsubscribeHandler)
+
+	// The API only accepts existing sessions, which its handlers check
+	http.HandleFunc("/api/v1/things", srv.ThingsHandler)
+	http.HandleFunc("/api/v1/things/", srv.ThingsHandler)
+	http.HandleFunc("/api/v1/search", srv.SearchHandler)
+	http.HandleFunc("/api/v1/attachments", srv.AttachmentsHandler)
+	http.HandleFunc("/api/v1/webhooks", srv.WebhooksHandler)
+	http.HandleFunc("/api/v1/webhooks/deliveries", srv.DeliveriesHandler)
 
 	// Feeds
-	hub := feeds.NewHub(config, conn, srv.sessions)
-	http.Handle("/feeds/v1/things", websocket.Handler(hub.Handler))
+	broadcaster, err := feeds.NewBroadcaster(settings.Feeds, config.Database)
+	if err != nil {
+		log.Panicf("server: could not create broadcaster: %s", err)
+	}
+	srv.searcher = feeds.NewSearcher(config.Database, conn, broadcaster)
+	mailer := mail.New(config.SMTP)
+	srv.digests = digests.Digests(config, conn, mailer, srv.templates)
+	go srv.digests.Run(settings.Digests.Interval)
+	srv.hub = feeds.NewHub(config, settings.Feeds, conn, srv.sessions, srv.lists, broadcaster, srv.searcher, mailer, srv.webhooks)
+	go srv.webhooks.Run()
+	http.Handle("/feeds/v1/things", websocket.Handler(srv.hub.Handler))
+	http.Handle("/feeds/v1/lists/", websocket.Handler(srv.hub.ListHandler))
+
+	// Attached files are only served to users with a session
+	if srv.attachments.Enabled() {
+		http.HandleFunc(config.MediaURL, srv.MediaHandler)
+	}
 
 	// Static Files
 	http.Handle(
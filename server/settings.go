@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aodin/listofthings/server/attachments"
+	"github.com/aodin/listofthings/server/digests"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/webhooks"
+)
+
+// Settings are the listofthings specific settings. They are parsed from the
+// same configuration file as volta's config.Config.
+type Settings struct {
+	Feeds       feeds.Settings       `json:"feeds"`
+	Attachments attachments.Settings `json:"attachments"`
+	Digests     digests.Settings     `json:"digests"`
+	Webhooks    webhooks.Settings    `json:"webhooks"`
+}
+
+// DefaultSettings are used for any keys missing from the configuration file
+var DefaultSettings = Settings{
+	Feeds:       feeds.DefaultSettings,
+	Attachments: attachments.DefaultSettings,
+	Digests:     digests.DefaultSettings,
+	Webhooks:    webhooks.DefaultSettings,
+}
+
+// ParseSettings will create Settings using the file at the given path.
+func ParseSettings(filename string) (Settings, error) {
+	settings := DefaultSettings
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return settings, err
+	}
+	if contents, err = parseDurations(contents); err != nil {
+		return settings, err
+	}
+	if err = json.Unmarshal(contents, &settings); err != nil {
+		return settings, err
+	}
+	if len(settings.Feeds.Schema.Fields) > 0 {
+		err = settings.Feeds.Schema.Error()
+	}
+	return settings, err
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseDurations replaces the durations of the settings, such as "30s",
+// with the nanoseconds that a time.Duration is decoded from. Durations must
+// be strings, since a bare number would be read as nanoseconds, except for
+// zero, which disables some settings.
+func parseDurations(contents []byte) ([]byte, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return nil, err
+	}
+	if err := replaceDurations(raw, reflect.TypeOf(Settings{}), ""); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func replaceDurations(raw map[string]interface{}, t reflect.Type, prefix string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		value, exists := raw[name]
+		if name == "" || !exists {
+			continue
+		}
+		switch {
+		case field.Type == durationType:
+			if value == float64(0) {
+				continue
+			}
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s%s must be a duration such as \"30s\"", prefix, name)
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s%s is not a valid duration: %s", prefix, name, s)
+			}
+			raw[name] = int64(d)
+		case field.Type.Kind() == reflect.Struct:
+			if nested, ok := value.(map[string]interface{}); ok {
+				if err := replaceDurations(nested, field.Type, prefix+name+"."); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSettingsDurations(t *testing.T) {
+	file, err := ioutil.TempFile("", "settings")
+	if err != nil {
+		t.Fatalf("could not create a settings file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	parse := func(contents string) (Settings, error) {
+		if err := ioutil.WriteFile(file.Name(), []byte(contents), 0600); err != nil {
+			t.Fatalf("could not write the settings file: %s", err)
+		}
+		return ParseSettings(file.Name())
+	}
+
+	settings, err := parse(`{
+		"feeds": {"ping_interval": "45s", "idle_timeout": 0, "event_retention": "36h"},
+		"webhooks": {"backoff": "1m30s"}
+	}`)
+	if err != nil {
+		t.Fatalf("could not parse the settings: %s", err)
+	}
+	if settings.Feeds.PingInterval != 45*time.Second || settings.Feeds.EventRetention != 36*time.Hour {
+		t.Errorf("unexpected feeds settings %+v", settings.Feeds)
+	}
+	if settings.Feeds.ReadTimeout != DefaultSettings.Feeds.ReadTimeout {
+		t.Errorf("a missing duration was not the default: %s", settings.Feeds.ReadTimeout)
+	}
+	if settings.Webhooks.Backoff != 90*time.Second {
+		t.Errorf("unexpected webhook backoff %s", settings.Webhooks.Backoff)
+	}
+
+	// Bare numbers would be read as nanoseconds
+	for _, contents := range []string{
+		`{"feeds": {"ping_interval": 30}}`,
+		`{"digests": {"interval": "daily"}}`,
+	} {
+		if _, err := parse(contents); err == nil {
+			t.Errorf("invalid durations were parsed: %s", contents)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/spec"
+	"github.com/aodin/listofthings/server/webhooks"
+)
+
+// SpecURL is where the description of the API and feeds is served
+const SpecURL = "/api/v1/openapi.json"
+
+// describe creates the description of the REST API and the websocket
+// feeds from the types they send and receive. The content fields of things
+// are those of the schema.
+func describe(schema db.Schema) spec.Document {
+	c := &spec.Components{}
+	failed := spec.Response{
+		Description: "An error with its message",
+		Content:     spec.JSON(c.Of(feeds.ErrorContent{})),
+	}
+	ok := func(description string, v interface{}) map[string]spec.Response {
+		return map[string]spec.Response{
+			"200":     {Description: description, Content: spec.JSON(c.Of(v))},
+			"default": failed,
+		}
+	}
+	created := func(description string, v interface{}) map[string]spec.Response {
+		return map[string]spec.Response{
+			"201":     {Description: description, Content: spec.JSON(c.Of(v))},
+			"default": failed,
+		}
+	}
+	body := func(v interface{}) *spec.Body {
+		return &spec.Body{Required: true, Content: spec.JSON(c.Of(v))}
+	}
+	list := spec.Query("list", "integer")
+	id := spec.Parameter{
+		Name: "id", In: "path", Required: true, Schema: &spec.Schema{Type: "integer"},
+	}
+	required := func(p spec.Parameter) spec.Parameter {
+		p.Required = true
+		return p
+	}
+
+	// Updates reply with the current thing if they conflict
+	update := ok("The updated thing", db.Thing{})
+	update["409"] = spec.Response{
+		Description: "The thing was changed since the given version",
+		Content:     spec.JSON(c.Of(ConflictContent{})),
+	}
+
+	remove := ok("The deleted thing", db.Thing{})
+	remove["409"] = update["409"]
+	ifMatch := spec.Parameter{Name: "If-Match", In: "header", Schema: &spec.Schema{Type: "string"}}
+
+	doc := spec.Document{
+		OpenAPI: "3.0.0",
+		Info:    spec.Info{Title: "List of Things", Version: "v1"},
+		Paths: map[string]spec.Path{
+			"/api/v1/things": {
+				"get": {
+					Summary: "List a page of the things of a list, nested under their parents",
+					Parameters: []spec.Parameter{
+						list, spec.Query("filter", "string"), spec.Query("cursor", "string"),
+					},
+					Responses: ok("A page of things", ThingsPage{}),
+				},
+				"post": {
+					Summary:     "Create a thing",
+					Parameters:  []spec.Parameter{list},
+					RequestBody: body(db.Thing{}),
+					Responses:   created("The created thing", db.Thing{}),
+				},
+			},
+			"/api/v1/things/{id}": {
+				"get": {
+					Summary:    "Get a thing",
+					Parameters: []spec.Parameter{id, list},
+					Responses:  ok("The thing", db.Thing{}),
+				},
+				"put": {
+					Summary:     "Update the content of a thing at the given version",
+					Parameters:  []spec.Parameter{id, list},
+					RequestBody: body(db.Thing{}),
+					Responses:   update,
+				},
+				"delete": {
+					Summary:    "Move a thing and its sub-items to the trash at the version of the parameter or If-Match",
+					Parameters: []spec.Parameter{id, list, spec.Query("version", "integer"), ifMatch},
+					Responses:  remove,
+				},
+			},
+			"/api/v1/search": {
+				"get": {
+					Summary: "Search the things of a list, best match first",
+					Parameters: []spec.Parameter{
+						list, spec.Query("q", "string"), spec.Query("limit", "integer"),
+					},
+					Responses: ok("The matching things", []feeds.Result{}),
+				},
+			},
+			"/api/v1/attachments": {
+				"get": {
+					Summary:    "List the attachments of a thing",
+					Parameters: []spec.Parameter{required(spec.Query("thing", "integer"))},
+					Responses:  ok("The attachments", []db.Attachment{}),
+				},
+				"post": {
+					Summary: "Attach a file to a thing",
+					RequestBody: &spec.Body{
+						Required: true,
+						Content: map[string]spec.Media{"multipart/form-data": {
+							Schema: &spec.Schema{Type: "object", Properties: map[string]*spec.Schema{
+								"thing": {Type: "integer"},
+								"file":  {Type: "string", Format: "binary"},
+							}},
+						}},
+					},
+					Responses: created("The attachment", db.Attachment{}),
+				},
+				"delete": {
+					Summary:    "Remove an attachment, which only its uploader can do",
+					Parameters: []spec.Parameter{required(spec.Query("id", "integer"))},
+					Responses:  ok("The removed attachment", db.Attachment{}),
+				},
+			},
+			"/api/v1/webhooks": {
+				"get": {
+					Summary:    "List the webhooks of a list, without their secrets",
+					Parameters: []spec.Parameter{required(list)},
+					Responses:  ok("The webhooks", []db.Webhook{}),
+				},
+				"post": {
+					Summary:     "Create a webhook, generating its secret if none is given",
+					RequestBody: body(db.Webhook{}),
+					Responses:   created("The webhook with its secret", db.Webhook{}),
+				},
+				"delete": {
+					Summary:    "Remove a webhook",
+					Parameters: []spec.Parameter{required(spec.Query("id", "integer"))},
+					Responses:  ok("The removed webhook", db.Webhook{}),
+				},
+			},
+			"/api/v1/webhooks/deliveries": {
+				"get": {
+					Summary: "List the recent deliveries of a webhook with their attempts",
+					Parameters: []spec.Parameter{
+						required(spec.Query("webhook", "integer")), spec.Query("state", "string"),
+					},
+					Responses: ok("The deliveries", []webhooks.Log{}),
+				},
+				"post": {
+					Summary:    "Retry a dead delivery",
+					Parameters: []spec.Parameter{required(spec.Query("id", "integer"))},
+					Responses: map[string]spec.Response{
+						"202":     {Description: "The queued delivery", Content: spec.JSON(c.Of(db.Delivery{}))},
+						"default": failed,
+					},
+				},
+			},
+		},
+		Feeds: spec.Feeds{
+			Paths:    []string{"/feeds/v1/things", "/feeds/v1/lists/{id}/things"},
+			Incoming: c.Of(feeds.IncomingMessage{}),
+			Outgoing: c.Of(feeds.OutgoingMessage{}),
+		},
+	}
+	for _, request := range feeds.Requests {
+		described := spec.FeedRequest{
+			Resource:  request.Resource,
+			Method:    request.Method,
+			Event:     request.Event,
+			Broadcast: request.Broadcast,
+		}
+		if request.Content != nil {
+			described.Content = c.Of(request.Content)
+		}
+		if request.Reply != nil {
+			described.Reply = c.Of(request.Reply)
+		}
+		doc.Feeds.Requests = append(doc.Feeds.Requests, described)
+	}
+	for _, notice := range feeds.Notices {
+		doc.Feeds.Notices = append(doc.Feeds.Notices, spec.FeedNotice{
+			Resource: notice.Resource,
+			Event:    notice.Event,
+			Content:  c.Of(notice.Content),
+		})
+	}
+
+	// Things are sent with their content fields alongside the system fields
+	thing := c.Schemas["Thing"]
+	for name, field := range schema.Fields {
+		thing.Properties[name] = &spec.Schema{Type: field.Type, MaxLength: field.MaxLength}
+		if field.Required {
+			thing.Required = append(thing.Required, name)
+		}
+	}
+	sort.Strings(thing.Required)
+	doc.Components = c
+	return doc
+}
+
+// SpecHandler serves the description of the API and feeds
+func (srv *Server) SpecHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "The spec must use GET",
+		})
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, srv.spec)
+}
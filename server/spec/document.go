@@ -0,0 +1,86 @@
+package spec
+
+// Document is an OpenAPI 3.0 document. The websocket feeds, which OpenAPI
+// cannot describe, are under "x-feeds".
+type Document struct {
+	OpenAPI    string          `json:"openapi"`
+	Info       Info            `json:"info"`
+	Paths      map[string]Path `json:"paths"`
+	Components *Components     `json:"components"`
+	Feeds      Feeds           `json:"x-feeds"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Path maps lowercase HTTP methods to their operations
+type Path map[string]Operation
+
+type Operation struct {
+	Summary     string              `json:"summary"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *Body               `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type Body struct {
+	Required bool             `json:"required"`
+	Content  map[string]Media `json:"content"`
+}
+
+type Response struct {
+	Description string           `json:"description"`
+	Content     map[string]Media `json:"content,omitempty"`
+}
+
+type Media struct {
+	Schema *Schema `json:"schema"`
+}
+
+// JSON returns the content of a JSON body with the given schema
+func JSON(schema *Schema) map[string]Media {
+	return map[string]Media{"application/json": {Schema: schema}}
+}
+
+// Query returns an optional query parameter
+func Query(name, kind string) Parameter {
+	return Parameter{Name: name, In: "query", Schema: &Schema{Type: kind}}
+}
+
+// Feeds describe the websocket protocol. Every message sent by clients
+// is an incoming message and every message sent by the server is an
+// outgoing message, whose content depends on its resource and method.
+type Feeds struct {
+	Paths    []string      `json:"paths"`
+	Incoming *Schema       `json:"incoming"`
+	Outgoing *Schema       `json:"outgoing"`
+	Requests []FeedRequest `json:"requests"`
+	Notices  []FeedNotice  `json:"notices"`
+}
+
+// FeedRequest is a method clients can send and the event that replies to it
+type FeedRequest struct {
+	Resource  string  `json:"resource"`
+	Method    string  `json:"method"`
+	Content   *Schema `json:"content,omitempty"`
+	Event     string  `json:"event,omitempty"`
+	Reply     *Schema `json:"reply,omitempty"`
+	Broadcast bool    `json:"broadcast"`
+}
+
+// FeedNotice is an event the server sends without a request
+type FeedNotice struct {
+	Resource string  `json:"resource"`
+	Event    string  `json:"method"`
+	Content  *Schema `json:"content"`
+}
@@ -0,0 +1,150 @@
+package spec
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON schema, as used by OpenAPI 3.0
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Components are the schemas of named Go structs, which other schemas
+// refer to by name
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+	types   map[string]reflect.Type
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Of returns the schema of the JSON encoding of the given value. Named
+// structs are added to the components and referenced. A nil value has an
+// empty schema, which allows anything.
+func (c *Components) Of(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return c.schema(reflect.TypeOf(v))
+}
+
+// Ref returns the reference to the named component
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (c *Components) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := c.schema(t.Elem())
+		if s.Ref != "" {
+			// References cannot have siblings in OpenAPI 3.0
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: c.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: c.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return c.object(t)
+		}
+		return Ref(c.define(t))
+	}
+	// Interfaces and anything else can be any JSON value
+	return &Schema{}
+}
+
+// define adds the named struct to the components and returns its name.
+// Structs of different packages with the same name are qualified by their
+// package.
+func (c *Components) define(t reflect.Type) string {
+	name := t.Name()
+	if existing, ok := c.types[name]; ok && existing != t {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	if _, ok := c.types[name]; ok {
+		return name
+	}
+	if c.Schemas == nil {
+		c.Schemas = make(map[string]*Schema)
+		c.types = make(map[string]reflect.Type)
+	}
+	// Set before recursing, so structs can contain themselves
+	c.types[name] = t
+	c.Schemas[name] = &Schema{}
+	*c.Schemas[name] = *c.object(t)
+	return name
+}
+
+// object returns the schema of the exported fields of a struct. Embedded
+// structs without a JSON name are flattened into it.
+func (c *Components) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for key, value := range c.object(embedded).Properties {
+					if _, exists := s.Properties[key]; !exists {
+						s.Properties[key] = value
+					}
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = c.schema(field.Type)
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type node struct {
+	ID       int64           `json:"id"`
+	Name     *string         `json:"name"`
+	Parent   *node           `json:"parent"`
+	Children []node          `json:"children"`
+	Seen     time.Time       `json:"seen"`
+	Content  json.RawMessage `json:"content"`
+	Secret   string          `json:"-"`
+	hidden   bool
+	embedded
+}
+
+type embedded struct {
+	Tags map[string]bool `json:"tags"`
+}
+
+func TestOf(t *testing.T) {
+	c := &Components{}
+	if ref := c.Of(node{}).Ref; ref != "#/components/schemas/node" {
+		t.Fatalf("unexpected reference %q", ref)
+	}
+	schema := c.Schemas["node"]
+	encoded, _ := json.Marshal(schema.Properties)
+	expected := `{` +
+		`"children":{"type":"array","items":{"$ref":"#/components/schemas/node"}},` +
+		`"content":{},` +
+		`"id":{"type":"integer","format":"int64"},` +
+		`"name":{"type":"string","nullable":true},` +
+		`"parent":{"$ref":"#/components/schemas/node"},` +
+		`"seen":{"type":"string","format":"date-time"},` +
+		`"tags":{"type":"object","additionalProperties":{"type":"boolean"}}}`
+	if string(encoded) != expected {
+		t.Errorf("unexpected properties %s", encoded)
+	}
+	if len(c.Schemas) != 1 {
+		t.Errorf("unexpected components %v", c.Schemas)
+	}
+	if any := c.Of(nil); any.Type != "" || any.Ref != "" {
+		t.Errorf("nil has the schema %+v", any)
+	}
+}
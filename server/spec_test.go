@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/spec"
+)
+
+// routes returns the patterns of the API that server.go handles
+func routes(t *testing.T) (patterns []string) {
+	file, err := parser.ParseFile(token.NewFileSet(), "server.go", nil, 0)
+	if err != nil {
+		t.Fatalf("could not parse server.go: %s", err)
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			pattern, _ := strconv.Unquote(lit.Value)
+			if strings.HasPrefix(pattern, "/api/") {
+				patterns = append(patterns, pattern)
+			}
+		}
+		return true
+	})
+	return
+}
+
+// refs returns the references made anywhere in the decoded JSON
+func refs(v interface{}) (found []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				found = append(found, ref)
+			}
+			found = append(found, refs(value)...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = append(found, refs(value)...)
+		}
+	}
+	return
+}
+
+func TestSpecHandler(t *testing.T) {
+	schema := db.Schema{Fields: map[string]db.Field{
+		"name": {Type: db.String, Required: true, MaxLength: 100},
+		"due":  {Type: db.String},
+	}}
+	srv := &Server{spec: describe(schema)}
+
+	r, _ := http.NewRequest("GET", SpecURL, nil)
+	w := httptest.NewRecorder()
+	srv.SpecHandler(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	var doc spec.Document
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("could not decode the spec: %s", err)
+	}
+	if doc.OpenAPI != "3.0.0" {
+		t.Errorf("unexpected version %q", doc.OpenAPI)
+	}
+
+	// Things have the fields of the schema
+	thing := doc.Components.Schemas["Thing"]
+	if name := thing.Properties["name"]; name == nil || name.Type != "string" || name.MaxLength != 100 {
+		t.Errorf("unexpected name field %+v", name)
+	}
+	if thing.Properties["due"] == nil || strings.Join(thing.Required, ",") != "name" {
+		t.Errorf("unexpected thing %+v", thing)
+	}
+
+	// Every route of the API is described, and every reference resolves
+	patterns := routes(t)
+	if len(patterns) == 0 {
+		t.Fatal("no routes of the API were found")
+	}
+	for _, pattern := range patterns {
+		var described bool
+		for path := range doc.Paths {
+			if path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)) {
+				described = true
+			}
+		}
+		if !described {
+			t.Errorf("the route %s is not described", pattern)
+		}
+	}
+	var decoded interface{}
+	json.Unmarshal(w.Body.Bytes(), &decoded)
+	for _, ref := range refs(decoded) {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("the reference %s does not resolve", ref)
+		}
+	}
+	if len(doc.Feeds.Requests) != len(feeds.Requests) || len(doc.Feeds.Notices) != len(feeds.Notices) {
+		t.Errorf("unexpected feeds %+v", doc.Feeds)
+	}
+
+	r, _ = http.NewRequest("POST", SpecURL, nil)
+	w = httptest.NewRecorder()
+	if srv.SpecHandler(w, r); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status %d of a POST", w.Code)
+	}
+}
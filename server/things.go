@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// ThingsPage is a page of things from the REST API, nested under their
+// parents. Pages with a cursor have more things after them.
+type ThingsPage struct {
+	Sequence int64        `json:"sequence"`
+	Cursor   string       `json:"cursor,omitempty"`
+	Things   feeds.Things `json:"things"`
+}
+
+// ConflictContent is the error of a change based on an outdated version,
+// with the current copy of the thing
+type ConflictContent struct {
+	feeds.ErrorContent
+	Current db.Thing `json:"current"`
+}
+
+// ThingsHandler serves the things of a list as JSON:
+//
+//	GET    /api/v1/things?list={id}&filter={filter}&cursor={cursor}
+//	POST   /api/v1/things?list={id}
+//	GET    /api/v1/things/{id}?list={id}
+//	PUT    /api/v1/things/{id}?list={id}
+//	DELETE /api/v1/things/{id}?list={id}&version={version}
+//
+// Without a list, the default list is used. Changes are validated by the
+// schema and broadcast through the hub like those of websockets. Updates
+// must include the version they were based on, as must deletes with either
+// the version parameter or an If-Match of the thing's ETag. Conflicts reply
+// 409 with the current thing.
+func (srv *Server) ThingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := srv.user(r)
+	if !user.Exists() {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "A session is required",
+		})
+		return
+	}
+	list, status, err := srv.requestList(r)
+	if err != nil {
+		writeJSON(w, status, feeds.ErrorContent{Message: err.Error()})
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/things"), "/")
+	if path == "" {
+		switch r.Method {
+		case "GET":
+			srv.listThings(w, r, list.ID)
+		case "POST":
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+					Message: "Invalid request body",
+				})
+				return
+			}
+			srv.commitThing(w, list.ID, user, "create", body, http.StatusCreated)
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+				Message: "Things must use GET or POST",
+			})
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+			Message: feeds.ErrNoThing.Error(),
+		})
+		return
+	}
+	thing, err := srv.hub.Thing(list.ID, id)
+	if err == nil && thing.IsDeleted() {
+		err = feeds.ErrNoThing
+	}
+	if err != nil {
+		writeThingError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, thing.Version))
+		writeJSON(w, http.StatusOK, thing)
+
+	case "PUT":
+		var content map[string]interface{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&content); err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: "Invalid JSON",
+			})
+			return
+		}
+		content["id"] = thing.ID
+		body, _ := json.Marshal(content)
+		srv.commitThing(w, list.ID, user, "update", body, http.StatusOK)
+
+	case "DELETE":
+		ref := feeds.Ref{ID: thing.ID}
+		version := r.FormValue("version")
+		if version == "" {
+			version = strings.Trim(strings.TrimPrefix(r.Header.Get("If-Match"), "W/"), `"`)
+		}
+		if version != "" {
+			if ref.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+				writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+					Message: "Invalid version",
+				})
+				return
+			}
+		}
+		body, _ := json.Marshal(ref)
+		srv.commitThing(w, list.ID, user, "delete", body, http.StatusOK)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "Things must use GET, PUT or DELETE",
+		})
+	}
+}
+
+// requestList returns the list of the request's "list" parameter, or the
+// default list without one
+func (srv *Server) requestList(r *http.Request) (db.List, int, error) {
+	list := srv.lists.Default()
+	if id := r.FormValue("list"); id != "" {
+		listID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return list, http.StatusBadRequest, fmt.Errorf("Invalid list ID")
+		}
+		list = srv.lists.Get(listID)
+	}
+	if !list.Exists() {
+		return list, http.StatusNotFound, fmt.Errorf("List does not exist")
+	}
+	return list, http.StatusOK, nil
+}
+
+// listThings writes a page of the things of the list. Pages after the
+// first are requested with the cursor of the page before.
+func (srv *Server) listThings(w http.ResponseWriter, r *http.Request, listID int64) {
+	in := feeds.IncomingMessage{Resource: "things", Event: "read"}
+	more := feeds.More{Filter: r.FormValue("filter")}
+	if more.Cursor = r.FormValue("cursor"); more.Cursor != "" {
+		in.Event = "list_more"
+	}
+	in.Content, _ = json.Marshal(more)
+	out, err := srv.hub.Query(listID, in)
+	if err != nil {
+		writeThingError(w, err)
+		return
+	}
+	things, _ := out.Content.(feeds.Things)
+	writeJSON(w, http.StatusOK, ThingsPage{
+		Sequence: out.Sequence,
+		Cursor:   out.Cursor,
+		Things:   things,
+	})
+}
+
+// commitThing applies a change to a thing through the hub and writes its
+// result
+func (srv *Server) commitThing(w http.ResponseWriter, listID int64, user db.User, event string, content []byte, status int) {
+	out, err := srv.hub.Commit(listID, user, feeds.IncomingMessage{
+		Resource: "things",
+		Event:    event,
+		Content:  content,
+	})
+	if err != nil {
+		writeThingError(w, err)
+		return
+	}
+	writeJSON(w, status, out.Content)
+}
+
+// writeThingError writes the error of a request for a thing with the
+// status that matches it. Conflicts include the current thing. Only invalid
+// requests are the fault of the client, any other error is the server's.
+func writeThingError(w http.ResponseWriter, err error) {
+	if conflict, ok := err.(feeds.ConflictError); ok {
+		writeJSON(w, http.StatusConflict, ConflictContent{
+			ErrorContent: feeds.ErrorContent{Message: err.Error()},
+			Current:      conflict.Current,
+		})
+		return
+	}
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case feeds.InvalidError, *json.SyntaxError, *json.UnmarshalTypeError:
+		status = http.StatusBadRequest
+	}
+	if err == feeds.ErrNoThing {
+		status = http.StatusNotFound
+	}
+	if status == http.StatusInternalServerError {
+		log.Printf("error: could not handle a request for things: %s", err)
+	}
+	writeJSON(w, status, feeds.ErrorContent{Message: err.Error()})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aodin/volta/config"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+	"github.com/aodin/listofthings/server/auth"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+	"github.com/aodin/listofthings/server/lists"
+)
+
+func TestThingsHandler(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+	user := dbtest.User(t, conn, "api@example.com")
+
+	conf := config.Default
+	srv := &Server{
+		config:   conf,
+		lists:    lists.Lists(conn),
+		sessions: auth.Sessions(conf, conn),
+		users:    auth.Users(conn),
+	}
+	srv.hub = feeds.NewHub(
+		conf, feeds.DefaultSettings, conn, srv.sessions, srv.lists,
+		feeds.NewLocalBroadcaster(), nil, nil, nil,
+	)
+	session := srv.sessions.Create(user)
+
+	request := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		srv.ThingsHandler(w, r)
+		return w
+	}
+	bearer := http.Header{"Authorization": {"Bearer " + session.Key}}
+	things := fmt.Sprintf("/api/v1/things?list=%d", list.ID)
+
+	// Requests without a session are refused without creating one
+	w := request("POST", things, `{"name": "Milk"}`, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status %d without a session", w.Code)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("a session was created by the API: %s", cookie)
+	}
+
+	w = request("POST", things, `{"name": "Milk"}`, bearer)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("could not create a thing: %d %s", w.Code, w.Body)
+	}
+	var thing db.Thing
+	if err := json.Unmarshal(w.Body.Bytes(), &thing); err != nil {
+		t.Fatalf("could not decode the created thing: %s", err)
+	}
+	path := fmt.Sprintf("/api/v1/things/%d?list=%d", thing.ID, list.ID)
+
+	w = request("GET", path, "", bearer)
+	if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("unexpected ETag %s", etag)
+	}
+
+	// Deletes must include the version they were based on
+	if w = request("DELETE", path, "", bearer); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status %d of a delete without a version", w.Code)
+	}
+	if w = request("DELETE", path+"&version=2", "", bearer); w.Code != http.StatusConflict {
+		t.Errorf("unexpected status %d of a stale delete", w.Code)
+	}
+	ifMatch := http.Header{
+		"Authorization": bearer["Authorization"],
+		"If-Match":      {`"1"`},
+	}
+	if w = request("DELETE", path, "", ifMatch); w.Code != http.StatusOK {
+		t.Fatalf("could not delete with If-Match: %d %s", w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &thing); err != nil || !thing.IsDeleted() {
+		t.Errorf("unexpected deleted thing %+v: %v", thing, err)
+	}
+}
+
+func TestWriteThingError(t *testing.T) {
+	var syntax error
+	if err := json.Unmarshal([]byte("{"), &struct{}{}); err != nil {
+		syntax = err
+	}
+	for _, test := range []struct {
+		err    error
+		status int
+	}{
+		{feeds.ConflictError{Version: 1, Current: db.Thing{ID: 1, Version: 2}}, http.StatusConflict},
+		{feeds.ErrNoThing, http.StatusNotFound},
+		{feeds.InvalidError{Message: "Unknown field: color"}, http.StatusBadRequest},
+		{syntax, http.StatusBadRequest},
+		{errors.New("pq: could not serialize access"), http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		writeThingError(w, test.err)
+		if w.Code != test.status {
+			t.Errorf("unexpected status %d of %q, expected %d", w.Code, test.err, test.status)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	db "github.com/aodin/listofthings/db"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// WebhooksHandler lists, creates and deletes the webhooks of lists.
+// GET /api/v1/webhooks?list={id} lists the webhooks of a list, a POST of a
+// JSON webhook creates one and DELETE /api/v1/webhooks?id={id} removes one.
+// The secret of a webhook is only returned when it is created. Only the
+// owner of a list can manage its webhooks.
+func (srv *Server) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	user := srv.user(r)
+	if !user.Exists() {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "A session is required",
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		listID, _ := strconv.ParseInt(r.FormValue("list"), 10, 64)
+		if !srv.lists.Get(listID).Exists() {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "List does not exist",
+			})
+			return
+		}
+		if !srv.isOwner(w, listID, user) {
+			return
+		}
+		webhooks := srv.webhooks.ForList(listID)
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		writeJSON(w, http.StatusOK, webhooks)
+
+	case "POST":
+		var webhook db.Webhook
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&webhook); err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: "Invalid JSON",
+			})
+			return
+		}
+		if !srv.lists.Get(webhook.ListID).Exists() {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "List does not exist",
+			})
+			return
+		}
+		if !srv.isOwner(w, webhook.ListID, user) {
+			return
+		}
+		webhook, err := srv.webhooks.Create(webhook)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusCreated, webhook)
+
+	case "DELETE":
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		webhook := srv.webhooks.Get(id)
+		if !webhook.Exists() {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "Webhook does not exist",
+			})
+			return
+		}
+		if !srv.isOwner(w, webhook.ListID, user) {
+			return
+		}
+		if err := srv.webhooks.Delete(webhook); err != nil {
+			writeJSON(w, http.StatusInternalServerError, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		webhook.Secret = ""
+		writeJSON(w, http.StatusOK, webhook)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "Webhooks must use GET, POST or DELETE",
+		})
+	}
+}
+
+// DeliveriesHandler shows the delivery log of a webhook with
+// GET /api/v1/webhooks/deliveries?webhook={id}&state={state}, where the
+// state is optional. POST /api/v1/webhooks/deliveries?id={id} redelivers a
+// dead delivery. Only the owner of the webhook's list can see or
+// redeliver its deliveries.
+func (srv *Server) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	user := srv.user(r)
+	if !user.Exists() {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "A session is required",
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		id, _ := strconv.ParseInt(r.FormValue("webhook"), 10, 64)
+		webhook := srv.webhooks.Get(id)
+		if !webhook.Exists() {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "Webhook does not exist",
+			})
+			return
+		}
+		if !srv.isOwner(w, webhook.ListID, user) {
+			return
+		}
+		logs, err := srv.webhooks.Deliveries(id, r.FormValue("state"), 100)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, logs)
+
+	case "POST":
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		delivery := srv.webhooks.Delivery(id)
+		if delivery.ID == 0 {
+			writeJSON(w, http.StatusNotFound, feeds.ErrorContent{
+				Message: "Delivery does not exist",
+			})
+			return
+		}
+		if !srv.isOwner(w, srv.webhooks.Get(delivery.WebhookID).ListID, user) {
+			return
+		}
+		if err := srv.webhooks.Redeliver(delivery); err != nil {
+			writeJSON(w, http.StatusBadRequest, feeds.ErrorContent{
+				Message: err.Error(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, srv.webhooks.Delivery(id))
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, feeds.ErrorContent{
+			Message: "Deliveries must use GET or POST",
+		})
+	}
+}
+
+// isOwner returns true if the user owns the list, otherwise it writes a
+// forbidden error
+func (srv *Server) isOwner(w http.ResponseWriter, listID int64, user db.User) bool {
+	member, err := srv.lists.Member(listID, user)
+	if err != nil {
+		log.Printf("error: could not get the membership of %s: %s", user, err)
+	}
+	if !member.Owner {
+		writeJSON(w, http.StatusForbidden, feeds.ErrorContent{
+			Message: "Only the owner of the list can manage its webhooks",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,527 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	sql "github.com/aodin/aspect"
+	pg "github.com/aodin/aspect/postgres"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/server/auth"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// Headers of every post
+const (
+	SignatureHeader = "X-Listofthings-Signature"
+	EventHeader     = "X-Listofthings-Event"
+	DeliveryHeader  = "X-Listofthings-Delivery"
+)
+
+// Events are the hub events that are posted to webhooks
+var Events = []string{feeds.CREATE, feeds.UPDATE, feeds.DELETE}
+
+// Settings configure how deliveries are posted and retried
+type Settings struct {
+	Attempts   int           `json:"attempts"`    // Attempts before a delivery is dead
+	Backoff    time.Duration `json:"backoff"`     // Wait before the first retry, doubled for each after
+	MaxBackoff time.Duration `json:"max_backoff"` // Longest wait between retries
+	Timeout    time.Duration `json:"timeout"`     // Time allowed for a single post
+	Interval   time.Duration `json:"interval"`    // Time between checks for due retries
+
+	// Allow posts to loopback, link-local and private addresses, such as
+	// for receivers on the same network. Off by default.
+	AllowPrivate bool `json:"allow_private"`
+}
+
+var DefaultSettings = Settings{
+	Attempts:   8,
+	Backoff:    10 * time.Second,
+	MaxBackoff: time.Hour,
+	Timeout:    10 * time.Second,
+	Interval:   5 * time.Second,
+}
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	ListID    int64       `json:"list_id"`
+	Resource  string      `json:"resource"`
+	Event     string      `json:"event"`
+	Sequence  int64       `json:"sequence"`
+	Content   interface{} `json:"content"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// ErrPrivateTarget is returned for webhooks whose host resolves to an
+// address that is not public
+var ErrPrivateTarget = errors.New("Webhooks cannot post to loopback, link-local or private addresses")
+
+// private are the networks that webhooks cannot post to, besides loopback,
+// link-local and unspecified addresses
+var private = func() (nets []*net.IPNet) {
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipnet)
+	}
+	return
+}()
+
+// Public returns true if webhooks can post to the address
+func Public(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, ipnet := range private {
+		if ipnet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Log is a delivery with each of its attempts, oldest first
+type Log struct {
+	db.Delivery
+	History []db.Attempt `json:"history"`
+}
+
+// Sign returns the signature of the body with the secret, as sent in the
+// signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the signature of the body is valid for the secret
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// WebhookManager saves the webhooks of lists and posts their deliveries
+type WebhookManager struct {
+	conn     sql.Connection
+	client   *http.Client
+	settings Settings
+	wake     chan struct{}
+}
+
+// Create saves a new webhook. A secret is generated if none is given.
+func (m *WebhookManager) Create(webhook db.Webhook) (db.Webhook, error) {
+	webhook.URL = strings.TrimSpace(webhook.URL)
+	webhook.Events = strings.ToUpper(strings.Replace(webhook.Events, " ", "", -1))
+	if webhook.Secret == "" {
+		webhook.Secret = auth.RandomKey()
+	}
+	if err := webhook.Error(); err != nil {
+		return webhook, err
+	}
+	u, _ := url.Parse(webhook.URL)
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, err := m.resolve(strings.Trim(host, "[]")); err != nil {
+		return webhook, err
+	}
+	for _, event := range strings.Split(webhook.Events, ",") {
+		if event != "" && !wanted(event) {
+			return webhook, fmt.Errorf("Webhooks cannot be sent %s events", event)
+		}
+	}
+	stmt := pg.Insert(db.Webhooks).Values(webhook).Returning(db.Webhooks)
+	err := m.conn.QueryOne(stmt, &webhook)
+	return webhook, err
+}
+
+// Get returns the webhook with the given ID
+func (m *WebhookManager) Get(id int64) (webhook db.Webhook) {
+	stmt := db.Webhooks.Select().Where(db.Webhooks.C["id"].Equals(id))
+	m.conn.MustQueryOne(stmt, &webhook)
+	return
+}
+
+// ForList returns the webhooks of a list, including their secrets
+func (m *WebhookManager) ForList(listID int64) []db.Webhook {
+	webhooks := []db.Webhook{}
+	stmt := db.Webhooks.Select().Where(
+		db.Webhooks.C["list_id"].Equals(listID),
+	).OrderBy(db.Webhooks.C["id"])
+	m.conn.MustQueryAll(stmt, &webhooks)
+	return webhooks
+}
+
+// Delete removes the webhook and its deliveries
+func (m *WebhookManager) Delete(webhook db.Webhook) error {
+	stmt := db.Webhooks.Delete().Where(db.Webhooks.C["id"].Equals(webhook.ID))
+	_, err := m.conn.Execute(stmt)
+	return err
+}
+
+// resolve returns the addresses of the host, or ErrPrivateTarget if any of
+// them is not public and the settings do not allow it
+func (m *WebhookManager) resolve(host string) ([]net.IP, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("Could not resolve the webhook host %s", host)
+	}
+	if !m.settings.AllowPrivate {
+		for _, ip := range ips {
+			if !Public(ip) {
+				return nil, ErrPrivateTarget
+			}
+		}
+	}
+	return ips, nil
+}
+
+// dial connects to the address only if its host resolves to public
+// addresses, so that redirects and changed DNS records cannot reach
+// private ones after the webhook was created
+func (m *WebhookManager) dial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := m.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: m.settings.Timeout}
+	return dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+}
+
+// Deliveries returns the most recent deliveries of the webhook with their
+// attempts, newest first. An empty state returns deliveries of any state.
+func (m *WebhookManager) Deliveries(webhookID int64, state string, limit int) ([]Log, error) {
+	var deliveries []db.Delivery
+	stmt := db.Deliveries.Select().Where(
+		db.Deliveries.C["webhook_id"].Equals(webhookID),
+	).OrderBy(db.Deliveries.C["id"].Desc()).Limit(limit)
+	if state != "" {
+		stmt = stmt.Where(
+			db.Deliveries.C["webhook_id"].Equals(webhookID),
+			db.Deliveries.C["state"].Equals(state),
+		)
+	}
+	if err := m.conn.QueryAll(stmt, &deliveries); err != nil {
+		return nil, err
+	}
+	logs := make([]Log, len(deliveries))
+	for i, delivery := range deliveries {
+		logs[i] = Log{Delivery: delivery, History: []db.Attempt{}}
+		stmt := db.Attempts.Select().Where(
+			db.Attempts.C["delivery_id"].Equals(delivery.ID),
+		).OrderBy(db.Attempts.C["id"])
+		if err := m.conn.QueryAll(stmt, &logs[i].History); err != nil {
+			return nil, err
+		}
+	}
+	return logs, nil
+}
+
+// Delivery returns the delivery with the given ID
+func (m *WebhookManager) Delivery(id int64) (delivery db.Delivery) {
+	stmt := db.Deliveries.Select().Where(db.Deliveries.C["id"].Equals(id))
+	m.conn.MustQueryOne(stmt, &delivery)
+	return
+}
+
+// Redeliver queues a dead delivery to be attempted again
+func (m *WebhookManager) Redeliver(delivery db.Delivery) error {
+	if delivery.State != db.Dead {
+		return fmt.Errorf("Only dead deliveries can be redelivered")
+	}
+	stmt := db.Deliveries.Update().Values(sql.Values{
+		"state":    db.Pending,
+		"attempts": 0,
+		"next_at":  time.Now().UTC(),
+	}).Where(db.Deliveries.C["id"].Equals(delivery.ID))
+	if _, err := m.conn.Execute(stmt); err != nil {
+		return err
+	}
+	m.Wake()
+	return nil
+}
+
+// wanted returns true if the event is posted to webhooks
+func wanted(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues a delivery of the message to every webhook of the list
+// that wants its event. It is called within the transaction of the change,
+// so deliveries are only queued for committed changes. It implements
+// feeds.Notifier.
+func (m *WebhookManager) Notify(tx sql.Connection, listID int64, out feeds.OutgoingMessage) error {
+	if !wanted(out.Event) {
+		return nil
+	}
+	var all, webhooks []db.Webhook
+	stmt := db.Webhooks.Select().Where(
+		db.Webhooks.C["list_id"].Equals(listID),
+	).OrderBy(db.Webhooks.C["id"])
+	if err := tx.QueryAll(stmt, &all); err != nil {
+		return err
+	}
+	for _, webhook := range all {
+		if webhook.Wants(out.Event) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(Payload{
+		ListID:    listID,
+		Resource:  out.Resource,
+		Event:     out.Event,
+		Sequence:  out.Sequence,
+		Content:   out.Content,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode webhook payload of %s: %s", out, err)
+	}
+	for _, webhook := range webhooks {
+		stmt := db.Deliveries.Insert().Values(db.Delivery{
+			WebhookID: webhook.ID,
+			Event:     out.Event,
+			Payload:   string(payload),
+			State:     db.Pending,
+			NextAt:    now,
+			CreatedAt: now,
+		})
+		if _, err := tx.Execute(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wake posts queued deliveries without waiting for the next check. It
+// implements feeds.Notifier.
+func (m *WebhookManager) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (m *WebhookManager) backoff(attempts int64) time.Duration {
+	wait := m.settings.Backoff
+	for i := int64(1); i < attempts && wait < m.settings.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > m.settings.MaxBackoff {
+		wait = m.settings.MaxBackoff
+	}
+	return wait
+}
+
+// claim delays the next attempt of the delivery while it is posted. It
+// returns false if another instance already claimed it.
+func (m *WebhookManager) claim(delivery db.Delivery, now time.Time) (bool, error) {
+	stmt := db.Deliveries.Update().Values(sql.Values{
+		"next_at": now.Add(2 * m.settings.Timeout),
+	}).Where(
+		db.Deliveries.C["id"].Equals(delivery.ID),
+		db.Deliveries.C["state"].Equals(db.Pending),
+		db.Deliveries.C["next_at"].LTE(now),
+	)
+	result, err := m.conn.Execute(stmt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeliverDue posts every pending delivery whose next attempt is due
+func (m *WebhookManager) DeliverDue(now time.Time) {
+	var deliveries []db.Delivery
+	stmt := db.Deliveries.Select().Where(
+		db.Deliveries.C["state"].Equals(db.Pending),
+		db.Deliveries.C["next_at"].LTE(now),
+	).OrderBy(db.Deliveries.C["id"]).Limit(100)
+	if err := m.conn.QueryAll(stmt, &deliveries); err != nil {
+		log.Printf("error: could not get due deliveries: %s", err)
+		return
+	}
+
+	// A slow receiver should not hold up the others
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		claimed, err := m.claim(delivery, now)
+		if err != nil {
+			log.Printf("error: could not claim delivery %d: %s", delivery.ID, err)
+		}
+		if !claimed {
+			continue
+		}
+		wg.Add(1)
+		go func(delivery db.Delivery) {
+			defer wg.Done()
+			if err := m.deliver(delivery); err != nil {
+				log.Printf("error: could not record delivery %d: %s", delivery.ID, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// deliver posts the delivery once and records the attempt. Deliveries are
+// dead once they have failed every attempt.
+func (m *WebhookManager) deliver(delivery db.Delivery) error {
+	webhook := m.Get(delivery.WebhookID)
+	if !webhook.Exists() {
+		return nil // Deleted along with its deliveries
+	}
+	attempt := m.post(webhook, delivery)
+	stmt := db.Attempts.Insert().Values(attempt)
+	if _, err := m.conn.Execute(stmt); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	attempts := delivery.Attempts + 1
+	values := sql.Values{"attempts": attempts}
+	if attempt.Error == "" {
+		values["state"] = db.Delivered
+		values["delivered_at"] = now
+	} else if attempts >= int64(m.settings.Attempts) {
+		log.Printf("error: delivery %d to webhook %d is dead: %s", delivery.ID, webhook.ID, attempt.Error)
+		values["state"] = db.Dead
+	} else {
+		values["next_at"] = now.Add(m.backoff(attempts))
+	}
+	update := db.Deliveries.Update().Values(values).Where(
+		db.Deliveries.C["id"].Equals(delivery.ID),
+	)
+	_, err := m.conn.Execute(update)
+	return err
+}
+
+// post sends the signed payload of the delivery. Any response other than
+// 2xx is a failure.
+func (m *WebhookManager) post(webhook db.Webhook, delivery db.Delivery) (attempt db.Attempt) {
+	attempt.DeliveryID = delivery.ID
+	start := time.Now()
+	defer func() {
+		attempt.Duration = int64(time.Since(start) / time.Millisecond)
+	}()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = "Invalid webhook URL"
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listofthings-webhooks")
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(delivery.ID))
+
+	// Only the status is recorded, since the delivery log is shown to
+	// users and the receiver may not be theirs
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("error: could not post delivery %d to webhook %d: %s", delivery.ID, webhook.ID, err)
+		attempt.Error = "Could not reach the receiver"
+		if uerr, ok := err.(*url.Error); ok && uerr.Err == ErrPrivateTarget {
+			attempt.Error = uerr.Err.Error()
+		}
+		return attempt
+	}
+	// Drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+
+	attempt.Status = int64(resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("Receiver responded %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// Run posts deliveries as they are queued and retries those that are due
+func (m *WebhookManager) Run() {
+	ticker := time.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.wake:
+		}
+		m.DeliverDue(time.Now().UTC())
+	}
+}
+
+// Webhooks creates a new webhook manager. The client is used for every
+// post and defaults to one with the timeout of the settings that only
+// dials public addresses.
+func Webhooks(settings Settings, conn sql.Connection, client *http.Client) *WebhookManager {
+	if settings.Attempts < 1 {
+		settings.Attempts = DefaultSettings.Attempts
+	}
+	if settings.Backoff <= 0 {
+		settings.Backoff = DefaultSettings.Backoff
+	}
+	if settings.MaxBackoff <= 0 {
+		settings.MaxBackoff = DefaultSettings.MaxBackoff
+	}
+	if settings.MaxBackoff < settings.Backoff {
+		settings.MaxBackoff = settings.Backoff
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = DefaultSettings.Timeout
+	}
+	if settings.Interval <= 0 {
+		settings.Interval = DefaultSettings.Interval
+	}
+	m := &WebhookManager{
+		conn:     conn,
+		client:   client,
+		settings: settings,
+		wake:     make(chan struct{}, 1),
+	}
+	if m.client == nil {
+		m.client = &http.Client{
+			Timeout:   settings.Timeout,
+			Transport: &http.Transport{Dial: m.dial},
+		}
+	}
+	return m
+}
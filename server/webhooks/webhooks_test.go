@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/aodin/listofthings/db"
+	"github.com/aodin/listofthings/db/dbtest"
+	feeds "github.com/aodin/listofthings/server/feeds/v1"
+)
+
+// receiver records the posts of webhooks and replies with the next of its
+// statuses
+type receiver struct {
+	sync.Mutex
+	secret   string
+	statuses []int
+	posts    int
+	invalid  int // Posts without a valid signature
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.Lock()
+	defer rec.Unlock()
+	if !Verify(rec.secret, body, r.Header.Get(SignatureHeader)) {
+		rec.invalid += 1
+	}
+	status := http.StatusOK
+	if rec.posts < len(rec.statuses) {
+		status = rec.statuses[rec.posts]
+	}
+	rec.posts += 1
+	w.WriteHeader(status)
+}
+
+func (rec *receiver) count() (posts, invalid int) {
+	rec.Lock()
+	defer rec.Unlock()
+	return rec.posts, rec.invalid
+}
+
+func TestPublic(t *testing.T) {
+	for _, addr := range []string{
+		"127.0.0.1", "::1", "0.0.0.0", "10.1.2.3", "172.20.0.1",
+		"192.168.1.1", "169.254.169.254", "100.64.0.1", "fe80::1", "fd00::1",
+	} {
+		if Public(net.ParseIP(addr)) {
+			t.Errorf("%s was public", addr)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "172.32.0.1", "2001:4860::8888"} {
+		if !Public(net.ParseIP(addr)) {
+			t.Errorf("%s was not public", addr)
+		}
+	}
+}
+
+func TestPrivateTargets(t *testing.T) {
+	rec := &receiver{secret: "secret"}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	m := Webhooks(DefaultSettings, nil, nil)
+	for _, url := range []string{srv.URL, "http://[::1]/hook", "http://169.254.169.254/"} {
+		if _, err := m.Create(db.Webhook{ListID: 1, URL: url}); err != ErrPrivateTarget {
+			t.Errorf("a webhook to %s was created: %v", url, err)
+		}
+	}
+
+	// Webhooks that already post to private addresses are refused on dial
+	webhook := db.Webhook{ID: 1, URL: srv.URL, Secret: rec.secret}
+	attempt := m.post(webhook, db.Delivery{ID: 1, Payload: "{}"})
+	if attempt.Error != ErrPrivateTarget.Error() {
+		t.Errorf("unexpected error of a private post: %q", attempt.Error)
+	}
+	if posts, _ := rec.count(); posts != 0 {
+		t.Errorf("the private receiver was posted %d times", posts)
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	conn := dbtest.Connect(t)
+	defer conn.Close()
+	list, remove := dbtest.List(t, conn)
+	defer remove()
+
+	rec := &receiver{secret: "secret", statuses: []int{500, 503, 404}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	settings := Settings{
+		Attempts:     3,
+		Backoff:      time.Minute,
+		MaxBackoff:   time.Hour,
+		AllowPrivate: true, // The receiver is on loopback
+	}
+	m := Webhooks(settings, conn, nil)
+	webhook, err := m.Create(db.Webhook{
+		ListID: list.ID,
+		URL:    srv.URL,
+		Secret: rec.secret,
+		Events: "create",
+	})
+	if err != nil {
+		t.Fatalf("could not create a webhook: %s", err)
+	}
+
+	out := feeds.OutgoingMessage{
+		Resource: "things",
+		Event:    feeds.CREATE,
+		Sequence: 1,
+		Content:  db.NewThing(list.ID, "Milk"),
+	}
+
+	// Nothing is queued for changes that are rolled back
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("could not begin a transaction: %s", err)
+	}
+	if err := m.Notify(tx, list.ID, out); err != nil {
+		t.Fatalf("could not queue a delivery: %s", err)
+	}
+	tx.Rollback()
+	if logs, _ := m.Deliveries(webhook.ID, "", 10); len(logs) != 0 {
+		t.Fatalf("%d deliveries were queued by a rolled back change", len(logs))
+	}
+
+	if err := m.Notify(conn, list.ID, out); err != nil {
+		t.Fatalf("could not queue a delivery: %s", err)
+	}
+	logs, err := m.Deliveries(webhook.ID, "", 10)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("unexpected deliveries %+v: %v", logs, err)
+	}
+	id := logs[0].ID
+
+	// Each failure waits twice as long as the one before it
+	start := time.Now().UTC()
+	m.DeliverDue(start)
+	delivery := m.Delivery(id)
+	if delivery.State != db.Pending || delivery.Attempts != 1 {
+		t.Errorf("unexpected delivery after one failure %+v", delivery)
+	}
+	if wait := delivery.NextAt.Sub(start); wait < time.Minute || wait > 2*time.Minute {
+		t.Errorf("unexpected wait %s after one failure", wait)
+	}
+	m.DeliverDue(start.Add(30 * time.Second))
+	if posts, _ := rec.count(); posts != 1 {
+		t.Errorf("a delivery was retried before its backoff: %d posts", posts)
+	}
+
+	m.DeliverDue(start.Add(2 * time.Minute))
+	delivery = m.Delivery(id)
+	if delivery.State != db.Pending || delivery.Attempts != 2 {
+		t.Errorf("unexpected delivery after two failures %+v", delivery)
+	}
+	if wait := delivery.NextAt.Sub(start); wait < 2*time.Minute || wait > 3*time.Minute {
+		t.Errorf("unexpected wait %s after two failures", wait)
+	}
+
+	// Deliveries are dead once every attempt has failed
+	m.DeliverDue(start.Add(time.Hour))
+	logs, err = m.Deliveries(webhook.ID, db.Dead, 10)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("unexpected dead deliveries %+v: %v", logs, err)
+	}
+	if len(logs[0].History) != 3 {
+		t.Fatalf("unexpected history %+v", logs[0].History)
+	}
+	if attempt := logs[0].History[2]; attempt.Status != 404 || attempt.Error != "Receiver responded 404" {
+		t.Errorf("unexpected last attempt %+v", attempt)
+	}
+	m.DeliverDue(start.Add(2 * time.Hour))
+	if posts, _ := rec.count(); posts != 3 {
+		t.Errorf("a dead delivery was retried: %d posts", posts)
+	}
+
+	// Redelivered deliveries are attempted again
+	if err := m.Redeliver(m.Delivery(id)); err != nil {
+		t.Fatalf("could not redeliver: %s", err)
+	}
+	m.DeliverDue(time.Now().UTC().Add(time.Second))
+	if delivery = m.Delivery(id); delivery.State != db.Delivered {
+		t.Errorf("unexpected redelivered delivery %+v", delivery)
+	}
+	if posts, invalid := rec.count(); posts != 4 || invalid != 0 {
+		t.Errorf("%d posts had invalid signatures of %d", invalid, posts)
+	}
+}
+
+func TestPostSignsPayload(t *testing.T) {
+	rec := &receiver{secret: "secret", statuses: []int{http.StatusTeapot}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	settings := DefaultSettings
+	settings.AllowPrivate = true
+	m := Webhooks(settings, nil, nil)
+	webhook := db.Webhook{ID: 1, URL: srv.URL, Secret: rec.secret}
+	delivery := db.Delivery{ID: 1, Event: feeds.CREATE, Payload: `{"list_id":1}`}
+
+	attempt := m.post(webhook, delivery)
+	if attempt.Status != http.StatusTeapot || attempt.Error != "Receiver responded 418" {
+		t.Errorf("unexpected failed attempt %+v", attempt)
+	}
+	if attempt = m.post(webhook, delivery); attempt.Status != 200 || attempt.Error != "" {
+		t.Errorf("unexpected attempt %+v", attempt)
+	}
+	if posts, invalid := rec.count(); posts != 2 || invalid != 0 {
+		t.Errorf("%d posts had invalid signatures of %d", invalid, posts)
+	}
+
+	// Receivers with another secret reject the signature
+	rec.Lock()
+	rec.secret = "other"
+	rec.Unlock()
+	m.post(webhook, delivery)
+	if _, invalid := rec.count(); invalid != 1 {
+		t.Error("a post was valid for another secret")
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"list_id":1}`)
+	signature := "sha256=7a184f6248ad0fda85d45e7d5727cc7f92838d22022bf6331a57c5031404843a"
+	if signed := Sign("secret", body); signed != signature {
+		t.Errorf("unexpected signature %s", signed)
+	}
+	if !Verify("secret", body, signature) {
+		t.Error("a valid signature was not verified")
+	}
+	for _, invalid := range []string{"", signature[7:], "sha256=" + strings.Repeat("0", 64)} {
+		if Verify("secret", body, invalid) {
+			t.Errorf("the signature %q was verified", invalid)
+		}
+	}
+	if Verify("other", body, signature) || Verify("secret", []byte(`{"list_id":2}`), signature) {
+		t.Error("a signature was verified for another secret or body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	settings := Settings{Backoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	m := Webhooks(settings, nil, nil)
+	expected := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	}
+	for i, wait := range expected {
+		if backoff := m.backoff(int64(i + 1)); backoff != wait {
+			t.Errorf("unexpected backoff %s after %d attempts, expected %s", backoff, i+1, wait)
+		}
+	}
+}
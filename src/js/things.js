@@ -15,7 +15,21 @@ var ListOfThings = function() {
     '#d6d67e', // Ugly yellow
   ];
 
-  var WEBSOCKET_URI = 'ws://' + document.URL.split('/', 3)[2] + '/feeds/v1/things';
+  var WEBSOCKET_ROOT = 'ws://' + document.URL.split('/', 3)[2] + '/feeds/v1';
+  var ATTACHMENTS_URL = '/api/v1/attachments';
+
+  // Only things with these tags are shown, e.g. /lists/1?tag=backend
+  var subscribedTags = _.compact(_.map(location.search.replace(/^\?/, '').split('&'), function(pair) {
+    var kv = pair.split('=');
+    return (kv[0] === 'tag' && kv[1]) ? decodeURIComponent(kv[1]) : null;
+  }));
+
+  // Things arrive nested under their parents, but collections are flat
+  var flatten = function(things) {
+    return _.reduce([].concat(things), function(flat, thing) {
+      return flat.concat([_.omit(thing, 'children')], flatten(thing.children || []));
+    }, []);
+  };
 
   var App = Backbone.View.extend({
     el: '#main',
@@ -23,18 +37,41 @@ var ListOfThings = function() {
       // Attach the user and thing collections
       this.users = options.users;
       this.things = options.things;
+      this.trash = options.trash;
+      this.comments = options.comments;
+      this.attachments = options.attachments;
 
       // Create views that listen
       // TODO pass the errors handler to each list
       new UserList({collection: this.users});
       new ThingsList({collection: this.things});
+      new TrashList({collection: this.trash});
+      new SearchView({collection: this.things});
+      new CommentList({collection: this.comments, things: this.things});
+      new AttachmentList({collection: this.attachments, things: this.things});
+      new HistoryView({collection: this.things});
 
       // Cache DOM elements
-      this.$errors = $('errors');
+      this.$errors = $('#errors');
 
-      // Create a new websocket
-      this.ws = new WebSocket(WEBSOCKET_URI);
-      // this.ws.onopen = this.join.bind(this);
+      // Requests awaiting a reply from the server, keyed by request id
+      this.pending = {};
+
+      // The sequence of the last list event seen, which lets a reconnecting
+      // client receive only the events it missed
+      this.sequence = 0;
+      this.retryDelay = 1000;
+
+      this.connect();
+    },
+    connect: function() {
+      // Create a new websocket for the list on this page
+      var uri = WEBSOCKET_ROOT + '/lists/' + this.$el.data('list') + '/things';
+      uri += '?filter=' + this.things.completion;
+      _.each(this.things.tags, function(tag) {uri += '&tag=' + encodeURIComponent(tag);});
+      if (this.sequence) {uri += '&since=' + this.sequence;}
+      this.ws = new WebSocket(uri);
+      this.ws.onopen = this.join.bind(this);
       this.ws.onmessage = this.onMessage.bind(this);
       this.ws.onerror = this.onError.bind(this);
       this.ws.onclose = this.leave.bind(this);
@@ -42,43 +79,192 @@ var ListOfThings = function() {
     onMessage: function(msg) {
       // Translate the message as JSON
       var payload = JSON.parse(msg.data);
+      var isPage = payload.resource === 'things' && (payload.method === 'LIST' || payload.method === 'LIST_MORE');
+
+      // Only the LIST sent on joining marks the events this client has seen
+      if (payload.sequence > this.sequence && !(isPage && payload.request_id)) {
+        this.sequence = payload.sequence;
+      }
+
+      // Reply to heartbeats so the server knows this client is still here
+      if (payload.resource === 'connection') {
+        if (payload.method === 'PING') {
+          this.ws.send(JSON.stringify({resource: 'connection', method: 'pong'}));
+        }
+        return;
+      }
+
+      if (isPage) {
+        this.applyPage(payload);
+      } else if (payload.resource === 'things') {
+        this.applyTrash(payload.method, payload.content);
+        this.track(payload);
+      } else if (payload.resource === 'comments') {
+        this.applyComment(payload.method, payload.content);
+      } else if (payload.resource === 'attachments') {
+        this.applyThread(this.attachments, payload.method, payload.content);
+      }
+
+      // Replies to this client's own requests resolve the pending request
+      if (payload.request_id && this.pending[payload.request_id]) {
+        this.resolve(payload);
+        return;
+      }
+      // Comments and attachments were applied to their open thread above
+      if (payload.resource === 'comments' || payload.resource === 'attachments') {return;}
 
       // TODO common/whitelist store of resources
       this.handleEvent(this[payload.resource], payload.method, payload.content);
     },
+    applyPage: function(payload) {
+      this.things.cursor = payload.cursor || null;
+      this.things.trigger('cursor', this.things.cursor);
+      if (payload.method === 'LIST') {
+        this.things.applied = {};
+        return;
+      }
+      // Pages are read after their sequence, so things changed by a later
+      // event already have a newer copy than the page, or were deleted
+      var applied = this.things.applied;
+      this.things.add(_.filter(flatten(payload.content), function(thing) {
+        return !(applied[thing.id] > payload.sequence);
+      }), {merge: true});
+    },
+    track: function(payload) {
+      // Remember the sequence of the last event applied to each thing
+      if (!payload.sequence) {return;}
+      _.each(flatten(payload.content), function(thing) {
+        if (thing && thing.id) {this.things.applied[thing.id] = payload.sequence;}
+      }, this);
+    },
+    applyTrash: function(method, content) {
+      // Deleted things move to the trash and restored things move back
+      switch (method) {
+        case 'DELETE':
+        case 'CLEAR':
+          // Sub-items are deleted and restored with their parent
+          this.things.remove(flatten(content));
+          if (this.trash.loaded) {this.trash.add(flatten(content), {merge: true});}
+          break;
+        case 'RESTORE':
+          this.trash.remove(flatten(content));
+          this.things.add(flatten(content), {merge: true});
+          break;
+      }
+    },
+    applyComment: function(method, content) {
+      // Count the comments of each thing and update the open thread
+      var thing = this.things.get(content.thing_id);
+      switch (method) {
+        case 'CREATE':
+          if (thing) {thing.set('comment_count', (thing.get('comment_count') || 0) + 1);}
+          break;
+        case 'DELETE':
+          if (thing) {thing.set('comment_count', Math.max((thing.get('comment_count') || 0) - 1, 0));}
+          break;
+        default:
+          if (method !== 'UPDATE') {return;}
+      }
+      this.applyThread(this.comments, method, content);
+    },
+    applyThread: function(collection, method, content) {
+      // Only the comments and attachments of the open thing are kept
+      if (content.thing_id === collection.thingID) {
+        this.handleEvent(collection, method, content);
+      }
+    },
+    resolve: function(payload) {
+      var request = this.pending[payload.request_id];
+      var options = request.options;
+      delete this.pending[payload.request_id];
+
+      if (payload.method === 'ERROR') {
+        this.$errors.prepend(new Error({message: payload.content.message}).el);
+        if (options.error) {options.error(payload.content);}
+        return;
+      }
+      if (payload.method === 'CONFLICT') {
+        // Someone else changed the thing first: show their version
+        this.$errors.prepend(new Error({message: 'This was changed by someone else'}).el);
+        if (options.error) {options.error(payload.content);}
+        request.model.set(payload.content);
+        return;
+      }
+      if (options.success) {options.success(payload.content);}
+    },
     handleEvent: function(collection, method, content) {
       console.log('handling:', collection, method, content); 
       switch (method) {
         case 'LIST':
-          collection.reset(content);
+          collection.reset(flatten(content));
           break;
         case 'CREATE':
-          collection.add(content);
+        case 'CONNECT':
+          collection.add(content, {merge: true});
           break;
         case 'DELETE':
+        case 'DISCONNECT':
           collection.remove(content);
           break;
         case 'UPDATE':
           collection.add(content, {merge: true});
           break;
+        case 'MOVE':
+          collection.add(content, {merge: true});
+          collection.sort();
+          break;
+        case 'REMINDER':
+          // Reminders stay until the next reminder timeout
+          this.$errors.prepend(new Error({message: 'Due: ' + _.escape(content.name), timeout: 60000}).el);
+          break;
+        case 'REPARENT':
+        case 'SCHEDULE':
+        case 'COLLAPSE':
+        case 'TOGGLE':
+        case 'TAG':
+        case 'UNTAG':
+          collection.add(content, {merge: true});
+          break;
       }
     },
     onError: function() {},
-    leave: function() {},
-    sync: function(method, model) {
+    join: function() {
+      this.retryDelay = 1000;
+    },
+    leave: function() {
+      // Requests without a reply will never get one
+      _.each(this.pending, function(request) {
+        if (request.options.error) {request.options.error({message: 'Connection lost'});}
+      });
+      this.pending = {};
+
+      // Reconnect with an increasing delay
+      setTimeout(this.connect.bind(this), this.retryDelay);
+      this.retryDelay = Math.min(this.retryDelay * 2, 30000);
+    },
+    sync: function(method, model, options) {
       // Check ready state
       if (this.ws.readyState !== 1) {
         // Return after displaying an error
-        this.$error.prepend(new Error({message: 'Could not connect to server'}).el);
+        this.$errors.prepend(new Error({message: 'Could not connect to server'}).el);
+        if (options.error) {options.error({message: 'Could not connect to server'});}
         return;
       }
 
+      // Tag the message with a request id so the reply can be matched
+      var requestID = _.uniqueId('request');
+      this.pending[requestID] = {model: model, options: options};
+
+      // Collections are sent as the resource of their url
+      var resource = options.resource || (model.collection ? model.collection.url : _.result(model, 'url'));
+
       // TODO translate the messages here?
-      console.log('sending:', model.collection.url, method);
+      console.log('sending:', resource, method);
       var msg = {
-        resource: model.collection.url,
+        resource: resource,
         method: method,
-        content: model.toJSON()
+        request_id: requestID,
+        content: options.content || ((method === 'read') ? null : model.toJSON())
       };
       this.ws.send(JSON.stringify(msg));
     }
@@ -88,7 +274,7 @@ var ListOfThings = function() {
   module.onready = function() {
     // App needs to know both users and the collection because all socket
     // messages go through it
-    var app = new App({things: new Things(), users: new Users()});
+    var app = new App({things: new Things(), trash: new Trash(), users: new Users(), comments: new Comments(), attachments: new Attachments()});
 
     // Bind to the app's sync
     Backbone.sync = app.sync.bind(app);
@@ -119,10 +305,35 @@ var ListOfThings = function() {
     events: {
       'keyup #create-name': 'proxyEnter',
       'click #create': 'createItem',
+      'click #filters [data-filter]': 'setFilter',
+      'click #filters .clear': 'clearCompleted',
+      'click #more': 'loadMore',
     },
     initialize: function() {
-      this.listenTo(this.collection, 'reset', this.render);
-      this.listenTo(this.collection, 'add', this.renderItem);
+      this.items = [];
+      this.listenTo(this.collection, 'cursor', this.toggleMore);
+      this.listenTo(this.collection, 'reset sort add remove change:parent_id change:collapsed change:completed change:tags', this.render);
+    },
+    setFilter: function(e) {
+      // The server sends only the things matching the filter
+      var completion = $(e.currentTarget).data('filter');
+      this.$('#filters [data-filter]').removeClass('active');
+      $(e.currentTarget).addClass('active');
+      this.collection.completion = completion;
+      this.collection.fetch({content: {filter: completion}});
+    },
+    toggleMore: function(cursor) {
+      this.$('#more').toggle(!!cursor);
+    },
+    loadMore: function() {
+      // The next page is added when the server replies
+      this.collection.sync('list_more', this.collection, {
+        content: {cursor: this.collection.cursor, filter: this.collection.completion}
+      });
+    },
+    clearCompleted: function() {
+      // Cleared things are removed when the server broadcasts them
+      this.collection.sync('clear', this.collection, {content: {}});
     },
     proxyEnter: function(e) {
       if (e.keyCode === 13) {this.createItem();}
@@ -146,30 +357,52 @@ var ListOfThings = function() {
     },
     render: function() {
       var $list = this.$('ol');
-      $list.empty();
-      _.each(this.collection.models, function(m) {
-        var item = new Item({model: m});
-        $list.append(item.render().el);
-      }, this);
-      return;
-    },
-    renderItem: function(m) {
-      var $list = this.$('ol');
-      var item = new Item({model: m});
-      $list.append(item.render().el);
+      _.invoke(this.items, 'remove');
+      this.items = [];
+
+      // Things without a visible parent are at the top level
+      var visible = _.indexBy(this.collection.filter(this.collection.matches, this.collection), 'id');
+      var children = _.groupBy(visible, function(m) {
+        return visible[m.get('parent_id')] ? m.get('parent_id') : 0;
+      });
+
+      // Render depth first, skipping the sub-items of collapsed things
+      var walk = function(parentID, depth) {
+        _.each(children[parentID], function(m) {
+          var item = new Item({model: m, depth: depth, children: children[m.id]});
+          this.items.push(item);
+          $list.append(item.render().el);
+          if (!m.get('collapsed')) {walk.call(this, m.id, depth + 1);}
+        }, this);
+      };
+      walk.call(this, 0, 0);
+      return this;
     }
   });
 
   var Item = Backbone.View.extend({
     tagName: 'li',
-    template: _.template('<h3><%- name %> <span class="edit"><small>edit</small></span><span class="delete"><small>delete</small></span></h3>'),
+    template: _.template('<h3<% if (completed) { %> class="completed"<% } %>><span class="complete"><small><%= completed ? "undo" : "done" %></small></span> <% if (hasChildren) { %><span class="collapse-toggle"><small><%= collapsed ? "+" : "-" %></small></span> <% } %><%- name %> <% _.each(tags, function(tag) { %><span class="tag label label-default" data-tag="<%- tag %>"><%- tag %></span><% }); %><span class="show-comments"><small>comments (<%- comment_count || 0 %>)</small></span><% if (due_at) { %><span class="due label label-warning"><%- due_at %></span><% } %><span class="schedule"><small>due</small></span><span class="add-tag"><small>tag</small></span><span class="indent"><small>indent</small></span><span class="outdent"><small>outdent</small></span><span class="up"><small>up</small></span><span class="down"><small>down</small></span><span class="edit"><small>edit</small></span><span class="delete"><small>delete</small></span></h3>'),
     editTemplate: _.template('<div class="input-group"><input type="text" class="form-control" value="<%- name %>"><span class="input-group-btn"><button class="btn btn-default" type="button">Save</button></div>'),
     events: {
       'click .delete': 'deleteItem',
       'click .edit': 'editItem',
+      'click .up': 'moveUp',
+      'click .down': 'moveDown',
+      'click .indent': 'indent',
+      'click .outdent': 'outdent',
+      'click .collapse-toggle': 'toggleCollapsed',
+      'click .complete': 'toggleCompleted',
+      'click .add-tag': 'addTag',
+      'click .schedule': 'schedule',
+      'click .show-comments': 'showComments',
+      'click .tag': 'removeTag',
       'click button': 'saveItem',
     },
-    initialize: function() {
+    initialize: function(options) {
+      // The list decides how deep the thing is nested
+      this.depth = options.depth || 0;
+      this.hasChildren = !_.isEmpty(options.children);
       this.listenTo(this.model, 'remove', this.remove);
       this.listenTo(this.model, 'change', this.render);
     },
@@ -177,6 +410,82 @@ var ListOfThings = function() {
       // Render the edit template
       this.$el.html(this.editTemplate(this.model.toJSON()));
     },
+    siblings: function() {
+      var parentID = this.model.get('parent_id') || 0;
+      return this.model.collection.filter(function(m) {
+        return (m.get('parent_id') || 0) === parentID;
+      });
+    },
+    moveUp: function() {
+      // Things are moved after another thing, or to the top without one
+      var siblings = this.siblings();
+      var index = _.indexOf(siblings, this.model);
+      if (index < 1) {return;}
+      var after = siblings[index - 2];
+      this.request('move', {id: this.model.id, after_id: after ? after.id : 0});
+    },
+    moveDown: function() {
+      var siblings = this.siblings();
+      var after = siblings[_.indexOf(siblings, this.model) + 1];
+      if (!after) {return;}
+      this.request('move', {id: this.model.id, after_id: after.id});
+    },
+    indent: function() {
+      // The previous sibling becomes the parent
+      var siblings = this.siblings();
+      var parent = siblings[_.indexOf(siblings, this.model) - 1];
+      if (!parent) {return;}
+      this.request('reparent', {id: this.model.id, parent_id: parent.id});
+    },
+    outdent: function() {
+      var parent = this.model.collection.get(this.model.get('parent_id'));
+      if (!parent) {return;}
+      this.request('reparent', {id: this.model.id, parent_id: parent.get('parent_id') || 0});
+    },
+    toggleCollapsed: function() {
+      this.request('collapse', {id: this.model.id, collapsed: !this.model.get('collapsed')});
+    },
+    toggleCompleted: function() {
+      this.request('toggle', {id: this.model.id, completed: !this.model.get('completed')});
+    },
+    addTag: function() {
+      var tag = $.trim(window.prompt('Tag') || '');
+      if (!tag) {return;}
+      this.request('tag', {id: this.model.id, tag: tag});
+    },
+    schedule: function() {
+      // Things are assigned to whoever sets their due date
+      var due = window.prompt('Due (YYYY-MM-DD HH:MM), blank to clear', '');
+      if (due === null) {return;}
+      var dueAt = $.trim(due) ? new Date($.trim(due).replace(' ', 'T')) : null;
+      if (dueAt && isNaN(dueAt.getTime())) {
+        $('#errors').prepend(new Error({message: 'Invalid due date'}).el);
+        return;
+      }
+      this.request('schedule', {
+        id: this.model.id,
+        due_at: dueAt ? dueAt.toISOString() : null,
+        assignee_id: dueAt ? $('#main').data('user') : 0
+      });
+    },
+    showComments: function() {
+      this.model.collection.trigger('thread', this.model);
+    },
+    removeTag: function(e) {
+      this.request('untag', {id: this.model.id, tag: $(e.currentTarget).data('tag')});
+    },
+    request: function(method, content) {
+      // The server replies with the things that changed
+      var collection = this.model.collection;
+      this.model.sync(method, this.model, {
+        resource: 'things',
+        content: content,
+        success: function(changed) {
+          collection.add(changed, {merge: true});
+          collection.sort();
+        }
+      });
+    },
     deleteItem: function() {
       // Delete the item, but wait for the server to respond
       this.model.destroy({wait: true});
@@ -186,20 +495,249 @@ var ListOfThings = function() {
       if (!name) {
         $('#errors').prepend(new Error({message: 'Empty items cannot be saved'}).el);
         // Re-render the original template
-        this.$el.html(this.template(this.model.toJSON()));
+        this.render();
         return;
       }
 
       // If name equals the old name, just re-render
       if (name === this.model.get('name')) {
-        this.$el.html(this.template(this.model.toJSON()));
+        this.render();
         return;
       }
 
-      this.model.set('name', name);
-      this.model.save();
+      // Optimistically rename, but roll back if the server rejects it
+      var previous = this.model.get('name');
+      var model = this.model;
+      this.model.save({name: name}, {
+        error: function() {model.set('name', previous);}
+      });
+    },
+    render: function() {
+      this.$el.css('margin-left', (this.depth * 20) + 'px');
+      this.$el.html(this.template(_.extend({hasChildren: this.hasChildren, tags: [], comment_count: 0, due_at: null}, this.model.toJSON())));
+      return this;
+    }
+  });
 
-      // TODO re-render the original model only on success
+  var SearchView = Backbone.View.extend({
+    el: '#search',
+    template: _.template('<li><strong><%- thing.name %></strong> <span><%= snippet %></span></li>'),
+    events: {
+      'keyup #search-query': 'onKeyup',
+    },
+    initialize: function() {
+      // Wait for a pause in typing before searching
+      this.debouncedSearch = _.debounce(this.search.bind(this), 300);
+    },
+    onKeyup: function() {
+      this.debouncedSearch();
+    },
+    search: function() {
+      var query = $.trim(this.$('#search-query').val());
+      if (!query) {
+        this.render([]);
+        return;
+      }
+      // Snippets are escaped by the server, with matches in <mark> tags
+      this.collection.sync('search', this.collection, {
+        resource: 'things',
+        content: {query: query},
+        success: this.render.bind(this)
+      });
+    },
+    render: function(results) {
+      var $list = this.$('#search-results');
+      $list.empty();
+      _.each(results, function(result) {
+        $list.append(this.template(result));
+      }, this);
+      return this;
+    }
+  });
+
+  var CommentList = Backbone.View.extend({
+    el: '#comments',
+    template: _.template('<li><strong><%- author %></strong> <%- body %><% if (mine) { %> <span class="edit-comment" data-id="<%- id %>"><small>edit</small></span><span class="delete-comment" data-id="<%- id %>"><small>delete</small></span><% } %></li>'),
+    events: {
+      'click #comment': 'createComment',
+      'click .edit-comment': 'editComment',
+      'click .delete-comment': 'deleteComment',
+    },
+    initialize: function(options) {
+      this.things = options.things;
+      this.listenTo(this.things, 'thread', this.open);
+      this.listenTo(this.collection, 'reset add remove change', this.render);
+    },
+    open: function(thing) {
+      // Only the comments of one thing are shown at a time
+      this.thing = thing;
+      this.collection.thingID = thing.id;
+      this.collection.sync('read', this.collection, {
+        resource: 'comments',
+        content: {thing_id: thing.id},
+        success: this.collection.reset.bind(this.collection)
+      });
+      this.$el.show();
+    },
+    createComment: function() {
+      var body = $.trim(this.$('#comment-body').val());
+      if (!body || !this.thing) {return;}
+      this.$('#comment-body').val('');
+      this.request('create', {thing_id: this.thing.id, body: body});
+    },
+    editComment: function(e) {
+      var comment = this.collection.get($(e.currentTarget).data('id'));
+      var body = $.trim(window.prompt('Comment', comment.get('body')) || '');
+      if (!body || body === comment.get('body')) {return;}
+      this.request('update', {id: comment.id, body: body});
+    },
+    deleteComment: function(e) {
+      this.request('delete', {id: $(e.currentTarget).data('id')});
+    },
+    request: function(method, content) {
+      // Changes are applied when they are broadcast
+      this.collection.sync(method, this.collection, {resource: 'comments', content: content});
+    },
+    render: function() {
+      this.$('h4').text(this.thing ? this.thing.get('name') : '');
+      var $list = this.$('ul');
+      var userID = this.$el.closest('#main').data('user');
+      $list.empty();
+      this.collection.each(function(comment) {
+        $list.append(this.template(_.extend({mine: comment.get('user_id') === userID}, comment.toJSON())));
+      }, this);
+      return this;
+    }
+  });
+
+  var AttachmentList = Backbone.View.extend({
+    el: '#attachments',
+    template: _.template('<li><% if (thumbnail_url) { %><a href="<%- url %>"><img src="<%- thumbnail_url %>" alt="<%- name %>"></a><% } else { %><a href="<%- url %>"><%- name %></a><% } %><% if (mine) { %> <span class="delete-attachment" data-id="<%- id %>"><small>delete</small></span><% } %></li>'),
+    events: {
+      'change #attachment-file': 'upload',
+      'click .delete-attachment': 'deleteAttachment',
+    },
+    initialize: function(options) {
+      this.things = options.things;
+      this.listenTo(this.things, 'thread', this.open);
+      this.listenTo(this.collection, 'reset add remove', this.render);
+    },
+    open: function(thing) {
+      this.collection.thingID = thing.id;
+      this.collection.reset();
+      $.getJSON(ATTACHMENTS_URL, {thing: thing.id}, this.collection.reset.bind(this.collection));
+    },
+    upload: function() {
+      // Uploads are broadcast to everyone once they are saved
+      var input = this.$('#attachment-file')[0];
+      if (!input.files.length || !this.collection.thingID) {return;}
+      var data = new FormData();
+      data.append('thing', this.collection.thingID);
+      data.append('file', input.files[0]);
+      $.ajax({url: ATTACHMENTS_URL, type: 'POST', data: data, processData: false, contentType: false}).fail(this.onError);
+      input.value = '';
+    },
+    deleteAttachment: function(e) {
+      $.ajax({url: ATTACHMENTS_URL + '?id=' + $(e.currentTarget).data('id'), type: 'DELETE'}).fail(this.onError);
+    },
+    onError: function(xhr) {
+      var message = (xhr.responseJSON && xhr.responseJSON.message) || 'Could not change the attachment';
+      $('#errors').prepend(new Error({message: message}).el);
+    },
+    render: function() {
+      var $list = this.$('ul');
+      var userID = this.$el.closest('#main').data('user');
+      $list.empty();
+      this.collection.each(function(attachment) {
+        $list.append(this.template(_.extend({mine: attachment.get('user_id') === userID, thumbnail_url: ''}, attachment.toJSON())));
+      }, this);
+      return this;
+    }
+  });
+
+  var HistoryView = Backbone.View.extend({
+    el: '#history',
+    template: _.template('<li><small><%- created_at %> <%- author || "Someone" %> <%- verb %></small><% _.each(diffs, function(diff) { %> <span><%- diff.field %>: <del><%- diff.before %></del> <ins><%- diff.after %></ins></span><% }); %><% if (!latest) { %> <span class="revert" data-id="<%- id %>"><small>revert</small></span><% } %></li>'),
+    verbs: {create: 'created', update: 'updated', 'delete': 'deleted', revert: 'reverted', snapshot: 'saved'},
+    events: {
+      'click .revert': 'revert',
+    },
+    initialize: function() {
+      this.listenTo(this.collection, 'thread', this.open);
+    },
+    open: function(thing) {
+      this.thing = thing;
+      this.load();
+    },
+    load: function() {
+      // Revisions are only sent to the client that asked for them
+      this.collection.sync('history', this.collection, {
+        resource: 'things',
+        content: {id: this.thing.id},
+        success: this.render.bind(this)
+      });
+    },
+    revert: function(e) {
+      // The reverted thing is broadcast as an update
+      var collection = this.collection;
+      this.thing.sync('revert', this.thing, {
+        resource: 'things',
+        content: {id: this.thing.id, revision: $(e.currentTarget).data('id'), version: this.thing.get('version')},
+        success: function(thing) {
+          collection.add(thing, {merge: true});
+          this.load();
+        }.bind(this)
+      });
+    },
+    render: function(changes) {
+      var $list = this.$('ul');
+      $list.empty();
+      _.each(changes, function(change, i) {
+        $list.append(this.template(_.extend({latest: i === 0, verb: this.verbs[change.action] || change.action}, change)));
+      }, this);
+      return this;
+    }
+  });
+
+  var TrashList = Backbone.View.extend({
+    el: '#trash',
+    events: {
+      'click .toggle': 'toggle',
+    },
+    initialize: function() {
+      this.listenTo(this.collection, 'reset add remove sync', this.render);
+    },
+    toggle: function() {
+      var $list = this.$('ol');
+      if ($list.is(':visible')) {
+        $list.hide();
+        return;
+      }
+      $list.show();
+      if (!this.collection.loaded) {
+        var collection = this.collection;
+        collection.fetch({success: function() {collection.loaded = true;}});
+      }
+    },
+    render: function() {
+      var $list = this.$('ol');
+      $list.empty();
+      _.each(this.collection.models, function(m) {
+        $list.append(new TrashItem({model: m}).render().el);
+      }, this);
+      return this;
+    }
+  });
+
+  var TrashItem = Backbone.View.extend({
+    tagName: 'li',
+    template: _.template('<h3><%- name %> <span class="restore"><small>restore</small></span></h3>'),
+    events: {
+      'click .restore': 'restoreItem',
+    },
+    restoreItem: function() {
+      // The server broadcasts the restored thing to everyone
+      this.model.sync('restore', this.model, {resource: 'things'});
     },
     render: function() {
       this.$el.html(this.template(this.model.toJSON()));
@@ -218,15 +756,58 @@ var ListOfThings = function() {
   var Things = Backbone.Collection.extend({
     model: Thing,
     url: 'things',
-    comparator: function(m) {
-      return m.get('timestamp');
+    completion: 'all', // Either all, open or done things are shown
+    tags: subscribedTags, // If any, only things with one of these tags are shown
+    initialize: function() {
+      this.cursor = null; // Set while there are more pages of things
+      this.applied = {}; // The sequence of the last event of each thing
+    },
+    parse: function(response) {
+      return flatten(response);
+    },
+    matches: function(m) {
+      if (this.tags.length && !_.intersection(this.tags, m.get('tags') || []).length) {return false;}
+      switch (this.completion) {
+        case 'open':
+          return !m.get('completed');
+        case 'done':
+          return m.get('completed');
+      }
+      return true;
+    },
+    comparator: function(a, b) {
+      // Order by position, with ties broken by id as the server does
+      if (a.get('position') !== b.get('position')) {
+        return (a.get('position') < b.get('position')) ? -1 : 1;
+      }
+      return (a.id < b.id) ? -1 : (a.id > b.id) ? 1 : 0;
     }
   });
 
+  var Comment = Backbone.Model.extend({urlRoot: 'comments'});
+  var Comments = Backbone.Collection.extend({
+    model: Comment,
+    url: 'comments',
+    thingID: null // The thing whose comments are loaded
+  });
+
+  var Attachment = Backbone.Model.extend({urlRoot: 'attachments'});
+  var Attachments = Backbone.Collection.extend({
+    model: Attachment,
+    url: 'attachments',
+    thingID: null // The thing whose attachments are loaded
+  });
+
+  var Trash = Backbone.Collection.extend({
+    model: Thing,
+    url: 'trash',
+    loaded: false
+  });
+
   var UserList = Backbone.View.extend({
     el: '#users',
     initialize: function() {
-      this.listenTo(this.collection, 'reset add remove', this.render);
+      this.listenTo(this.collection, 'reset add remove change', this.render);
       // Render the initial state
       this.render();
     },
@@ -236,7 +817,8 @@ var ListOfThings = function() {
         // Assign colors as a mod of the user id
         var color = colors[user.get('id') % colors.length];
         // TODO Use a template
-        this.$el.append('<li><div class="user" style="background-color:' + color + '"></div></li>');
+        var seen = _.escape(user.get('last_seen'));
+        this.$el.append('<li><div class="user" title="Last seen ' + seen + '" style="background-color:' + color + '"></div></li>');
       }, this);
 
       // Add the user count